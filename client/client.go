@@ -2,91 +2,351 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"net"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
+// Exit codes, so scripts and bots can tell how the last match went.
+const (
+	exitWon     = 0 // the last match was won, or no match was played
+	exitError   = 1 // could not connect or join, or a script wait timed out
+	exitLost    = 2 // the last match was lost
+	exitPlaying = 3 // the client stopped while a match was still going on
+)
+
+// state is what the receiver learned from the server, shared with the
+// goroutine sending commands.
+type state struct {
+	mu       sync.Mutex
+	username string
+	scripted bool     // only a script waits on messages, so only then are they kept
+	unread   []string // messages not yet looked at by a script wait
+	inMatch  bool
+	result   int
+
+	// What commands can be completed with, from the server's events
+	players []string
+	matches []string
+	owned   []string // nil until the first bag event
+	parties []string
+	items   []string // IDs of the items in the bag
+	pages   bagPages
+}
+
 func main() {
-	udpAddr, err := net.ResolveUDPAddr("udp", "localhost:8080")
+	host := flag.String("host", "localhost", "server host")
+	port := flag.Int("port", 8080, "server port")
+	username := flag.String("user", "", "username to join with (asked for when empty)")
+	password := flag.String("password", "", "password of the account, set on first join")
+	script := flag.String("script", "", "read commands from this file instead of the keyboard (- for stdin)")
+	delay := flag.Duration("delay", 300*time.Millisecond, "pause after each scripted command")
+	timeout := flag.Duration("timeout", 30*time.Second, "longest a scripted wait can take")
+	fullScreen := flag.Bool("tui", false, "full-screen terminal UI with lobby, bag, battle and chat panes")
+	flag.Parse()
+
+	udpAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(*host, strconv.Itoa(*port)))
 	if err != nil {
 		fmt.Println("Error resolving UDP address:", err)
-		return
+		os.Exit(exitError)
 	}
 
 	conn, err := net.DialUDP("udp", nil, udpAddr)
 	if err != nil {
 		fmt.Println("Error connecting to server:", err)
-		return
+		os.Exit(exitError)
 	}
 	defer conn.Close()
 
 	reader := bufio.NewReader(os.Stdin)
+	if *script != "" && *username == "" {
+		fmt.Println("Error: -user is required with -script")
+		os.Exit(exitError)
+	}
 
+	st := &state{scripted: *script != ""}
 	for {
-		fmt.Print("Enter your username: ")
-		username, _ := reader.ReadString('\n')
-		username = strings.TrimSpace(username)
+		name := *username
+		if name == "" {
+			fmt.Print("Enter your username: ")
+			name, _ = reader.ReadString('\n')
+			name = strings.TrimSpace(name)
+		}
 
-		_, err = conn.Write([]byte("@join " + username))
+		join := "@join " + name
+		if *password != "" {
+			join += " " + *password
+		}
+		_, err = conn.Write([]byte(join))
 		if err != nil {
 			fmt.Println("Error joining chat:", err)
-			return
+			os.Exit(exitError)
 		}
 
 		buffer := make([]byte, 1024)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
 		n, _, err := conn.ReadFromUDP(buffer)
+		conn.SetReadDeadline(time.Time{})
 		if err != nil {
 			fmt.Println("Error reading from UDP:", err)
-			return
+			os.Exit(exitError)
 		}
 
 		response := strings.TrimSpace(string(buffer[:n]))
+		if joined(response, name) {
+			fmt.Println(response)
+			st.username = name
+			break
+		}
 		if response == "Invalid: Username already exists." {
 			fmt.Println("Username already used, please choose another!")
 		} else {
 			fmt.Println(response)
-			break
+		}
+		if *username != "" {
+			os.Exit(exitError)
 		}
 	}
 
-	fmt.Print("Joined the game!\nUsages:\n" +
-		"1.Open your pokedex\n" +
-		"2.Catch random 4 Pokemon\n" +
-		"3.List the players\n" +
-		"4.Invite player to join the battle\n" +
-		"5.Quit the game\n" +
-		"Enter your choice: ")
+	if *fullScreen && *script == "" {
+		os.Exit(runTUI(conn, st))
+	}
 
-	go receiveMessages(conn)
+	fmt.Println("Joined the game!")
+	if *script != "" {
+		go receiveMessages(conn, st, func(message string) { fmt.Println(message) })
+		code := runScript(conn, st, *script, *delay, *timeout)
+		quit(conn)
+		os.Exit(code)
+	}
+	os.Exit(runPrompt(conn, st, reader))
+}
 
-	for {
-		text, _ := reader.ReadString('\n')
-		text = strings.TrimSpace(text)
-		_, err := conn.Write([]byte(text))
+// runScript sends the commands of a script file one line at a time. Blank
+// lines and lines starting with # are skipped, and two directives control
+// the pace:
+//
+//	sleep <duration>   pause, e.g. sleep 2s
+//	wait <text>        pause until a message containing text arrives
+//
+// It returns the exit code once the script ends.
+func runScript(conn *net.UDPConn, st *state, fileName string, delay, timeout time.Duration) int {
+	in := os.Stdin
+	if fileName != "-" {
+		file, err := os.Open(fileName)
 		if err != nil {
-			fmt.Println("Error sending message:", err)
-			return
+			fmt.Println("Error opening script:", err)
+			return exitError
 		}
-		if strings.Split(text, " ")[0] == "@battle" {
-			break
+		defer file.Close()
+		in = file
+	}
+
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if arg, ok := strings.CutPrefix(line, "sleep "); ok {
+			d, err := time.ParseDuration(strings.TrimSpace(arg))
+			if err != nil {
+				fmt.Println("Error in script:", err)
+				return exitError
+			}
+			time.Sleep(d)
+			continue
+		}
+		if text, ok := strings.CutPrefix(line, "wait "); ok {
+			if !st.waitFor(strings.TrimSpace(text), timeout) {
+				fmt.Printf("Error in script: no message containing %q after %s\n", text, timeout)
+				return exitError
+			}
+			continue
 		}
+
+		fmt.Println("> " + line)
+		wire, local, err := parseCommand(line, st)
+		if err != nil {
+			fmt.Println("Error in script:", err)
+			return exitError
+		}
+		if local != "" {
+			fmt.Println(local)
+			continue
+		}
+		if _, err := conn.Write([]byte(wire)); err != nil {
+			fmt.Println("Error sending message:", err)
+			return exitError
+		}
+		time.Sleep(delay)
 	}
+	if err := scanner.Err(); err != nil {
+		fmt.Println("Error reading script:", err)
+		return exitError
+	}
+	return st.exitCode()
 }
 
-func receiveMessages(conn *net.UDPConn) {
-	buffer := make([]byte, 1024)
+// joined reports whether the reply to @join is the server's welcome. Every
+// other reply, an error like an unreadable save included, means we are not in.
+func joined(response, name string) bool {
+	return response == "["+name+"] Welcome to the POKEMON game!"
+}
+
+// quit frees the username on the server. UDP has no disconnect, so without
+// it the name stays taken after the client is gone.
+func quit(conn *net.UDPConn) {
+	conn.Write([]byte("5"))
+}
+
+// receiveMessages hands every message from the server to show. Events are
+// never split, so the buffer fits the largest datagram.
+func receiveMessages(conn *net.UDPConn, st *state, show func(message string)) {
+	buffer := make([]byte, 65536)
 	for {
 		n, _, err := conn.ReadFromUDP(buffer)
 		if err != nil {
-			fmt.Println("Error receiving message:", err)
+			show("Error receiving message: " + err.Error())
 			return
 		}
-		fmt.Println(string(buffer[:n]))
-		if string(buffer[:n]) == "You are out of the game" {
-			os.Exit(0)
-			break
+		message := string(buffer[:n])
+		show(message)
+		st.record(message)
+		if message == "You are out the game" {
+			leave(st.exitCode())
+		}
+	}
+}
+
+// record keeps the message for a script and follows the match the player is in.
+// Spectated matches are prefixed with [Match n] and never match.
+func (st *state) record(message string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.scripted {
+		st.unread = append(st.unread, message)
+	}
+
+	switch {
+	case strings.HasPrefix(message, "@event "):
+		st.learn(message)
+	case strings.HasPrefix(message, "["):
+		// Chat and spectated matches never change the player's own match
+	case strings.HasPrefix(message, "You are join the battle!"),
+		strings.Contains(message, " has accepted the battle"),
+		strings.HasPrefix(message, "You are battling "),
+		strings.HasPrefix(message, "Match found! You are battling "):
+		st.inMatch = true
+	case message == "Game over! You win!", message == "Game over! "+st.username+" wins!":
+		st.inMatch = false
+		st.result = exitWon
+	case message == "Game over! You lose!":
+		st.inMatch = false
+		st.result = exitLost
+	}
+}
+
+// learn keeps the names and IDs from lobby and bag events for completion.
+func (st *state) learn(message string) {
+	kind, data, _ := strings.Cut(strings.TrimPrefix(message, "@event "), " ")
+	switch kind {
+	case "lobby":
+		var lobby LobbyEvent
+		if json.Unmarshal([]byte(data), &lobby) != nil {
+			return
+		}
+		st.players, st.matches = nil, nil
+		for _, p := range lobby.Players {
+			if p.Name != st.username {
+				st.players = append(st.players, p.Name)
+			}
+		}
+		for _, m := range lobby.Matches {
+			st.matches = append(st.matches, strconv.Itoa(m.ID))
+		}
+	case "bag":
+		var page BagEvent
+		if json.Unmarshal([]byte(data), &page) != nil {
+			return
+		}
+		bag, whole := st.pages.add(page)
+		if !whole {
+			return
+		}
+		st.owned = []string{}
+		for _, poke := range bag.Pokemon {
+			st.owned = append(st.owned, poke.ID)
+		}
+		st.parties = bag.Parties
+		st.items = nil
+		for id, n := range bag.Items {
+			if n > 0 {
+				st.items = append(st.items, id)
+			}
+		}
+	}
+}
+
+// ownedIDs returns the IDs in the bag, or nil when the bag is not known.
+func (st *state) ownedIDs() []string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.owned
+}
+
+// candidates lists the values a command argument can be completed with.
+func (st *state) candidates(a arg) []string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	switch a.kind {
+	case argChoice:
+		return a.choices
+	case argPlayer:
+		return st.players
+	case argPokemon:
+		return st.owned
+	case argParty:
+		return st.parties
+	case argItem:
+		return st.items
+	case argMatch:
+		return append([]string{"leave"}, st.matches...)
+	}
+	return nil
+}
+
+func (st *state) exitCode() int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.inMatch {
+		return exitPlaying
+	}
+	return st.result
+}
+
+// waitFor blocks until a message received since the last wait contains
+// text, or the timeout runs out.
+func (st *state) waitFor(text string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		st.mu.Lock()
+		for len(st.unread) > 0 {
+			message := st.unread[0]
+			st.unread = st.unread[1:]
+			if strings.Contains(message, text) {
+				st.mu.Unlock()
+				return true
+			}
 		}
+		st.mu.Unlock()
+		time.Sleep(50 * time.Millisecond)
 	}
+	return false
 }
@@ -0,0 +1,99 @@
+package main
+
+import "testing"
+
+func TestRecordFollowsTheMatch(t *testing.T) {
+	for _, message := range []string{
+		"You are join the battle!",
+		"bob has accepted the battle",
+		"You are battling the CPU",
+		"Match found! You are battling bob (1500) in a rated match.",
+	} {
+		st := &state{username: "alice"}
+		st.record(message)
+		if !st.inMatch {
+			t.Errorf("%q did not start a match", message)
+		}
+	}
+	st := &state{username: "alice", inMatch: true}
+	st.record("[Match 3] You are battling bob")
+	st.record("Game over! You lose!")
+	if st.inMatch || st.result != exitLost {
+		t.Errorf("after a loss: in match %v, result %d", st.inMatch, st.result)
+	}
+}
+
+func TestRecordKeepsMessagesOnlyForScripts(t *testing.T) {
+	st := &state{}
+	for i := 0; i < 100; i++ {
+		st.record("hello")
+	}
+	if len(st.unread) != 0 {
+		t.Errorf("kept %d messages without a script", len(st.unread))
+	}
+	st.scripted = true
+	st.record("hello")
+	if len(st.unread) != 1 {
+		t.Errorf("kept %d messages for a script, want 1", len(st.unread))
+	}
+}
+
+func bagPage(page, pages int, ids ...string) BagEvent {
+	event := BagEvent{Page: page, Pages: pages, Coins: page}
+	for _, id := range ids {
+		event.Pokemon = append(event.Pokemon, BagEntry{ID: id})
+	}
+	return event
+}
+
+func TestBagPages(t *testing.T) {
+	var b bagPages
+	if _, whole := b.add(bagPage(1, 3, "#1", "#2")); whole {
+		t.Fatal("whole after page 1 of 3")
+	}
+	b.add(bagPage(2, 3, "#3"))
+	bag, whole := b.add(bagPage(3, 3, "#4"))
+	if !whole || len(bag.Pokemon) != 4 || bag.Coins != 1 {
+		t.Fatalf("whole %v, %d Pokémon, %d coins", whole, len(bag.Pokemon), bag.Coins)
+	}
+
+	// A lost page drops the bag until the next one
+	b.add(bagPage(1, 3, "#1"))
+	if _, whole := b.add(bagPage(3, 3, "#3")); whole {
+		t.Error("whole without page 2")
+	}
+	if bag, whole := b.add(bagPage(1, 1, "#9")); !whole || len(bag.Pokemon) != 1 {
+		t.Errorf("a one page bag: whole %v, %d Pokémon", whole, len(bag.Pokemon))
+	}
+	// Servers from before pages send the bag in one event
+	if _, whole := b.add(BagEvent{}); !whole {
+		t.Error("an unpaged bag was not whole")
+	}
+}
+
+func TestRecordLearnsAPagedBag(t *testing.T) {
+	st := &state{}
+	st.record(`@event bag {"Page":1,"Pages":2,"Pokemon":[{"ID":"#0001"}],"Parties":["main"]}`)
+	if st.ownedIDs() != nil {
+		t.Fatal("learned half a bag")
+	}
+	st.record(`@event bag {"Page":2,"Pages":2,"Pokemon":[{"ID":"#0004"}]}`)
+	if got := st.ownedIDs(); len(got) != 2 || len(st.parties) != 1 {
+		t.Errorf("owned %v, parties %v", got, st.parties)
+	}
+}
+
+func TestJoinedOnlyOnTheWelcome(t *testing.T) {
+	if !joined("[alice] Welcome to the POKEMON game!", "alice") {
+		t.Error("the welcome was not a join")
+	}
+	for _, response := range []string{
+		"Your save could not be read! Please try again later.",
+		"Invalid: Wrong password.",
+		"[bob] Welcome to the POKEMON game!",
+	} {
+		if joined(response, "alice") {
+			t.Errorf("%q counted as a join", response)
+		}
+	}
+}
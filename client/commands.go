@@ -0,0 +1,465 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The client owns the command grammar: players type readable commands,
+// the client checks them and sends the server's own tokens. The server's
+// bare tokens (1-5, p) still work as aliases.
+
+type argKind int
+
+const (
+	argWord    argKind = iota // any single word
+	argPlayer                 // another player online
+	argPokemon                // a Pokémon ID from the bag, like #0001
+	argParty                  // one of the player's parties
+	argMatch                  // a live match ID
+	argItem                   // an item in the bag, like potion
+	argNumber
+	argChoice
+)
+
+type arg struct {
+	name     string
+	kind     argKind
+	choices  []string // for argChoice
+	optional bool
+	repeat   bool // takes every remaining word
+	notSelf  bool // for argPlayer: cannot be the player's own name
+}
+
+type command struct {
+	name    string   // what the player types, one or two words
+	aliases []string // the server's bare tokens
+	wire    string   // sent in place of the name
+	args    []arg
+	help    string
+}
+
+var commands = []command{
+	{name: "bag", aliases: []string{"1"}, wire: "1",
+		help: "Open your Pokédex."},
+	{name: "bag items", wire: "1 items",
+		help: "Show your coins and items."},
+	{name: "give", wire: "give",
+		args: []arg{{name: "item", kind: argItem}, {name: "#id", kind: argPokemon}},
+		help: "Let a Pokémon hold an item from your bag, like leftovers or a choice-band."},
+	{name: "take", wire: "take",
+		args: []arg{{name: "#id", kind: argPokemon}},
+		help: "Take a Pokémon's held item back to your bag."},
+	{name: "shop", wire: "shop",
+		help: "List what the shop sells and the prices."},
+	{name: "buy", wire: "buy",
+		args: []arg{{name: "item", kind: argWord}, {name: "qty", kind: argNumber, optional: true}},
+		help: "Buy items from the shop, one unless you give a quantity."},
+	{name: "use", wire: "use",
+		args: []arg{{name: "item", kind: argItem}, {name: "#id", kind: argPokemon, optional: true}},
+		help: "Use an item. Medicine works in battle and takes your turn, on your active Pokémon unless you give one. Stones and rare candies work outside battles."},
+	{name: "roll", aliases: []string{"2"}, wire: "2",
+		help: "Catch random Pokémon with a Poké Ball."},
+	{name: "players", aliases: []string{"3"}, wire: "3",
+		help: "List the players online."},
+	{name: "invite", aliases: []string{"4"}, wire: "4",
+		args: []arg{{name: "player", kind: argPlayer, notSelf: true}, {name: "rated|unrated", kind: argChoice, choices: []string{"rated", "unrated"}, optional: true},
+			{name: "singles|doubles", kind: argChoice, choices: []string{"singles", "doubles"}, optional: true}, {name: "rules", kind: argWord, optional: true}},
+		help: "Invite a player to battle. They answer with accept yes|no. Unrated matches are only for friends. A double battle needs 2 Pokémon each. rules names the rule set, see rules."},
+	{name: "quit", aliases: []string{"5"}, wire: "5",
+		help: "Quit the game."},
+	{name: "accept", wire: "accept",
+		args: []arg{{name: "yes|no", kind: argChoice, choices: []string{"yes", "no"}}},
+		help: "Answer a battle invitation."},
+	{name: "pick", aliases: []string{"p"}, wire: "p",
+		args: []arg{{name: "#id1", kind: argPokemon}, {name: "#id2", kind: argPokemon}, {name: "#id3", kind: argPokemon}},
+		help: "Pick three Pokémon for the next battle instead of your active party."},
+	{name: "start", wire: "start",
+		help: "Start the battle once the invitation was accepted."},
+	{name: "attack", wire: "attack",
+		args: []arg{{name: "normal|special|random", kind: argChoice, choices: []string{"normal", "special", "random"}, optional: true},
+			{name: "target", kind: argChoice, choices: []string{"1", "2", "ally", "foes", "all"}, optional: true}},
+		help: "Attack the opponent's Pokémon. Without a kind one is picked at random. In a double battle pick opponent 1 or 2, your ally, both foes or all at less damage each."},
+	{name: "move", wire: "move",
+		args: []arg{{name: "field move", kind: argChoice, choices: []string{"rain-dance", "sunny-day", "sandstorm", "snowscape",
+			"electric-terrain", "grassy-terrain", "psychic-terrain", "misty-terrain"}}},
+		help: "Use a weather or terrain move. Only Pokémon of the move's type can."},
+	{name: "switch", wire: "switch",
+		args: []arg{{name: "#id", kind: argPokemon}, {name: "slot", kind: argChoice, choices: []string{"1", "2"}, optional: true}},
+		help: "Send in another Pokémon of your team. Takes your turn unless yours fainted. In a double battle the slot picks which fainted one it replaces."},
+	{name: "surrender", aliases: []string{"forfeit"}, wire: "surrender",
+		help: "Give up the battle."},
+	{name: "battle cpu", wire: "battle cpu",
+		args: []arg{{name: "easy|normal|hard", kind: argChoice, choices: []string{"easy", "normal", "hard"}, optional: true},
+			{name: "singles|doubles", kind: argChoice, choices: []string{"singles", "doubles"}, optional: true}},
+		help: "Battle a CPU trainer with your active party or picked team."},
+	{name: "party list", wire: "party list",
+		help: "List your parties."},
+	{name: "party create", wire: "party create",
+		args: []arg{{name: "name", kind: argWord}, {name: "size", kind: argNumber, optional: true}},
+		help: "Create a party of 1 to 6 Pokémon (3 by default)."},
+	{name: "party add", wire: "party add",
+		args: []arg{{name: "party", kind: argParty}, {name: "#id", kind: argPokemon}},
+		help: "Add a Pokémon to a party."},
+	{name: "party remove", wire: "party remove",
+		args: []arg{{name: "party", kind: argParty}, {name: "#id", kind: argPokemon}},
+		help: "Remove a Pokémon from a party."},
+	{name: "party reorder", wire: "party reorder",
+		args: []arg{{name: "party", kind: argParty}, {name: "#id", kind: argPokemon, repeat: true}},
+		help: "Put every Pokémon of a party in a new order."},
+	{name: "party lead", wire: "party lead",
+		args: []arg{{name: "party", kind: argParty}, {name: "#id", kind: argPokemon}},
+		help: "Choose the Pokémon a party sends out first."},
+	{name: "party size", wire: "party size",
+		args: []arg{{name: "party", kind: argParty}, {name: "size", kind: argNumber}},
+		help: "Change how many Pokémon a party holds."},
+	{name: "party use", wire: "party use",
+		args: []arg{{name: "party", kind: argParty}},
+		help: "Battle with this party from now on."},
+	{name: "party delete", wire: "party delete",
+		args: []arg{{name: "party", kind: argParty}},
+		help: "Delete a party."},
+	{name: "friends", wire: "friends",
+		help: "List your friends, where they are and your friend requests."},
+	{name: "friend add", wire: "friend add",
+		args: []arg{{name: "player", kind: argPlayer, notSelf: true}},
+		help: "Send a friend request, or accept theirs."},
+	{name: "friend accept", wire: "friend accept",
+		args: []arg{{name: "player", kind: argWord}},
+		help: "Accept a friend request."},
+	{name: "friend decline", wire: "friend decline",
+		args: []arg{{name: "player", kind: argWord}},
+		help: "Decline a friend request."},
+	{name: "friend remove", wire: "friend remove",
+		args: []arg{{name: "player", kind: argWord}},
+		help: "Remove a friend, or take back a request you sent."},
+	{name: "queue", wire: "queue",
+		args: []arg{{name: "ranked|casual|leave|stats", kind: argChoice, choices: []string{"ranked", "casual", "leave", "stats"}}, {name: "rules", kind: argWord, optional: true}},
+		help: "Find an opponent near your rating who plays the same rules, or leave the queue. stats is for admins."},
+	{name: "rules", wire: "rules",
+		args: []arg{{name: "name", kind: argWord, optional: true}},
+		help: "List the rule sets, or show the rules of one: level, team size, clauses and banned Pokémon."},
+	{name: "ladder", wire: "ladder",
+		args: []arg{{name: "n", kind: argNumber, optional: true}},
+		help: "Show the top players of the ladder (10 by default)."},
+	{name: "profile", wire: "profile",
+		args: []arg{{name: "player", kind: argPlayer, optional: true}},
+		help: "Show a player's rating, record and rating history. Without a name shows yours."},
+	{name: "tournament status", wire: "tournament status",
+		help: "Show the tournament: standings and the pairings of the round."},
+	{name: "tournament join", wire: "tournament join",
+		help: "Register for the tournament."},
+	{name: "tournament leave", wire: "tournament leave",
+		help: "Take back your registration before the tournament starts."},
+	{name: "tournament create", wire: "tournament create",
+		args: []arg{{name: "name", kind: argWord}, {name: "single|double|swiss", kind: argChoice, choices: []string{"single", "double", "swiss"}}, {name: "rounds", kind: argNumber, optional: true},
+			{name: "rules", kind: argWord, optional: true}},
+		help: "Open a tournament for registration. rounds is for Swiss only, rules names the rule set of its matches. Admins only."},
+	{name: "tournament start", wire: "tournament start",
+		help: "Close registration and seed the bracket by rating. Admins only."},
+	{name: "tournament forfeit", wire: "tournament forfeit",
+		args: []arg{{name: "player", kind: argWord}},
+		help: "Give a player's match of the round to their opponent. Admins only."},
+	{name: "tournament cancel", wire: "tournament cancel",
+		help: "Stop the tournament. Admins only."},
+	{name: "matches", wire: "matches",
+		help: "List the live matches."},
+	{name: "spectate", wire: "spectate",
+		args: []arg{{name: "matchID|leave", kind: argMatch}},
+		help: "Watch a live match, or stop watching with spectate leave."},
+	{name: "replay", wire: "replay",
+		args: []arg{{name: "matchID", kind: argNumber}},
+		help: "Play a finished match again."},
+	{name: "/say", aliases: []string{"say"}, wire: "/say",
+		args: []arg{{name: "message", kind: argWord, repeat: true}},
+		help: "Talk to everyone online."},
+	{name: "/w", aliases: []string{"whisper"}, wire: "/w",
+		args: []arg{{name: "player", kind: argPlayer, notSelf: true}, {name: "message", kind: argWord, repeat: true}},
+		help: "Whisper to one player."},
+	{name: "/m", wire: "/m",
+		args: []arg{{name: "message", kind: argWord, repeat: true}},
+		help: "Talk to the players and spectators of your match."},
+	{name: "/mute", wire: "/mute",
+		args: []arg{{name: "player", kind: argPlayer, notSelf: true, optional: true}},
+		help: "Hide a player's chat, kept with your profile. Without a name lists who is muted."},
+	{name: "/unmute", wire: "/unmute",
+		args: []arg{{name: "player", kind: argWord}},
+		help: "Show a muted player's chat again."},
+	{name: "events", wire: "events",
+		args: []arg{{name: "on|off", kind: argChoice, choices: []string{"on", "off"}}},
+		help: "Turn the structured events for graphical clients on or off."},
+	{name: "help",
+		args: []arg{{name: "command", kind: argWord, optional: true, repeat: true}},
+		help: "Show the commands, or the details of one."},
+}
+
+func (c command) usage() string {
+	usage := c.name
+	for _, a := range c.args {
+		name := a.name
+		if a.repeat {
+			name += "..."
+		}
+		if a.optional {
+			name = "[" + name + "]"
+		} else if a.kind != argChoice || len(a.choices) == 0 {
+			name = "<" + name + ">"
+		}
+		usage += " " + name
+	}
+	return usage
+}
+
+// findCommand returns the command the words start with and how many words
+// its name took.
+func findCommand(words []string) (*command, int) {
+	if len(words) == 0 {
+		return nil, 0
+	}
+	first := strings.ToLower(words[0])
+	for i := range commands {
+		c := &commands[i]
+		name := strings.Fields(c.name)
+		if len(name) == 2 && len(words) >= 2 && first == name[0] && strings.ToLower(words[1]) == name[1] {
+			return c, 2
+		}
+	}
+	for i := range commands {
+		c := &commands[i]
+		if first == c.name {
+			return c, 1
+		}
+		for _, alias := range c.aliases {
+			if first == alias {
+				return c, 1
+			}
+		}
+	}
+	return nil, 0
+}
+
+// parseCommand checks a line against the grammar and returns what to send
+// to the server. help gives its text in local and sends nothing.
+func parseCommand(line string, st *state) (wire, local string, err error) {
+	words := strings.Fields(line)
+	if len(words) == 0 {
+		return "", "", nil
+	}
+	c, n := findCommand(words)
+	if c == nil {
+		if group := commandGroup(words[0]); len(group) > 0 {
+			return "", "", fmt.Errorf("%s needs a subcommand: %s", words[0], strings.Join(group, ", "))
+		}
+		return "", "", fmt.Errorf("unknown command %q, type help to see the commands", words[0])
+	}
+	args := words[n:]
+	if c.name == "help" {
+		return "", helpText(strings.Join(args, " ")), nil
+	}
+	if err := c.check(args, st); err != nil {
+		return "", "", fmt.Errorf("%v\nUsage: %s", err, c.usage())
+	}
+	bound, _ := c.bind(args, st)
+	for i, a := range bound {
+		if a != nil && a.kind == argChoice {
+			args[i] = strings.ToLower(args[i])
+		}
+	}
+	return strings.TrimSpace(c.wire + " " + strings.Join(args, " ")), "", nil
+}
+
+// commandGroup lists the subcommands of a two-word command like party.
+func commandGroup(word string) []string {
+	var subs []string
+	for _, c := range commands {
+		name := strings.Fields(c.name)
+		if len(name) == 2 && name[0] == strings.ToLower(word) {
+			subs = append(subs, name[1])
+		}
+	}
+	return subs
+}
+
+// bind pairs each word with the argument it fills, nil for a word too
+// many. An optional argument the word does not fit is skipped when a later
+// one fits it, so "attack 2" picks a target and "invite bob doubles" a
+// format. next is the index of the first argument no word reached.
+func (c command) bind(args []string, st *state) (bound []*arg, next int) {
+	for _, value := range args {
+		if next == len(c.args) && next > 0 && c.args[next-1].repeat {
+			bound = append(bound, &c.args[next-1])
+			continue
+		}
+		for next < len(c.args) && c.args[next].optional && c.args[next].check(value, st) != nil && c.fitsAfter(next, value, st) {
+			next++
+		}
+		if next == len(c.args) {
+			bound = append(bound, nil)
+			continue
+		}
+		bound = append(bound, &c.args[next])
+		next++
+	}
+	return bound, next
+}
+
+// fitsAfter reports whether an argument after i that can be reached by
+// skipping optional ones takes the word.
+func (c command) fitsAfter(i int, value string, st *state) bool {
+	for j := i + 1; j < len(c.args); j++ {
+		if c.args[j].check(value, st) == nil {
+			return true
+		}
+		if !c.args[j].optional {
+			return false
+		}
+	}
+	return false
+}
+
+func (c command) check(args []string, st *state) error {
+	bound, next := c.bind(args, st)
+	for _, a := range c.args[next:] {
+		if !a.optional {
+			return fmt.Errorf("missing %s", a.name)
+		}
+	}
+	for i, value := range args {
+		if bound[i] == nil {
+			return fmt.Errorf("too many arguments for %s", c.name)
+		}
+		if err := bound[i].check(value, st); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a arg) check(value string, st *state) error {
+	switch a.kind {
+	case argChoice:
+		for _, choice := range a.choices {
+			if strings.EqualFold(value, choice) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%q is not one of %s", value, strings.Join(a.choices, ", "))
+	case argNumber:
+		if _, err := strconv.Atoi(value); err != nil {
+			return fmt.Errorf("%s must be a number, got %q", a.name, value)
+		}
+	case argMatch:
+		if _, err := strconv.Atoi(value); err != nil && value != "leave" {
+			return fmt.Errorf("%q is not a match ID", value)
+		}
+	case argPokemon:
+		if !strings.HasPrefix(value, "#") {
+			return fmt.Errorf("%q is not a Pokémon ID like #0001", value)
+		}
+		if owned := st.ownedIDs(); owned != nil && !contains(owned, value) {
+			return fmt.Errorf("%s is not in your bag", value)
+		}
+	case argPlayer:
+		if a.notSelf && value == st.username {
+			return fmt.Errorf("you cannot pick yourself")
+		}
+	}
+	return nil
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// helpText lists the commands, or explains one.
+func helpText(topic string) string {
+	if topic == "" {
+		text := "Commands (help <command> for details, Tab completes):\n"
+		for _, c := range commands {
+			text += fmt.Sprintf("  %-34s %s\n", c.usage(), c.help)
+		}
+		return strings.TrimRight(text, "\n")
+	}
+	c, _ := findCommand(strings.Fields(topic))
+	if c == nil {
+		if group := commandGroup(topic); len(group) > 0 {
+			var text []string
+			for _, sub := range group {
+				sc, _ := findCommand([]string{topic, sub})
+				text = append(text, fmt.Sprintf("  %-34s %s", sc.usage(), sc.help))
+			}
+			return strings.Join(text, "\n")
+		}
+		return fmt.Sprintf("No command %q, type help to see them all.", topic)
+	}
+	text := "Usage: " + c.usage() + "\n" + c.help
+	if len(c.aliases) > 0 {
+		text += "\nAlso: " + strings.Join(c.aliases, ", ")
+	}
+	return text
+}
+
+// completions returns the words that can replace the last word of the
+// line: command names first, then the arguments they take.
+func completions(line string, st *state) []string {
+	words := strings.Fields(line)
+	if len(words) == 0 || !strings.HasSuffix(line, " ") {
+		// The last word is still being typed
+		if len(words) > 0 {
+			words = words[:len(words)-1]
+		}
+	}
+	prefix := ""
+	if !strings.HasSuffix(line, " ") && line != "" {
+		fields := strings.Fields(line)
+		prefix = fields[len(fields)-1]
+	}
+
+	var candidates []string
+	switch {
+	case len(words) == 0:
+		for _, c := range commands {
+			candidates = append(candidates, strings.Fields(c.name)[0])
+		}
+	case len(words) == 1 && len(commandGroup(words[0])) > 0:
+		candidates = commandGroup(words[0])
+	default:
+		c, n := findCommand(words)
+		if c == nil {
+			return nil
+		}
+		if c.name == "help" {
+			for _, other := range commands {
+				candidates = append(candidates, strings.Fields(other.name)[0])
+			}
+			break
+		}
+		// The next word fills any optional argument up to a required one
+		_, next := c.bind(words[n:], st)
+		if next == len(c.args) && next > 0 && c.args[next-1].repeat {
+			next--
+		}
+		for _, a := range c.args[next:] {
+			candidates = append(candidates, st.candidates(a)...)
+			if !a.optional {
+				break
+			}
+		}
+	}
+
+	var matches []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(strings.ToLower(candidate), strings.ToLower(prefix)) && !contains(matches, candidate) {
+			matches = append(matches, candidate)
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseCommandOptionalArguments(t *testing.T) {
+	st := &state{username: "alice", players: []string{"bob"}, owned: []string{"#0001", "#0004"}}
+	tests := []struct {
+		line, wire string
+	}{
+		{"attack", "attack"},
+		{"attack special", "attack special"},
+		{"attack 2", "attack 2"},
+		{"attack ally", "attack ally"},
+		{"attack Normal Foes", "attack normal foes"},
+		{"battle cpu", "battle cpu"},
+		{"battle cpu doubles", "battle cpu doubles"},
+		{"battle cpu hard singles", "battle cpu hard singles"},
+		{"invite bob", "4 bob"},
+		{"invite bob doubles", "4 bob doubles"},
+		{"invite bob standard", "4 bob standard"},
+		{"invite bob unrated doubles flat", "4 bob unrated doubles flat"},
+		{"switch #0004 2", "switch #0004 2"},
+		{"buy potion 3", "buy potion 3"},
+		{"tournament create cup swiss standard", "tournament create cup swiss standard"},
+		{"/say hello there", "/say hello there"},
+	}
+	for _, tt := range tests {
+		wire, _, err := parseCommand(tt.line, st)
+		if err != nil || wire != tt.wire {
+			t.Errorf("%q sent %q (%v), want %q", tt.line, wire, err, tt.wire)
+		}
+	}
+}
+
+func TestParseCommandRejects(t *testing.T) {
+	st := &state{username: "alice", owned: []string{"#0001"}}
+	tests := []struct {
+		line, err string
+	}{
+		{"attack 3", `"3" is not one of`},
+		{"attack ally special", "too many arguments"},
+		{"attack special 1 2", "too many arguments"},
+		{"battle cpu doubles hard", "too many arguments"},
+		{"invite alice", "cannot pick yourself"},
+		{"invite bob rated doubles flat now", "too many arguments"},
+		{"accept maybe", `"maybe" is not one of`},
+		{"give potion", "missing #id"},
+		{"switch #0009", "not in your bag"},
+		{"buy potion lots", "must be a number"},
+	}
+	for _, tt := range tests {
+		_, _, err := parseCommand(tt.line, st)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%q gave %v, want an error with %q", tt.line, err, tt.err)
+		}
+	}
+}
+
+func TestCompletionsOfOptionalArguments(t *testing.T) {
+	st := &state{}
+	if got, want := completions("attack ", st), []string{"1", "2", "all", "ally", "foes", "normal", "random", "special"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("attack completes to %q, want %q", got, want)
+	}
+	if got, want := completions("battle cpu easy ", st), []string{"doubles", "singles"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("battle cpu easy completes to %q, want %q", got, want)
+	}
+	if got := completions("attack 1 ", st); got != nil {
+		t.Errorf("a full attack completes to %q", got)
+	}
+}
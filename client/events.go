@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The structured events the server sends after "events on", as
+// "@event <kind> <json>". They mirror the server's event types.
+
+type BagEvent struct {
+	Page        int            `json:"Page"` // from 1, 0 from servers that send one event
+	Pages       int            `json:"Pages"`
+	Pokemon     []BagEntry     `json:"Pokemon"`
+	Team        []string       `json:"Team"`
+	ActiveParty string         `json:"Active-Party"`
+	Parties     []string       `json:"Parties"`
+	Coins       int            `json:"Coins"`
+	Items       map[string]int `json:"Items"`
+}
+
+type BagEntry struct {
+	ID      string   `json:"ID"`
+	Name    string   `json:"Name"`
+	Level   int      `json:"Level"`
+	Exp     int      `json:"Exp"`
+	Types   []string `json:"Types"`
+	Hp      int      `json:"HP"`
+	Atk     int      `json:"ATK"`
+	Def     int      `json:"DEF"`
+	Speed   int      `json:"Speed"`
+	Held    string   `json:"Held"`
+	Ability string   `json:"Ability"`
+}
+
+type LobbyEvent struct {
+	Players []LobbyPlayer `json:"Players"`
+	Matches []LobbyMatch  `json:"Matches"`
+}
+
+type LobbyPlayer struct {
+	Name   string `json:"Name"`
+	Status string `json:"Status"`
+}
+
+type LobbyMatch struct {
+	ID       int    `json:"ID"`
+	Player1  string `json:"Player1"`
+	Player2  string `json:"Player2"`
+	Watching int    `json:"Watching"`
+}
+
+type BattleEvent struct {
+	Match      int        `json:"Match"`
+	Format     string     `json:"Format"` // singles or doubles
+	YourTurn   bool       `json:"Your-Turn"`
+	MustSwitch bool       `json:"Must-Switch"`
+	Slot       int        `json:"Slot"` // your slot that acts or waits for a replacement
+	Over       bool       `json:"Over"`
+	Won        bool       `json:"Won"`
+	You        BattleSide `json:"You"`
+	Opponent   BattleSide `json:"Opponent"`
+	Moves      []Move     `json:"Moves"`
+	Field      Field      `json:"Field"`
+}
+
+// Field is the weather and the terrain in play, by ID.
+type Field struct {
+	Weather      string `json:"Weather"`
+	WeatherTurns int    `json:"Weather-Turns"`
+	Terrain      string `json:"Terrain"`
+	TerrainTurns int    `json:"Terrain-Turns"`
+}
+
+type BattleSide struct {
+	Player string          `json:"Player"`
+	Active int             `json:"Active"`
+	Slots  []int           `json:"Slots"` // index into Team of each Pokémon in battle
+	Team   []BattlePokemon `json:"Team"`
+	Timer  *BattleTimer    `json:"Timer"` // nil when the match has no timer
+}
+
+// BattleTimer is a player's time in seconds when the event was sent.
+type BattleTimer struct {
+	Running bool `json:"Running"`
+	Turn    int  `json:"Turn"` // 0 without a turn timer
+	Bank    int  `json:"Bank"` // 0 without a chess clock
+}
+
+// left is the time on the timer after the given seconds went by, "" when
+// there is no timer.
+func (t *BattleTimer) left(gone int) string {
+	if t == nil {
+		return ""
+	}
+	if !t.Running {
+		gone = 0
+	}
+	var parts []string
+	if t.Turn > 0 || (t.Running && t.Bank == 0) {
+		parts = append(parts, fmt.Sprintf("%ds", max(0, t.Turn-gone)))
+	}
+	if t.Bank > 0 {
+		bank := max(0, t.Bank-gone)
+		parts = append(parts, fmt.Sprintf("clock %d:%02d", bank/60, bank%60))
+	}
+	return strings.Join(parts, ", ")
+}
+
+type BattlePokemon struct {
+	ID      string   `json:"ID"`
+	Name    string   `json:"Name"`
+	Level   int      `json:"Level"`
+	Types   []string `json:"Types"`
+	Hp      int      `json:"HP"`
+	MaxHp   int      `json:"Max-HP"`
+	Status  string   `json:"Status"`
+	Item    string   `json:"Item"`    // only for your own team
+	Ability string   `json:"Ability"` // only for your own team
+	Hidden  bool     `json:"Hidden"`
+}
+
+// Move is an attack, or a field move that deals no damage.
+type Move struct {
+	Name   string `json:"Name"`
+	Damage int    `json:"Damage"`
+}
+
+// bench returns the team members that are not in battle, in team order.
+// The number keys switch to them.
+func (s BattleSide) bench() []BattlePokemon {
+	var bench []BattlePokemon
+	for i, poke := range s.Team {
+		if !s.inBattle(i) {
+			bench = append(bench, poke)
+		}
+	}
+	return bench
+}
+
+func (s BattleSide) inBattle(i int) bool {
+	if len(s.Slots) == 0 {
+		// Servers from before double battles only send Active
+		return i == s.Active
+	}
+	for _, slot := range s.Slots {
+		if slot == i {
+			return true
+		}
+	}
+	return false
+}
+
+// bagPages puts together a bag sent in pages. A page that is lost drops
+// the bag until the next one starts.
+type bagPages struct {
+	bag  BagEvent
+	next int // page expected next, 0 when none is
+}
+
+// add takes one page and returns the bag once it has all of them.
+func (b *bagPages) add(page BagEvent) (BagEvent, bool) {
+	switch {
+	case page.Page <= 1:
+		b.bag, b.next = page, 2
+	case page.Page == b.next:
+		b.bag.Pokemon = append(b.bag.Pokemon, page.Pokemon...)
+		b.next++
+	default:
+		b.next = 0
+		return BagEvent{}, false
+	}
+	if page.Page < page.Pages {
+		return BagEvent{}, false
+	}
+	b.next = 0
+	return b.bag, true
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+	"unicode"
+)
+
+// lineEditor is the command line of the raw-mode clients: Up and Down walk
+// the history and Tab completes the word being typed.
+type lineEditor struct {
+	line     []rune
+	history  []string
+	browsing int    // history index shown, len(history) when typing
+	draft    string // what was typed before browsing the history
+	complete func(line string) []string
+}
+
+const maxHistory = 100
+
+func newLineEditor(complete func(line string) []string) *lineEditor {
+	return &lineEditor{complete: complete}
+}
+
+func (e *lineEditor) String() string { return string(e.line) }
+
+func (e *lineEditor) empty() bool { return len(e.line) == 0 }
+
+func (e *lineEditor) insert(r rune) {
+	if unicode.IsPrint(r) {
+		e.line = append(e.line, r)
+	}
+}
+
+// backspace removes the last rune and reports whether there was one.
+func (e *lineEditor) backspace() bool {
+	if len(e.line) == 0 {
+		return false
+	}
+	e.line = e.line[:len(e.line)-1]
+	return true
+}
+
+func (e *lineEditor) clear() {
+	e.line = nil
+	e.browsing = len(e.history)
+}
+
+// submit returns the line and keeps it in the history.
+func (e *lineEditor) submit() string {
+	text := strings.TrimSpace(string(e.line))
+	if text != "" && (len(e.history) == 0 || e.history[len(e.history)-1] != text) {
+		e.history = append(e.history, text)
+		if len(e.history) > maxHistory {
+			e.history = e.history[1:]
+		}
+	}
+	e.clear()
+	return text
+}
+
+func (e *lineEditor) previous() {
+	if e.browsing == 0 {
+		return
+	}
+	if e.browsing == len(e.history) {
+		e.draft = string(e.line)
+	}
+	e.browsing--
+	e.line = []rune(e.history[e.browsing])
+}
+
+func (e *lineEditor) next() {
+	if e.browsing >= len(e.history) {
+		return
+	}
+	e.browsing++
+	if e.browsing == len(e.history) {
+		e.line = []rune(e.draft)
+	} else {
+		e.line = []rune(e.history[e.browsing])
+	}
+}
+
+// tab completes the last word as far as it is unambiguous and returns the
+// choices left when there are several.
+func (e *lineEditor) tab() []string {
+	line := string(e.line)
+	matches := e.complete(line)
+	if len(matches) == 0 {
+		return nil
+	}
+	start := strings.LastIndex(line, " ") + 1
+	word := matches[0]
+	for _, m := range matches[1:] {
+		word = commonPrefix(word, m)
+	}
+	if len(matches) == 1 {
+		word += " "
+	}
+	if len(word) >= len(line[start:]) {
+		e.line = []rune(line[:start] + word)
+	}
+	if len(matches) == 1 {
+		return nil
+	}
+	return matches
+}
+
+func commonPrefix(a, b string) string {
+	ra, rb := []rune(a), []rune(b)
+	n := 0
+	for n < len(ra) && n < len(rb) && unicode.ToLower(ra[n]) == unicode.ToLower(rb[n]) {
+		n++
+	}
+	return string(ra[:n])
+}
+
+// readArrow reads the rest of an escape sequence after Esc and returns the
+// arrow key it was ('A' up, 'B' down, 'C' right, 'D' left), or 0 for a lone
+// Esc or any other sequence.
+func readArrow(in *bufio.Reader) rune {
+	if in.Buffered() == 0 {
+		return 0
+	}
+	next, _, err := in.ReadRune()
+	if err != nil || (next != '[' && next != 'O') {
+		return 0
+	}
+	code, _, err := in.ReadRune()
+	if err != nil {
+		return 0
+	}
+	switch code {
+	case 'A', 'B', 'C', 'D':
+		return code
+	}
+	return 0
+}
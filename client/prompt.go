@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// prompt is the plain interactive client. Where stty is available it reads
+// single keys for history and Tab completion and keeps the line being typed
+// under the messages from the server; otherwise it reads whole lines.
+type prompt struct {
+	mu      sync.Mutex
+	conn    *net.UDPConn
+	st      *state
+	raw     bool
+	restore string
+	editor  *lineEditor
+	in      *bufio.Reader
+}
+
+func runPrompt(conn *net.UDPConn, st *state, in *bufio.Reader) int {
+	p := &prompt{conn: conn, st: st, in: in}
+	p.editor = newLineEditor(func(line string) []string { return completions(line, st) })
+	p.restore, p.raw = enterRawMode()
+	restoreTerminal = p.close
+
+	p.print(helpText(""))
+	go receiveMessages(conn, st, p.show)
+	// The events are not shown, they feed the completion
+	conn.Write([]byte("events on"))
+
+	if p.raw {
+		p.readKeys()
+	} else {
+		p.readLines()
+	}
+	quit(conn)
+	p.close()
+	return st.exitCode()
+}
+
+func (p *prompt) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.raw {
+		fmt.Print("\r\n")
+		stty(p.restore)
+		p.raw = false
+	}
+}
+
+func (p *prompt) show(message string) {
+	if strings.HasPrefix(message, "@event ") {
+		return
+	}
+	p.print(strings.TrimRight(message, "\n"))
+}
+
+// print writes text above the line being typed.
+func (p *prompt) print(text string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.raw {
+		fmt.Println(text)
+		return
+	}
+	fmt.Print("\r\x1b[K" + strings.ReplaceAll(text, "\n", "\r\n") + "\r\n")
+	p.drawLine()
+}
+
+func (p *prompt) drawLine() {
+	fmt.Print("\r\x1b[K> " + p.editor.String())
+}
+
+func (p *prompt) readKeys() {
+	in := p.in
+	for {
+		r, _, err := in.ReadRune()
+		if err != nil {
+			return
+		}
+		p.mu.Lock()
+		var text string
+		submitted, done := false, false
+		switch r {
+		case 3: // Ctrl-C
+			done = true
+		case 4: // Ctrl-D
+			done = p.editor.empty()
+		case '\r', '\n':
+			text, submitted = p.editor.submit(), true
+			fmt.Print("\r\x1b[K> " + text + "\r\n")
+		case 127, 8:
+			p.editor.backspace()
+		case 21: // Ctrl-U
+			p.editor.clear()
+		case '\t':
+			if choices := p.editor.tab(); len(choices) > 0 {
+				fmt.Print("\r\x1b[K" + strings.Join(choices, "  ") + "\r\n")
+			}
+		case 27: // Esc, or the start of an arrow key
+			switch readArrow(in) {
+			case 'A':
+				p.editor.previous()
+			case 'B':
+				p.editor.next()
+			case 0:
+				p.editor.clear()
+			}
+		default:
+			p.editor.insert(r)
+		}
+		p.drawLine()
+		p.mu.Unlock()
+		if done || (submitted && p.run(text)) {
+			return
+		}
+	}
+}
+
+func (p *prompt) readLines() {
+	for {
+		text, err := p.in.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if p.run(strings.TrimSpace(text)) {
+			return
+		}
+	}
+}
+
+// run checks a command line, sends it and reports whether the player quit.
+func (p *prompt) run(text string) bool {
+	wire, local, err := parseCommand(text, p.st)
+	switch {
+	case err != nil:
+		p.print(err.Error())
+	case local != "":
+		p.print(local)
+	case wire == "5":
+		return true
+	case wire != "":
+		if _, err := p.conn.Write([]byte(wire)); err != nil {
+			p.print("Error sending message: " + err.Error())
+		}
+	}
+	return false
+}
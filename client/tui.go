@@ -0,0 +1,730 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The full-screen client draws four panes with ANSI escapes: the lobby and
+// the bag on the left, the battle and the chat on the right, and a command
+// line at the bottom. Everything but the chat comes from the server's
+// structured events. It only needs the standard library: where stty is
+// available the terminal goes to raw mode so single keys work, otherwise
+// every key binding is typed followed by Enter.
+
+const maxChat = 200 // lines kept in the chat pane
+
+type tui struct {
+	mu      sync.Mutex
+	conn    *net.UDPConn
+	st      *state
+	raw     bool
+	restore string // stty settings to put back on exit
+	width   int
+	height  int
+
+	lobby  LobbyEvent
+	bag    BagEvent
+	pages  bagPages
+	battle *BattleEvent
+	since  time.Time // when the battle event came
+	chat   []string
+
+	editor  *lineEditor
+	command bool // typing a command while the battle keys are active
+	forfeit bool // f was pressed once, a second f surrenders
+	notice  string
+}
+
+// restoreTerminal is set while the TUI owns the terminal, so every way out
+// of the program can put it back.
+var restoreTerminal = func() {}
+
+// leave restores the terminal and exits.
+func leave(code int) {
+	restoreTerminal()
+	os.Exit(code)
+}
+
+func runTUI(conn *net.UDPConn, st *state) int {
+	ui := &tui{conn: conn, st: st, width: 80, height: 24}
+	ui.editor = newLineEditor(func(line string) []string { return completions(line, st) })
+	ui.restore, ui.raw = enterRawMode()
+	ui.querySize()
+	restoreTerminal = ui.close
+
+	go receiveMessages(conn, st, ui.show)
+	conn.Write([]byte("events on"))
+	ui.redraw()
+	go ui.tick()
+
+	if ui.raw {
+		ui.readKeys()
+	} else {
+		ui.readLines()
+	}
+	quit(conn)
+	ui.close()
+	return st.exitCode()
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// enterRawMode switches the terminal to single keys without echo and
+// returns the settings to restore, or false where stty is not available.
+func enterRawMode() (string, bool) {
+	saved, err := stty("-g")
+	if err != nil {
+		return "", false
+	}
+	if _, err := stty("raw", "-echo"); err != nil {
+		return "", false
+	}
+	return saved, true
+}
+
+func (ui *tui) querySize() {
+	size, err := stty("size")
+	if err != nil {
+		return
+	}
+	var rows, cols int
+	if _, err := fmt.Sscan(size, &rows, &cols); err == nil && rows >= 20 && cols >= 60 {
+		ui.height, ui.width = rows, cols
+	}
+}
+
+func (ui *tui) close() {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	if ui.raw {
+		stty(ui.restore)
+		ui.raw = false
+	}
+	fmt.Print("\x1b[0m\x1b[2J\x1b[H")
+}
+
+// show handles one message from the server: events update the panes and
+// everything else goes to the chat.
+func (ui *tui) show(message string) {
+	ui.mu.Lock()
+	if rest, ok := strings.CutPrefix(message, "@event "); ok {
+		kind, data, _ := strings.Cut(rest, " ")
+		var err error
+		switch kind {
+		case "lobby":
+			err = json.Unmarshal([]byte(data), &ui.lobby)
+		case "bag":
+			var page BagEvent
+			if err = json.Unmarshal([]byte(data), &page); err == nil {
+				if bag, whole := ui.pages.add(page); whole {
+					ui.bag = bag
+				}
+			}
+		case "battle":
+			event := &BattleEvent{}
+			if err = json.Unmarshal([]byte(data), event); err == nil {
+				ui.battle = event
+				ui.since = time.Now()
+				ui.forfeit = false
+			}
+		}
+		if err != nil {
+			ui.addChat("Bad " + kind + " event: " + err.Error())
+		}
+	} else {
+		for _, line := range strings.Split(strings.TrimRight(message, "\n"), "\n") {
+			ui.addChat(line)
+		}
+	}
+	ui.mu.Unlock()
+	ui.redraw()
+}
+
+// tick redraws every second while a battle timer runs, so it counts down
+// between the server's events.
+func (ui *tui) tick() {
+	for range time.Tick(time.Second) {
+		ui.mu.Lock()
+		b := ui.battle
+		running := b != nil && !b.Over && ((b.You.Timer != nil && b.You.Timer.Running) || (b.Opponent.Timer != nil && b.Opponent.Timer.Running))
+		ui.mu.Unlock()
+		if running {
+			ui.redraw()
+		}
+	}
+}
+
+func (ui *tui) addChat(line string) {
+	ui.chat = append(ui.chat, line)
+	if len(ui.chat) > maxChat {
+		ui.chat = ui.chat[len(ui.chat)-maxChat:]
+	}
+}
+
+// battleKeys reports whether single keys drive the battle right now.
+func (ui *tui) battleKeys() bool {
+	return ui.battle != nil && !ui.battle.Over && !ui.command && ui.editor.empty()
+}
+
+func (ui *tui) readKeys() {
+	in := bufio.NewReader(os.Stdin)
+	for {
+		r, _, err := in.ReadRune()
+		if err != nil {
+			return
+		}
+		ui.mu.Lock()
+		done := ui.key(r, in)
+		ui.mu.Unlock()
+		if done {
+			return
+		}
+		ui.redraw()
+	}
+}
+
+// key handles one key press and reports whether the player quit.
+func (ui *tui) key(r rune, in *bufio.Reader) bool {
+	ui.notice = ""
+	switch r {
+	case 3: // Ctrl-C
+		return true
+	case 4: // Ctrl-D
+		return ui.editor.empty()
+	case 12: // Ctrl-L
+		ui.querySize()
+		return false
+	case '\r', '\n':
+		return ui.submit(ui.editor.submit())
+	case 127, 8:
+		if !ui.editor.backspace() {
+			ui.command = false
+		}
+		return false
+	case 21: // Ctrl-U
+		ui.editor.clear()
+		return false
+	case '\t':
+		if choices := ui.editor.tab(); len(choices) > 0 {
+			ui.notice = strings.Join(choices, "  ")
+		}
+		return false
+	case 27: // Esc, or the start of an arrow key
+		switch readArrow(in) {
+		case 'A':
+			ui.editor.previous()
+		case 'B':
+			ui.editor.next()
+		case 0:
+			ui.editor.clear()
+			ui.command, ui.forfeit = false, false
+		}
+		return false
+	}
+
+	if ui.battleKeys() && ui.battleKey(r) {
+		return false
+	}
+	ui.editor.insert(r)
+	return false
+}
+
+// battleKey runs a battle key binding and reports whether r was one.
+func (ui *tui) battleKey(r rune) bool {
+	if r != 'f' {
+		ui.forfeit = false
+	}
+	switch {
+	case r == 'n':
+		ui.send("attack normal")
+	case r == 's':
+		ui.send("attack special")
+	case r == 'a':
+		ui.send("attack")
+	case r == 'f':
+		if !ui.forfeit {
+			ui.forfeit = true
+			ui.notice = "Press f again to forfeit the match."
+			return true
+		}
+		ui.forfeit = false
+		ui.send("surrender")
+	case r >= '1' && r <= '9':
+		bench := ui.battle.You.bench()
+		i := int(r - '1')
+		if i >= len(bench) {
+			ui.notice = "No Pokémon on the bench at " + string(r) + "."
+			return true
+		}
+		if ui.battle.Format == "doubles" {
+			ui.send(fmt.Sprintf("switch %s %d", bench[i].ID, ui.battle.Slot+1))
+			break
+		}
+		ui.send("switch " + bench[i].ID)
+	case r == ':' || r == '/':
+		ui.command = true
+	default:
+		return false
+	}
+	return true
+}
+
+// submit runs a command line and reports whether the player quit.
+func (ui *tui) submit(text string) bool {
+	ui.command = false
+	wire, local, err := parseCommand(text, ui.st)
+	switch {
+	case err != nil:
+		ui.addChat("> " + text)
+		for _, line := range strings.Split(err.Error(), "\n") {
+			ui.addChat(line)
+		}
+	case local != "":
+		for _, line := range strings.Split(local, "\n") {
+			ui.addChat(line)
+		}
+	case wire == "5":
+		return true
+	case wire != "":
+		ui.send(wire)
+	}
+	return false
+}
+
+func (ui *tui) send(text string) {
+	ui.notice = ""
+	ui.addChat("> " + text)
+	if _, err := ui.conn.Write([]byte(text)); err != nil {
+		ui.addChat("Error sending message: " + err.Error())
+	}
+}
+
+// readLines is the fallback without raw mode: a line holding a single
+// battle key runs it, anything else is sent as a command.
+func (ui *tui) readLines() {
+	in := bufio.NewReader(os.Stdin)
+	for {
+		line, err := in.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimSpace(line)
+		ui.mu.Lock()
+		runes := []rune(line)
+		done := false
+		if len(runes) == 1 && ui.battleKeys() && ui.battleKey(runes[0]) {
+			ui.command = false
+		} else {
+			done = ui.submit(line)
+		}
+		ui.mu.Unlock()
+		if done {
+			return
+		}
+		ui.redraw()
+	}
+}
+
+func (ui *tui) redraw() {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	c := newCanvas(ui.width, ui.height)
+	panes := ui.height - 2
+	left := ui.width * 2 / 5
+	right := ui.width - left
+	top := panes / 2
+	battleHeight := panes * 3 / 5
+
+	ui.drawLobby(c, 0, 0, left, top)
+	ui.drawBag(c, 0, top, left, panes-top)
+	ui.drawBattle(c, left, 0, right, battleHeight)
+	ui.drawChat(c, left, battleHeight, right, panes-battleHeight)
+
+	help := "Enter a command · help · Tab completes · Up/Down history · Ctrl-C quit"
+	if ui.battleKeys() {
+		help = "n/s/a attack · 1-9 switch · f forfeit · : command · Ctrl-C quit"
+	}
+	if ui.notice != "" {
+		help = ui.notice
+	}
+	c.text(0, ui.height-2, help, "2", ui.width)
+	prompt := "> "
+	if ui.battleKeys() {
+		prompt = "[battle keys] "
+	}
+	x := c.text(0, ui.height-1, prompt, "1", ui.width)
+	input := ui.editor.String()
+	c.text(x, ui.height-1, input, "", ui.width-x)
+
+	out := c.String()
+	if ui.raw {
+		out = strings.ReplaceAll(out, "\n", "\r\n")
+	}
+	// Leave the cursor at the end of the command line
+	fmt.Printf("%s\x1b[%d;%dH", out, ui.height, x+len([]rune(input))+1)
+}
+
+func (ui *tui) drawLobby(c *canvas, x, y, w, h int) {
+	c.box(x, y, w, h, "Lobby")
+	row := y + 1
+	line := func(text, style string) {
+		if row < y+h-1 {
+			c.text(x+2, row, text, style, w-4)
+			row++
+		}
+	}
+	line("Players", "1")
+	for _, p := range ui.lobby.Players {
+		style := "32"
+		if p.Status == "in battle" {
+			style = "31"
+		} else if p.Status == "invited" {
+			style = "33"
+		} else if p.Status == "idle" {
+			style = "2"
+		}
+		name := p.Name
+		if name == ui.st.username {
+			name += " (you)"
+		}
+		line(fmt.Sprintf("● %-16s %s", name, p.Status), style)
+	}
+	line("", "")
+	line("Live matches", "1")
+	if len(ui.lobby.Matches) == 0 {
+		line("none", "2")
+	}
+	for _, m := range ui.lobby.Matches {
+		line(fmt.Sprintf("#%d %s vs %s (%d watching)", m.ID, m.Player1, m.Player2, m.Watching), "")
+	}
+}
+
+func (ui *tui) drawBag(c *canvas, x, y, w, h int) {
+	title := "Bag"
+	if ui.bag.ActiveParty != "" {
+		title += " · party " + ui.bag.ActiveParty
+	}
+	c.box(x, y, w, h, title)
+	rows := h - 2
+	for i, poke := range ui.bag.Pokemon {
+		row := y + 1 + i
+		if i == rows-1 && len(ui.bag.Pokemon) > rows {
+			c.text(x+2, row, fmt.Sprintf("... %d more (1 to list them all)", len(ui.bag.Pokemon)-i), "2", w-4)
+			break
+		}
+		marker, style := "  ", ""
+		if containsID(ui.bag.Team, poke.ID) {
+			marker, style = "★ ", "1"
+		}
+		cx := c.text(x+2, row, fmt.Sprintf("%s%s %-10s Lv%d", marker, poke.ID, poke.Name, poke.Level), style, w-4)
+		c.badges(cx+1, row, poke.Types, x+w-1)
+	}
+}
+
+func containsID(ids []string, id string) bool {
+	for _, other := range ids {
+		if other == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (ui *tui) drawBattle(c *canvas, x, y, w, h int) {
+	b := ui.battle
+	if b == nil {
+		c.box(x, y, w, h, "Battle")
+		c.text(x+2, y+1, "No battle yet.", "2", w-4)
+		c.text(x+2, y+2, "4 <name> invites a player, battle cpu [easy|normal|hard]", "2", w-4)
+		c.text(x+2, y+3, "plays the CPU. p #id #id #id or party use <name> picks a team.", "2", w-4)
+		return
+	}
+	c.box(x, y, w, h, fmt.Sprintf("Battle · match %d vs %s", b.Match, b.Opponent.Player))
+
+	status, style := "Waiting for "+b.Opponent.Player+"...", "2"
+	switch {
+	case b.Over && b.Won:
+		status, style = "You won!", "1;32"
+	case b.Over:
+		status, style = "You lost.", "1;31"
+	case b.MustSwitch:
+		status, style = "Your Pokémon fainted! Press a number to switch.", "1;33"
+	case b.YourTurn:
+		status, style = "Your turn!", "1;32"
+	}
+	if b.Format == "doubles" && !b.Over && (b.YourTurn || b.MustSwitch) && b.Slot < len(b.You.Slots) {
+		status += fmt.Sprintf(" (slot %d: %s)", b.Slot+1, b.You.Team[b.You.Slots[b.Slot]].Name)
+	}
+	// Đồng hồ đếm ngược của người đang đến lượt
+	if gone := int(time.Since(ui.since) / time.Second); !b.Over {
+		if left := b.You.Timer.left(gone); left != "" {
+			status += " · you: " + left
+		}
+		if left := b.Opponent.Timer.left(gone); left != "" {
+			status += " · " + b.Opponent.Player + ": " + left
+		}
+	}
+	row := y + 1
+	c.text(x+2, row, status, style, w-4)
+	row += 2
+	if f := b.Field; f.Weather != "" || f.Terrain != "" {
+		var parts []string
+		if f.Weather != "" {
+			parts = append(parts, fmt.Sprintf("%s (%d)", f.Weather, f.WeatherTurns))
+		}
+		if f.Terrain != "" {
+			parts = append(parts, fmt.Sprintf("%s terrain (%d)", f.Terrain, f.TerrainTurns))
+		}
+		c.text(x+2, row-1, "Field: "+strings.Join(parts, ", "), "36", w-4)
+	}
+	row = ui.drawActive(c, x+2, row, w-4, b.Opponent)
+	c.text(x+2, row, "Bench: "+benchSummary(b.Opponent.bench(), false), "2", w-4)
+	row += 2
+	row = ui.drawActive(c, x+2, row, w-4, b.You)
+
+	moves := "Moves:"
+	keys := []string{"n", "s"}
+	var field []string
+	for i, move := range b.Moves {
+		if i < len(keys) {
+			moves += fmt.Sprintf("  [%s] %s ~%d dmg", keys[i], move.Name, move.Damage)
+		} else {
+			field = append(field, move.Name)
+		}
+	}
+	moves += "  [a] random"
+	c.text(x+2, row, moves, "", w-4)
+	row++
+	if len(field) > 0 {
+		c.text(x+2, row, "Field moves (:move <name>): "+strings.Join(field, ", "), "2", w-4)
+		row++
+	}
+	c.text(x+2, row, "Bench: "+benchSummary(b.You.bench(), true), "", w-4)
+	row++
+	c.text(x+2, row, "[f] forfeit", "2", w-4)
+}
+
+// drawActive draws a side's Pokémon in battle and returns the next row.
+func (ui *tui) drawActive(c *canvas, x, y, w int, side BattleSide) int {
+	slots := side.Slots
+	if len(slots) == 0 {
+		slots = []int{side.Active}
+	}
+	for n, i := range slots {
+		if i >= len(side.Team) {
+			continue
+		}
+		label := side.Player
+		if len(slots) > 1 {
+			label = fmt.Sprintf("%s [%d]", side.Player, n+1)
+		}
+		y = ui.drawPokemon(c, x, y, w, label, side.Team[i])
+	}
+	return y
+}
+
+func (ui *tui) drawPokemon(c *canvas, x, y, w int, label string, poke BattlePokemon) int {
+	title := fmt.Sprintf("%s: %s Lv%d", label, poke.Name, poke.Level)
+	if poke.Ability != "" {
+		title += " (" + poke.Ability + ")"
+	}
+	if poke.Item != "" {
+		title += " @" + poke.Item
+	}
+	if poke.Status != "" {
+		title += " [" + poke.Status + "]"
+	}
+	cx := c.text(x, y, title, "1", w)
+	c.badges(cx+1, y, poke.Types, x+w)
+	c.hpBar(x, y+1, poke.Hp, poke.MaxHp, w)
+	return y + 2
+}
+
+func benchSummary(bench []BattlePokemon, numbered bool) string {
+	if len(bench) == 0 {
+		return "empty"
+	}
+	var parts []string
+	for i, poke := range bench {
+		text := "?"
+		if !poke.Hidden {
+			text = fmt.Sprintf("%s %d/%d", poke.Name, poke.Hp, poke.MaxHp)
+			if poke.Hp == 0 {
+				text = poke.Name + " fainted"
+			}
+		}
+		if numbered {
+			text = fmt.Sprintf("[%d] %s", i+1, text)
+		}
+		parts = append(parts, text)
+	}
+	return strings.Join(parts, "  ")
+}
+
+func (ui *tui) drawChat(c *canvas, x, y, w, h int) {
+	c.box(x, y, w, h, "Chat")
+	var lines []string
+	for _, line := range ui.chat {
+		lines = append(lines, wrap(line, w-4)...)
+	}
+	rows := h - 2
+	if len(lines) > rows {
+		lines = lines[len(lines)-rows:]
+	}
+	for i, line := range lines {
+		style := ""
+		if strings.HasPrefix(line, "> ") {
+			style = "36"
+		}
+		c.text(x+2, y+1+i, line, style, w-4)
+	}
+}
+
+func wrap(line string, width int) []string {
+	runes := []rune(line)
+	if width <= 0 || len(runes) <= width {
+		return []string{line}
+	}
+	var lines []string
+	for len(runes) > width {
+		lines = append(lines, string(runes[:width]))
+		runes = runes[width:]
+	}
+	return append(lines, string(runes))
+}
+
+// canvas is the screen being drawn: one rune and ANSI style per cell.
+type canvas struct {
+	w, h  int
+	cells [][]cell
+}
+
+type cell struct {
+	r     rune
+	style string
+}
+
+func newCanvas(w, h int) *canvas {
+	c := &canvas{w: w, h: h}
+	for y := 0; y < h; y++ {
+		row := make([]cell, w)
+		for x := range row {
+			row[x].r = ' '
+		}
+		c.cells = append(c.cells, row)
+	}
+	return c
+}
+
+func (c *canvas) set(x, y int, r rune, style string) {
+	if x >= 0 && y >= 0 && x < c.w && y < c.h {
+		c.cells[y][x] = cell{r, style}
+	}
+}
+
+// text writes at most max runes and returns the column after the text.
+func (c *canvas) text(x, y int, s, style string, max int) int {
+	for _, r := range s {
+		if max <= 0 {
+			break
+		}
+		c.set(x, y, r, style)
+		x++
+		max--
+	}
+	return x
+}
+
+func (c *canvas) box(x, y, w, h int, title string) {
+	for i := x + 1; i < x+w-1; i++ {
+		c.set(i, y, '─', "2")
+		c.set(i, y+h-1, '─', "2")
+	}
+	for j := y + 1; j < y+h-1; j++ {
+		c.set(x, j, '│', "2")
+		c.set(x+w-1, j, '│', "2")
+	}
+	c.set(x, y, '┌', "2")
+	c.set(x+w-1, y, '┐', "2")
+	c.set(x, y+h-1, '└', "2")
+	c.set(x+w-1, y+h-1, '┘', "2")
+	c.text(x+2, y, " "+title+" ", "1", w-4)
+}
+
+var typeColors = map[string]string{
+	"Normal": "30;47", "Fire": "97;41", "Water": "97;44", "Electric": "30;43",
+	"Grass": "30;42", "Ice": "30;46", "Fighting": "97;41", "Poison": "97;45",
+	"Ground": "30;43", "Flying": "30;46", "Psychic": "97;45", "Bug": "30;42",
+	"Rock": "30;43", "Ghost": "97;45", "Dragon": "97;44", "Dark": "97;40",
+	"Steel": "30;47", "Fairy": "97;45",
+}
+
+// badges draws the type badges up to column end.
+func (c *canvas) badges(x, y int, types []string, end int) {
+	for _, t := range types {
+		badge := " " + t + " "
+		if x+len(badge) > end {
+			return
+		}
+		x = c.text(x, y, badge, typeColors[t], end-x) + 1
+	}
+}
+
+func (c *canvas) hpBar(x, y, hp, maxHp, w int) {
+	const barWidth = 20
+	filled := 0
+	if maxHp > 0 {
+		filled = hp * barWidth / maxHp
+	}
+	if hp > 0 && filled == 0 {
+		filled = 1
+	}
+	style := "32"
+	if hp*2 <= maxHp {
+		style = "33"
+	}
+	if hp*5 <= maxHp {
+		style = "31"
+	}
+	cx := c.text(x, y, "HP ", "", w)
+	cx = c.text(cx, y, strings.Repeat("█", filled), style, x+w-cx)
+	cx = c.text(cx, y, strings.Repeat("░", barWidth-filled), "2", x+w-cx)
+	c.text(cx, y, " "+strconv.Itoa(hp)+"/"+strconv.Itoa(maxHp), "", x+w-cx)
+}
+
+func (c *canvas) String() string {
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[0m")
+	for y, row := range c.cells {
+		style := ""
+		for _, cell := range row {
+			if cell.style != style {
+				b.WriteString("\x1b[0m")
+				if cell.style != "" {
+					b.WriteString("\x1b[" + cell.style + "m")
+				}
+				style = cell.style
+			}
+			b.WriteRune(cell.r)
+		}
+		b.WriteString("\x1b[0m")
+		if y < len(c.cells)-1 {
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Passwords are optional: a player who joins with one locks their name, and
+// from then on @join needs it. Only a salted PBKDF2 hash is saved, slow on
+// purpose so a leaked save is expensive to guess.
+
+// passwordRounds is how many PBKDF2 rounds a new hash gets. The count is
+// saved in the hash, so raising it later keeps old saves working.
+const passwordRounds = 200000
+
+func newSalt() string {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	return hex.EncodeToString(salt)
+}
+
+// pbkdf2 là PBKDF2-HMAC-SHA256 (RFC 8018) cho một khối 32 byte.
+func pbkdf2(password, salt []byte, rounds int) []byte {
+	mac := hmac.New(sha256.New, password)
+	mac.Write(salt)
+	mac.Write(binary.BigEndian.AppendUint32(nil, 1))
+	u := mac.Sum(nil)
+	key := append([]byte(nil), u...)
+	for i := 1; i < rounds; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
+
+// hashPassword gives "pbkdf2-sha256$<rounds>$<hex>".
+func hashPassword(salt, password string, rounds int) string {
+	key := pbkdf2([]byte(password), []byte(salt), rounds)
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s", rounds, hex.EncodeToString(key))
+}
+
+// legacyHash is the single salted sha256 the first saves with passwords
+// used. It is still checked so those players can log in and be upgraded.
+func legacyHash(salt, password string) string {
+	sum := sha256.Sum256([]byte(salt + password))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkPassword reports whether the password opens the client's save. A
+// save without a password accepts anything.
+func checkPassword(client *Client, password string) bool {
+	if client.passwordHash == "" {
+		return true
+	}
+	hash := legacyHash(client.passwordSalt, password)
+	if parts := strings.Split(client.passwordHash, "$"); len(parts) == 3 && parts[0] == "pbkdf2-sha256" {
+		rounds, err := strconv.Atoi(parts[1])
+		if err != nil || rounds < 1 {
+			return false
+		}
+		hash = hashPassword(client.passwordSalt, password, rounds)
+	}
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(client.passwordHash)) == 1
+}
+
+// needsPassword reports whether @join should (re)hash the given password:
+// the save has none yet, or still has a legacy or weaker hash.
+func needsPassword(client *Client, password string) bool {
+	current := fmt.Sprintf("pbkdf2-sha256$%d$", passwordRounds)
+	return password != "" && !strings.HasPrefix(client.passwordHash, current)
+}
+
+func setPassword(client *Client, password string) {
+	client.passwordSalt = newSalt()
+	client.passwordHash = hashPassword(client.passwordSalt, password, passwordRounds)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestPBKDF2Vectors(t *testing.T) {
+	for rounds, want := range map[int]string{
+		1: "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b",
+		2: "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43",
+	} {
+		if got := hex.EncodeToString(pbkdf2([]byte("password"), []byte("salt"), rounds)); got != want {
+			t.Errorf("%d rounds: got %s, want %s", rounds, got, want)
+		}
+	}
+}
+
+func TestLegacyPasswordsStillOpenAndGetUpgraded(t *testing.T) {
+	client := &Client{passwordSalt: "abcd"}
+	client.passwordHash = legacyHash(client.passwordSalt, "secret")
+	if checkPassword(client, "wrong") {
+		t.Fatal("a wrong password opened a legacy save")
+	}
+	if !checkPassword(client, "secret") {
+		t.Fatal("the right password did not open a legacy save")
+	}
+	if !needsPassword(client, "secret") {
+		t.Fatal("a legacy hash was not marked for upgrade")
+	}
+	setPassword(client, "secret")
+	if needsPassword(client, "secret") {
+		t.Error("a fresh hash was marked for upgrade")
+	}
+	if !checkPassword(client, "secret") || checkPassword(client, "wrong") {
+		t.Error("the upgraded hash does not check the password")
+	}
+}
+
+func TestNeedsPasswordOnlyWhenOneIsGiven(t *testing.T) {
+	if needsPassword(&Client{}, "") {
+		t.Error("joining without a password set one")
+	}
+	if !needsPassword(&Client{}, "secret") {
+		t.Error("the first password was not saved")
+	}
+}
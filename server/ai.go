@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+
+	"pokegame/server/battle"
+)
+
+func (l *Lobby) battleCPU(name, difficulty string, format battle.Format) {
+	client := l.players[name]
+	if client == nil {
+		return
+	}
+	addr := client.Addr
+	if client.game != nil || client.rival != nil {
+		sendMessageToClient("You are already in a battle!", addr, l.conn)
+		return
+	}
+	newTrainer, ok := battle.Trainers[difficulty]
+	if !ok {
+		sendMessageToClient("Unknown difficulty! Choose one of: easy, normal, hard", addr, l.conn)
+		return
+	}
+	if len(client.team) == 0 {
+		sendMessageToClient("Choose your pokemon first!\n(Usage: p #id_pokemon1 #id_pokemon2 #id_pokemon3 or party use <name>)", addr, l.conn)
+		return
+	}
+	if format == battle.Doubles && len(client.team) < 2 {
+		sendMessageToClient("A double battle needs at least 2 Pokémon on your team!\n(Usage: p #id_pokemon1 #id_pokemon2 #id_pokemon3 or party use <name>)", addr, l.conn)
+		return
+	}
+	// Trận với CPU theo luật mặc định
+	ruleName := config.Gameplay.Rules
+	if err := checkTeam(ruleName, client.team); err != nil {
+		sendMessageToClient(brokenRules(ruleName, err), addr, l.conn)
+		return
+	}
+
+	cpu := &Player{Name: "CPU-" + difficulty, format: format, rules: ruleName}
+	team := client.team
+	var cpuTeam []Pokedex
+	var cpuSlots []int
+	for range team {
+		poke := pokedex[rollRNG.Intn(len(pokedex))]
+		poke.Level = team[0].Level
+		poke.Ability = pickAbility(poke, rollRNG)
+		cpuTeam = append(cpuTeam, poke)
+		cpuSlots = append(cpuSlots, -1)
+	}
+
+	l.dequeue(client)
+	client.rival = cpu
+	cpu.rival = client
+	client.format = format
+	client.rules = ruleName
+	game := l.newBattle(client, cpu, team, cpuTeam, client.slots, cpuSlots)
+
+	fmt.Printf("[LOG] %s started a battle against %s.\n", client.Name, cpu.Name)
+	game.post(func(g *Battle) {
+		g.trainer = newTrainer(trainerRNG(g.Engine.Seed))
+		msg := fmt.Sprintf("You are battling %s! It sends out %s.", cpu.Name, leads(g.Engine.Sides[1]))
+		if format != battle.Doubles {
+			// begin gives the usage of double battles
+			msg += "\n(Usage: " + battleUsage(format) + ")"
+		}
+		sendMessageToClient(msg, addr, g.conn)
+		g.begin()
+		g.runTrainer()
+	})
+}
+
+// runTrainer lets the CPU player act when the battle is waiting on it.
+// Every delivered action calls it again, so the CPU keeps up turn by turn.
+func (game *Battle) runTrainer() {
+	const side = 1 // CPU players always join as the opponent
+	engine := game.Engine
+	if game.trainer == nil || !engine.Waiting(side) {
+		return
+	}
+	game.playFor(side, game.trainer)
+}
+
+// playFor applies the trainer's choice for the side: the CPU's moves and
+// the move played when a player's turn times out.
+func (game *Battle) playFor(side int, trainer battle.Trainer) {
+	engine := game.Engine
+	view := engine.View(side)
+	if engine.MustSwitch(side) && len(view.Bench) == 0 {
+		return
+	}
+
+	action := trainer.Choose(view)
+	action.Side = side
+	events := engine.Apply(action)
+	if len(events) == 1 && events[0].Kind == battle.EventRejected {
+		// Never stall the match on a bad choice
+		fallback := battle.Action{Side: side, Kind: battle.ActAttack, Attack: battle.AttackNormal}
+		if engine.MustSwitch(side) {
+			fallback = battle.Action{Side: side, Kind: battle.ActSwitch, Slot: view.Slot, SwitchTo: view.Bench[0].Pokemon.ID}
+		}
+		events = engine.Apply(fallback)
+	}
+	game.deliver(events)
+}
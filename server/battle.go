@@ -0,0 +1,349 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"pokegame/server/battle"
+)
+
+// Battle is a live match: the engine plus the players, spectators and the
+// owned Pokémon behind each team member. Each match runs in its own
+// goroutine, which is the only one touching the engine and the spectators;
+// the lobby posts the players' actions to its inbox.
+type Battle struct {
+	ID         int
+	Players    [2]*Player
+	Teams      [2][]Pokedex // the Pokémon as they were when the match started
+	Slots      [2][]int     // index of each team member in its owner's userPokedex, -1 for CPU Pokémon
+	Engine     *battle.Engine
+	Spectators []*Player
+	Rules      string         // the rule set the match is played under
+	Rated      bool           // the result updates the ladder
+	Tournament bool           // the result advances the tournament
+	trainer    battle.Trainer // plays side 1 in matches against the CPU
+	clocks     [2]*clock      // the players' timers, nil without one
+	events     [2]bool        // players who get structured events
+	lobby      *Lobby
+	conn       *net.UDPConn
+	inbox      chan func(*Battle)
+}
+
+func (game *Battle) run() {
+	for f := range game.inbox {
+		f(game)
+	}
+}
+
+// post runs f in the match goroutine. Only the lobby posts, and it stops
+// before closing the inbox in endGame.
+func (game *Battle) post(f func(*Battle)) {
+	game.inbox <- f
+}
+
+// side returns the engine side the player fights on.
+func (game *Battle) side(player *Player) int {
+	if player == game.Players[1] {
+		return 1
+	}
+	return 0
+}
+
+func (game *Battle) names() [2]string {
+	return [2]string{game.Players[0].Name, game.Players[1].Name}
+}
+
+func toBattleTeam(team []Pokedex) []battle.Pokemon {
+	var pokes []battle.Pokemon
+	for _, poke := range team {
+		pokes = append(pokes, toBattlePokemon(poke))
+	}
+	return pokes
+}
+
+func toBattlePokemon(poke Pokedex) battle.Pokemon {
+	info := poke.PokeInfo
+	def := info.TypeDefense
+	return battle.Pokemon{
+		ID:      poke.Id,
+		Name:    poke.Name,
+		Level:   poke.Level,
+		Types:   poke.Types,
+		Stats:   battle.Stats{Hp: info.Hp, Atk: info.Atk, Def: info.Def, SpAtk: info.SpAtk, SpDef: info.SpDef, Speed: info.Speed},
+		Item:    poke.Held,
+		Ability: poke.Ability,
+		TypeDefense: map[string]float32{
+			"Normal":   def.Normal,
+			"Fire":     def.Fire,
+			"Water":    def.Water,
+			"Electric": def.Electric,
+			"Grass":    def.Grass,
+			"Ice":      def.Ice,
+			"Fighting": def.Fighting,
+			"Poison":   def.Poison,
+			"Ground":   def.Ground,
+			"Flying":   def.Flying,
+			"Psychic":  def.Psychic,
+			"Bug":      def.Bug,
+			"Rock":     def.Rock,
+			"Ghost":    def.Ghost,
+			"Dragon":   def.Dragon,
+			"Dark":     def.Dark,
+			"Steel":    def.Steel,
+			"Fairy":    def.Fairy,
+		},
+	}
+}
+
+// begin tells the players who moves first and what the leads' abilities
+// did when they came out.
+func (game *Battle) begin() {
+	if game.Engine.Format == battle.Doubles {
+		for side, p := range game.Players {
+			sendMessageToClient(fmt.Sprintf("Double battle! You send out %s, your opponent sends out %s.\n(Usage: %s)",
+				leads(game.Engine.Sides[side]), leads(game.Engine.Sides[1-side]), battleUsage(battle.Doubles)), p.Addr, game.conn)
+		}
+	}
+	sendMessageToClient("You first", game.Players[game.Engine.Turn].Addr, game.conn)
+	for _, e := range game.Engine.Opening {
+		text := describeEffect(e)
+		sendMessageToClient(text, game.Players[e.Side].Addr, game.conn)
+		sendMessageToClient("Opponent's "+text, game.Players[1-e.Side].Addr, game.conn)
+		game.notifySpectators(describeEvent(game.names(), e))
+	}
+	if field := describeField(game.Engine.Field); field != "" {
+		for _, p := range game.Players {
+			sendMessageToClient(field, p.Addr, game.conn)
+		}
+	}
+	if timer := describeClocks(config.Rules[game.Rules]); timer != "" {
+		for side, p := range game.Players {
+			if game.clocks[side] != nil {
+				sendMessageToClient(timer, p.Addr, game.conn)
+			}
+		}
+	}
+	game.runClocks()
+	game.sendState()
+}
+
+// leads names the side's Pokémon in battle.
+func leads(side *battle.Side) string {
+	var names []string
+	for slot := range side.Slots {
+		names = append(names, side.At(slot).Pokemon.Name)
+	}
+	return strings.Join(names, " and ")
+}
+
+// battleUsage is the help for the battle commands of the format.
+func battleUsage(format battle.Format) string {
+	if format == battle.Doubles {
+		return "attack [normal|special] [1|2|ally|foes|all], switch #id [1|2], surrender"
+	}
+	return "attack [normal|special], switch #id, surrender"
+}
+
+// submit applies an action to the match and tells everyone what happened.
+func (game *Battle) submit(action battle.Action) {
+	game.deliver(game.Engine.Apply(action))
+}
+
+// deliver turns engine events into messages for the players and the
+// spectators, ends the match when there is a winner and lets a CPU player
+// act when it is its turn.
+func (game *Battle) deliver(events []battle.Event) {
+	conn := game.conn
+	engine := game.Engine
+	surrendered := false
+	for _, e := range events {
+		player := game.Players[e.Side]
+		opponent := game.Players[1-e.Side]
+
+		switch e.Kind {
+		case battle.EventRejected:
+			sendMessageToClient(e.Reason, player.Addr, conn)
+			return
+		case battle.EventAttack:
+			attacker := engine.Sides[e.Side].At(e.Slot)
+			fmt.Printf("[LOG] %s dealt %d damage to %s with %s attack. Remaining HP: %d\n",
+				e.Pokemon, e.Damage, e.Target, e.Attack, e.Hp)
+			sendMessageToClient(fmt.Sprintf("%s attacked %s! Your %s's HP: %d\n%s's opponent - HP: %d",
+				e.Pokemon, e.Target, e.Pokemon, attacker.Hp, e.Target, e.Hp), player.Addr, conn)
+			sendMessageToClient(fmt.Sprintf("%s attacked and remaining HP: %d", e.Pokemon, attacker.Hp), opponent.Addr, conn)
+		case battle.EventFaint:
+			fmt.Printf("[LOG] %s has fainted.\n", e.Pokemon)
+			sendMessageToClient(fmt.Sprintf("%s has fainted! Please switch your Pokémon.", e.Pokemon), player.Addr, conn)
+		case battle.EventMustSwitch:
+			if engine.Format == battle.Doubles {
+				sendMessageToClient(fmt.Sprintf("Your Pokémon in slot %d has fainted! Please switch to another Pokémon using @switch <PokemonID> %d.", e.Slot+1, e.Slot+1), player.Addr, conn)
+				break
+			}
+			sendMessageToClient("Your Pokémon has fainted! Please switch to another Pokémon using @switch <PokemonID>.", player.Addr, conn)
+		case battle.EventSwitchIn:
+			sendMessageToClient(fmt.Sprintf("You switched to %s.", e.Pokemon), player.Addr, conn)
+			sendMessageToClient(fmt.Sprintf("Your opponent switched to %s.", e.Pokemon), opponent.Addr, conn)
+		case battle.EventItem:
+			sendMessageToClient(fmt.Sprintf("You used a %s on %s. HP: %d/%d", e.Item, e.Pokemon, e.Hp, e.MaxHp), player.Addr, conn)
+			sendMessageToClient(fmt.Sprintf("Your opponent used a %s on %s.", e.Item, e.Pokemon), opponent.Addr, conn)
+		case battle.EventHeldItem, battle.EventAbility, battle.EventParalyzed, battle.EventAsleep, battle.EventWoke, battle.EventField, battle.EventResidual:
+			text := describeEffect(e)
+			sendMessageToClient(text, player.Addr, conn)
+			sendMessageToClient("Opponent's "+text, opponent.Addr, conn)
+		case battle.EventFieldEnd:
+			text := describeEvent(game.names(), e)
+			sendMessageToClient(text, player.Addr, conn)
+			sendMessageToClient(text, opponent.Addr, conn)
+		case battle.EventTurn:
+			fmt.Printf("[LOG] Turn switched to %s.\n", player.Name)
+			if engine.Format == battle.Doubles {
+				sendMessageToClient(fmt.Sprintf("Your turn: %s (slot %d) is up.", engine.Sides[e.Side].At(e.Slot).Pokemon.Name, e.Slot+1), player.Addr, conn)
+			}
+		case battle.EventSurrender:
+			surrendered = true
+		case battle.EventWin:
+			if surrendered {
+				sendMessageToClient(fmt.Sprintf("Game over! %s wins!", player.Name), player.Addr, conn)
+			} else {
+				sendMessageToClient("Game over! You win!", player.Addr, conn)
+			}
+			sendMessageToClient("Game over! You lose!", opponent.Addr, conn)
+		}
+		if text := describeEvent(game.names(), e); text != "" {
+			game.notifySpectators(text)
+		}
+	}
+
+	if field := describeField(engine.Field); field != "" && !engine.Over() {
+		for _, p := range game.Players {
+			sendMessageToClient(field, p.Addr, conn)
+		}
+		game.notifySpectators(field)
+	}
+	game.runClocks()
+	game.sendState()
+	if engine.Over() {
+		game.finish()
+		return
+	}
+	game.runTrainer()
+}
+
+// describeEvent tells an event from a neutral point of view, for
+// spectators and replays. Events nobody needs to see give "".
+func describeEvent(names [2]string, e battle.Event) string {
+	switch e.Kind {
+	case battle.EventAttack:
+		return fmt.Sprintf("%s's %s used a %s attack on %s for %d damage. %s HP: %d/%d",
+			names[e.Side], e.Pokemon, e.Attack, e.Target, e.Damage, e.Target, e.Hp, e.MaxHp)
+	case battle.EventFaint:
+		return fmt.Sprintf("%s's %s has fainted!", names[e.Side], e.Pokemon)
+	case battle.EventSwitchIn:
+		return fmt.Sprintf("%s switched to %s [HP: %d/%d].", names[e.Side], e.Pokemon, e.Hp, e.MaxHp)
+	case battle.EventItem:
+		return fmt.Sprintf("%s used a %s on %s (+%d HP). %s HP: %d/%d",
+			names[e.Side], e.Item, e.Pokemon, e.Healed, e.Pokemon, e.Hp, e.MaxHp)
+	case battle.EventHeldItem, battle.EventAbility, battle.EventParalyzed, battle.EventAsleep, battle.EventWoke, battle.EventField, battle.EventResidual:
+		return names[e.Side] + "'s " + describeEffect(e)
+	case battle.EventFieldEnd:
+		return "The " + e.Field + " ended."
+	case battle.EventSurrender:
+		return names[e.Side] + " surrendered."
+	case battle.EventWin:
+		return fmt.Sprintf("Game over! %s wins!", names[e.Side])
+	}
+	return ""
+}
+
+// describeEffect tells what a held item, an ability or a status did to one
+// side's Pokémon, without saying whose it is.
+func describeEffect(e battle.Event) string {
+	switch e.Kind {
+	case battle.EventParalyzed:
+		return e.Pokemon + " is paralyzed! It can't move!"
+	case battle.EventAsleep:
+		return e.Pokemon + " is fast asleep."
+	case battle.EventWoke:
+		return e.Pokemon + " woke up!"
+	case battle.EventField:
+		if e.Ability != "" {
+			return fmt.Sprintf("%s's %s brought %s!", e.Pokemon, e.Ability, e.Field)
+		}
+		if e.Reason != "" {
+			return fmt.Sprintf("%s used %s! %s", e.Pokemon, e.Move, e.Reason)
+		}
+		return fmt.Sprintf("%s used %s! %s covers the field.", e.Pokemon, e.Move, e.Field)
+	case battle.EventResidual:
+		if e.Healed > 0 {
+			return fmt.Sprintf("%s restored %d HP in the %s. HP: %d/%d", e.Pokemon, e.Healed, e.Field, e.Hp, e.MaxHp)
+		}
+		return fmt.Sprintf("%s is hurt by the %s (-%d HP). HP: %d/%d", e.Pokemon, e.Field, e.Damage, e.Hp, e.MaxHp)
+	case battle.EventAbility:
+		return describeAbility(e)
+	}
+	if e.Healed > 0 {
+		return fmt.Sprintf("%s restored %d HP with its %s. HP: %d/%d", e.Pokemon, e.Healed, e.Item, e.Hp, e.MaxHp)
+	}
+	return fmt.Sprintf("%s held on with its %s! HP: %d/%d", e.Pokemon, e.Item, e.Hp, e.MaxHp)
+}
+
+func describeAbility(e battle.Event) string {
+	switch e.Ability {
+	case "Intimidate":
+		return fmt.Sprintf("%s's Intimidate cut %s's attack!", e.Pokemon, e.Target)
+	case "Levitate":
+		return fmt.Sprintf("%s's Levitate keeps it out of reach of %s's Ground power!", e.Pokemon, e.Target)
+	case "Static", "Effect Spore":
+		if e.Reason != "" {
+			return fmt.Sprintf("%s's %s would have put %s to sleep, but the %s stopped it!", e.Pokemon, e.Ability, e.Target, e.Reason)
+		}
+		if e.Status == battle.Sleep {
+			return fmt.Sprintf("%s's %s put %s to sleep!", e.Pokemon, e.Ability, e.Target)
+		}
+		return fmt.Sprintf("%s's %s paralyzed %s!", e.Pokemon, e.Ability, e.Target)
+	case "Sturdy":
+		return fmt.Sprintf("%s endured the hit with Sturdy! HP: %d/%d", e.Pokemon, e.Hp, e.MaxHp)
+	}
+	// Blaze, Torrent và Overgrow
+	return fmt.Sprintf("%s's %s powered up its attack!", e.Pokemon, e.Ability)
+}
+
+// describeField tells the weather and the terrain in play, or "".
+func describeField(f battle.Field) string {
+	var parts []string
+	if f.Weather != "" {
+		parts = append(parts, fmt.Sprintf("%s (%d turns left)", battle.Conditions[f.Weather].Name, f.WeatherTurns))
+	}
+	if f.Terrain != "" {
+		parts = append(parts, fmt.Sprintf("%s (%d turns left)", battle.Conditions[f.Terrain].Name, f.TerrainTurns))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "Field: " + strings.Join(parts, ", ")
+}
+
+func describeEvents(names [2]string, events []battle.Event) string {
+	var lines []string
+	for _, e := range events {
+		if text := describeEvent(names, e); text != "" {
+			lines = append(lines, text)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// finish saves the replay and hands the result to the lobby. The winner's
+// session gets the experience, CPU Pokémon do not level up.
+func (game *Battle) finish() {
+	winner := game.Engine.Winner
+	reward := 0
+	if game.Players[winner].session != nil {
+		// Phân phối kinh nghiệm
+		reward = expReward(game, winner)
+	}
+	saveReplay(game)
+	// Posted from its own goroutine so the match never waits on the lobby
+	go game.lobby.post(func(l *Lobby) { l.endGame(game, winner, reward) })
+}
@@ -0,0 +1,231 @@
+package battle
+
+import "math/rand"
+
+// Paralysis is the status Static gives. A paralysed Pokémon is half as
+// fast and sometimes cannot move.
+const Paralysis = "paralysis"
+
+// Sleep keeps a Pokémon from attacking for a few of its turns.
+const Sleep = "sleep"
+
+const (
+	paralysisChance = 4 // 1 in 4 turns lost
+	maxSleep        = 3 // turns lost to sleep, at most
+)
+
+// Ability is a Pokémon's ability. Like held items every hook is optional
+// and the engine calls each one at its point of the turn. Abilities the
+// engine does not know do nothing.
+type Ability struct {
+	// Enter runs when the holder comes into battle facing foe and reports
+	// whether it did something.
+	Enter func(holder, foe *Combatant) bool
+	// Power scales the damage of the holder's attacks.
+	Power func(holder *Combatant, kind AttackKind) float32
+	// Sets is the weather or terrain the holder brings when it comes in.
+	Sets string
+	// Immune is the attacking type the holder takes no special damage from.
+	Immune string
+	// Survive gets the damage of an attack that would knock the holder out
+	// and returns the damage it takes instead.
+	Survive func(holder *Combatant, damage int) int
+	// Contact runs after the holder is hit by a normal attack by a
+	// Pokémon without a status and returns the status it gives, or "".
+	Contact func(holder, attacker *Combatant, rng *rand.Rand) string
+}
+
+// Abilities are the abilities the engine knows, by name.
+var Abilities = map[string]Ability{
+	"Intimidate": {Enter: func(holder, foe *Combatant) bool {
+		if foe.Hp == 0 || foe.Stages.Atk <= -6 {
+			return false
+		}
+		foe.Stages.Atk--
+		return true
+	}},
+	"Levitate": {Immune: "Ground"},
+	"Blaze":    {Power: pinch("Fire")},
+	"Torrent":  {Power: pinch("Water")},
+	"Overgrow": {Power: pinch("Grass")},
+	"Static": {Contact: func(holder, attacker *Combatant, rng *rand.Rand) string {
+		// 30% làm tê liệt đối thủ
+		if rng.Intn(10) >= 3 {
+			return ""
+		}
+		return Paralysis
+	}},
+	"Effect Spore": {Contact: func(holder, attacker *Combatant, rng *rand.Rand) string {
+		// 30% gây ngủ hoặc tê liệt
+		if rng.Intn(10) >= 3 {
+			return ""
+		}
+		return [2]string{Sleep, Paralysis}[rng.Intn(2)]
+	}},
+	"Drizzle":        {Sets: "rain"},
+	"Drought":        {Sets: "sun"},
+	"Sand Stream":    {Sets: "sandstorm"},
+	"Snow Warning":   {Sets: "snow"},
+	"Electric Surge": {Sets: "electric"},
+	"Grassy Surge":   {Sets: "grassy"},
+	"Psychic Surge":  {Sets: "psychic"},
+	"Misty Surge":    {Sets: "misty"},
+	"Sturdy": {Survive: func(holder *Combatant, damage int) int {
+		if holder.Hp < holder.MaxHp {
+			return damage
+		}
+		return holder.Hp - 1
+	}},
+}
+
+// pinch powers up special attacks by half when the holder has the type and
+// a third of its HP or less.
+func pinch(t string) func(*Combatant, AttackKind) float32 {
+	return func(holder *Combatant, k AttackKind) float32 {
+		if k != AttackSpecial || holder.Hp*3 > holder.MaxHp {
+			return 1
+		}
+		for _, own := range holder.Pokemon.Types {
+			if own == t {
+				return 1.5
+			}
+		}
+		return 1
+	}
+}
+
+func ability(poke *Combatant) (Ability, bool) {
+	a, ok := Abilities[poke.Pokemon.Ability]
+	return a, ok
+}
+
+// stage is the multiplier of a stat stage.
+func stage(s int) float32 {
+	if s >= 0 {
+		return float32(2+s) / 2
+	}
+	return 2 / float32(2-s)
+}
+
+// enter runs the Enter hook of the Pokémon that came into the slot against
+// every opponent in battle, and starts the weather or terrain its ability
+// brings.
+func (e *Engine) enter(side, slot int) []Event {
+	poke := e.Sides[side].At(slot)
+	a, ok := ability(poke)
+	if !ok {
+		return nil
+	}
+	if a.Sets != "" {
+		return e.setField(side, poke.Pokemon.Name, a.Sets, "", poke.Pokemon.Ability)
+	}
+	if a.Enter == nil {
+		return nil
+	}
+	var events []Event
+	foes := e.Sides[1-side]
+	for s := range foes.Slots {
+		if foe := foes.At(s); a.Enter(poke, foe) {
+			events = append(events, Event{Kind: EventAbility, Side: side, Slot: slot, Pokemon: poke.Pokemon.Name, Ability: poke.Pokemon.Ability, Target: foe.Pokemon.Name})
+		}
+	}
+	return events
+}
+
+// hit works out the damage of an attack with stat stages, abilities, held
+// items and the field, and the ability events that explain it. side and
+// target are the sides of the attacker and the defender.
+func hit(field Field, side, target int, attacker, defender *Combatant, kind AttackKind) (int, []Event) {
+	atk, def := attacker.Pokemon, defender.Pokemon
+	atk.Stats.Atk = int(float32(atk.Stats.Atk) * stage(attacker.Stages.Atk))
+	atk.Stats.SpAtk = int(float32(atk.Stats.SpAtk) * stage(attacker.Stages.SpAtk))
+	def.Stats.Def = int(float32(def.Stats.Def) * stage(defender.Stages.Def))
+	def.Stats.SpDef = int(float32(def.Stats.SpDef) * stage(defender.Stages.SpDef))
+	field.guard(&def)
+
+	var events []Event
+	if a, ok := ability(defender); ok && a.Immune != "" && kind == AttackSpecial {
+		// Hệ miễn nhiễm không tính vào đòn đặc biệt
+		var types []string
+		for _, t := range atk.Types {
+			if t != a.Immune {
+				types = append(types, t)
+			}
+		}
+		if len(types) < len(atk.Types) {
+			events = append(events, Event{Kind: EventAbility, Side: target, Pokemon: def.Name, Ability: def.Ability, Target: atk.Name})
+			if len(types) == 0 {
+				return 0, events
+			}
+			atk.Types = types
+		}
+	}
+
+	normal, special := Damage(atk, def)
+	damage := float32(normal)
+	if kind == AttackSpecial {
+		damage = float32(special)
+	}
+	damage *= field.power(atk, kind) * field.shield(atk, def, kind)
+	if a, ok := ability(attacker); ok && a.Power != nil {
+		if factor := a.Power(attacker, kind); factor != 1 {
+			damage *= factor
+			events = append(events, Event{Kind: EventAbility, Side: side, Pokemon: atk.Name, Ability: atk.Ability})
+		}
+	}
+	return int(damage), events
+}
+
+// Preview is the damage the Pokémon in the side's slot would deal to the
+// opponent in the foe slot with an attack of the kind.
+func (e *Engine) Preview(side, slot, foe int, kind AttackKind) int {
+	damage, _ := hit(e.Field, side, 1-side, e.Sides[side].At(slot), e.Sides[1-side].At(foe), kind)
+	return damage
+}
+
+// contact runs the Contact hook of a Pokémon hit by a normal attack. With
+// Sleep Clause a side cannot have a second Pokémon put to sleep while one
+// is still asleep.
+func (e *Engine) contact(side, target int, attacker, defender *Combatant, kind AttackKind) []Event {
+	a, ok := ability(defender)
+	if !ok || a.Contact == nil || kind != AttackNormal || attacker.Hp == 0 || attacker.Status != "" {
+		return nil
+	}
+	status := a.Contact(defender, attacker, e.rng)
+	if status == "" {
+		return nil
+	}
+	event := Event{Kind: EventAbility, Side: target, Pokemon: defender.Pokemon.Name, Ability: defender.Pokemon.Ability, Target: attacker.Pokemon.Name, Status: status}
+	if status == Sleep && e.SleepClause && e.Sides[side].asleep() {
+		event.Reason = "Sleep Clause"
+		return []Event{event}
+	}
+	attacker.Status = status
+	if status == Sleep {
+		attacker.Sleep = 1 + e.rng.Intn(maxSleep)
+	}
+	return []Event{event}
+}
+
+func (s *Side) asleep() bool {
+	for _, poke := range s.Team {
+		if poke.Hp > 0 && poke.Status == Sleep {
+			return true
+		}
+	}
+	return false
+}
+
+// wake counts down the sleep of a Pokémon about to attack. It returns what
+// happened and whether the Pokémon is still asleep and loses its turn.
+func (e *Engine) wake(a Action, poke *Combatant) ([]Event, bool) {
+	if poke.Status != Sleep {
+		return nil, false
+	}
+	if poke.Sleep > 0 {
+		poke.Sleep--
+		return []Event{{Kind: EventAsleep, Side: a.Side, Slot: a.Slot, Pokemon: poke.Pokemon.Name}}, true
+	}
+	poke.Status = ""
+	return []Event{{Kind: EventWoke, Side: a.Side, Slot: a.Slot, Pokemon: poke.Pokemon.Name}}, false
+}
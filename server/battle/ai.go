@@ -0,0 +1,159 @@
+package battle
+
+import "math/rand"
+
+// View is the part of the battle a CPU trainer is allowed to see.
+type View struct {
+	Side     int
+	Slot     int // slot of Active
+	Active   *Combatant
+	Bench    []*Combatant // Pokémon that can still be switched in
+	Foe      int          // slot of Opponent
+	Opponent *Combatant
+}
+
+// View returns what the given side can see of the match: the Pokémon that
+// acts, or the fainted one to replace, and the weakest opponent standing.
+func (e *Engine) View(side int) View {
+	own, foes := e.Sides[side], e.Sides[1-side]
+	view := View{Side: side, Slot: e.Slot}
+	if fainted := own.Fainted(); len(fainted) > 0 {
+		view.Slot = fainted[0]
+	} else if e.Turn != side {
+		view.Slot = 0
+	}
+	view.Active = own.At(view.Slot)
+	for _, slot := range foes.Standing() {
+		if view.Opponent == nil || foes.At(slot).Hp < view.Opponent.Hp {
+			view.Foe, view.Opponent = slot, foes.At(slot)
+		}
+	}
+	if view.Opponent == nil {
+		view.Opponent = foes.Current()
+	}
+	for i, poke := range own.Team {
+		if !own.inBattle(i, -1) && poke.Hp > 0 {
+			view.Bench = append(view.Bench, poke)
+		}
+	}
+	return view
+}
+
+// Trainer picks the actions of a CPU player. Choose must return a switch
+// when the active Pokémon has fainted.
+type Trainer interface {
+	Choose(view View) Action
+}
+
+// Trainers maps a difficulty name to its Trainer. The generator passed in
+// is seeded from the match. Add an entry here to plug in a new AI.
+var Trainers = map[string]func(rng *rand.Rand) Trainer{
+	"easy":   func(rng *rand.Rand) Trainer { return RandomTrainer{rng: rng} },
+	"normal": func(rng *rand.Rand) Trainer { return GreedyTrainer{} },
+	"hard":   func(rng *rand.Rand) Trainer { return LookaheadTrainer{} },
+}
+
+// RandomTrainer attacks with a random kind and switches to a random
+// Pokémon when forced to.
+type RandomTrainer struct {
+	rng *rand.Rand
+}
+
+func (t RandomTrainer) Choose(view View) Action {
+	if view.Active.Hp == 0 {
+		return switchTo(view, view.Bench[t.rng.Intn(len(view.Bench))])
+	}
+	return Action{Side: view.Side, Kind: ActAttack, Attack: AttackKind(t.rng.Intn(2)), Foe: view.Foe}
+}
+
+// GreedyTrainer always uses the attack that deals the most damage against
+// the opponent's types, and sends in the hardest hitter when forced to
+// switch.
+type GreedyTrainer struct{}
+
+func (GreedyTrainer) Choose(view View) Action {
+	if view.Active.Hp == 0 {
+		best := view.Bench[0]
+		for _, poke := range view.Bench[1:] {
+			if bestDamageOf(poke, view.Opponent) > bestDamageOf(best, view.Opponent) {
+				best = poke
+			}
+		}
+		return switchTo(view, best)
+	}
+	kind, _ := bestAttack(view.Active, view.Opponent)
+	return Action{Side: view.Side, Kind: ActAttack, Attack: kind, Foe: view.Foe}
+}
+
+// LookaheadTrainer scores every attack and switch one exchange ahead: the
+// damage it deals against the damage the opponent answers with.
+type LookaheadTrainer struct{}
+
+func (LookaheadTrainer) Choose(view View) Action {
+	var best Action
+	bestScore := -1e9
+	if view.Active.Hp > 0 {
+		for _, kind := range []AttackKind{AttackNormal, AttackSpecial} {
+			if score := scoreAttack(view.Active, view.Opponent, kind); score > bestScore {
+				best, bestScore = Action{Side: view.Side, Kind: ActAttack, Attack: kind, Foe: view.Foe}, score
+			}
+		}
+	}
+	for _, poke := range view.Bench {
+		score := scoreSwitch(poke, view.Opponent)
+		if view.Active.Hp > 0 {
+			score -= 0.1 // switching gives the turn away
+		}
+		if score > bestScore {
+			best, bestScore = switchTo(view, poke), score
+		}
+	}
+	return best
+}
+
+func switchTo(view View, poke *Combatant) Action {
+	return Action{Side: view.Side, Kind: ActSwitch, Slot: view.Slot, SwitchTo: poke.Pokemon.ID}
+}
+
+func scoreAttack(active, opponent *Combatant, kind AttackKind) float64 {
+	dealt := attackDamage(active, opponent, kind)
+	if dealt >= opponent.Hp {
+		return 1 + hpFraction(active.Hp, active.MaxHp)
+	}
+	taken := bestDamageOf(opponent, active)
+	return hpFraction(dealt, opponent.Hp) - hpFraction(taken, active.Hp)
+}
+
+func scoreSwitch(poke, opponent *Combatant) float64 {
+	taken := bestDamageOf(opponent, poke)
+	if taken >= poke.Hp {
+		return -1
+	}
+	return hpFraction(bestDamageOf(poke, opponent), opponent.Hp) - hpFraction(taken, poke.Hp)
+}
+
+func hpFraction(damage, hp int) float64 {
+	if hp <= 0 || damage >= hp {
+		return 1
+	}
+	return float64(damage) / float64(hp)
+}
+
+func attackDamage(attacker, defender *Combatant, kind AttackKind) int {
+	// Trainers do not read the weather
+	damage, _ := hit(Field{}, 0, 1, attacker, defender, kind)
+	return damage
+}
+
+func bestAttack(attacker, defender *Combatant) (AttackKind, int) {
+	normal, special := attackDamage(attacker, defender, AttackNormal), attackDamage(attacker, defender, AttackSpecial)
+	if special > normal {
+		return AttackSpecial, special
+	}
+	return AttackNormal, normal
+}
+
+func bestDamageOf(attacker, defender *Combatant) int {
+	_, dmg := bestAttack(attacker, defender)
+	return dmg
+}
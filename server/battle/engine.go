@@ -0,0 +1,503 @@
+// Package battle holds the battle rules. The Engine only turns actions into
+// events: it does no networking, locking or printing, so a match can be
+// played, replayed and tested without a server.
+package battle
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// Format is how many Pokémon of each side battle at once.
+type Format string
+
+const (
+	Singles Format = "singles"
+	Doubles Format = "doubles"
+)
+
+// Slots is the number of Pokémon each side has in battle.
+func (f Format) Slots() int {
+	if f == Doubles {
+		return 2
+	}
+	return 1
+}
+
+type ActionKind string
+
+const (
+	ActAttack    ActionKind = "attack"
+	ActSwitch    ActionKind = "switch"
+	ActSurrender ActionKind = "surrender"
+	ActItem      ActionKind = "item"
+	ActMove      ActionKind = "move"
+)
+
+// Aim is who an attack hits in a double battle.
+type Aim string
+
+const (
+	AimFoe  Aim = ""     // one opponent, Action.Foe says which
+	AimAlly Aim = "ally" // the partner
+	AimFoes Aim = "foes" // both opponents
+	AimAll  Aim = "all"  // every other Pokémon in battle
+)
+
+// spread is the damage multiplier of an attack that hits more than one
+// Pokémon.
+const spread = 0.75
+
+// Action is what a side submits on its turn. The engine fills in Slot, the
+// battle slot that acts, except for a switch that replaces a fainted
+// Pokémon, where it names the slot to fill.
+type Action struct {
+	Side     int        `json:"Side"`
+	Kind     ActionKind `json:"Kind"`
+	Slot     int        `json:"Slot,omitempty"`
+	Attack   AttackKind `json:"Attack,omitempty"`
+	Aim      Aim        `json:"Aim,omitempty"`
+	Foe      int        `json:"Foe,omitempty"`      // opposing slot hit with AimFoe
+	SwitchTo string     `json:"SwitchTo,omitempty"` // Pokémon ID
+	Item     *Medicine  `json:"Item,omitempty"`
+	Target   string     `json:"Target,omitempty"` // Pokémon ID the item is used on
+	Move     string     `json:"Move,omitempty"`   // field move ID
+}
+
+type EventKind string
+
+const (
+	EventAttack     EventKind = "attack"
+	EventFaint      EventKind = "faint"
+	EventMustSwitch EventKind = "must-switch"
+	EventSwitchIn   EventKind = "switch-in"
+	EventTurn       EventKind = "turn"
+	EventSurrender  EventKind = "surrender"
+	EventWin        EventKind = "win"
+	EventRejected   EventKind = "rejected"
+	EventItem       EventKind = "item"
+	EventHeldItem   EventKind = "held-item"
+	EventAbility    EventKind = "ability"
+	EventParalyzed  EventKind = "paralyzed"
+	EventAsleep     EventKind = "asleep"
+	EventWoke       EventKind = "woke"
+	EventField      EventKind = "field"     // a weather or terrain started
+	EventFieldEnd   EventKind = "field-end" // it ran out
+	EventResidual   EventKind = "residual"  // it hurt or healed at the end of a turn
+)
+
+// Event is one thing that happened while applying an action. Side is the
+// side the event is about: the attacker, the fainted Pokémon's owner, the
+// side to move or the winner. Slot is the battle slot of that side's
+// Pokémon.
+type Event struct {
+	Kind    EventKind
+	Side    int
+	Slot    int
+	Pokemon string // acting or affected Pokémon
+	Target  string
+	Attack  AttackKind
+	Damage  int
+	Hp      int // HP of the affected Pokémon (the target of an attack) afterwards
+	MaxHp   int
+	Reason  string // why an action was rejected
+	Item    string // item used
+	Healed  int    // HP restored by the item
+	Ability string // ability that worked
+	Status  string // status it gave
+	Move    string // field move used
+	Field   string // weather or terrain
+}
+
+type Side struct {
+	Team []*Combatant
+	// Slots holds the team index of the Pokémon in each battle slot. A
+	// fainted Pokémon keeps its slot until it is replaced, for good when
+	// there is no one left to send in.
+	Slots []int
+}
+
+// Current returns the Pokémon in the first slot, the only one in singles.
+func (s *Side) Current() *Combatant {
+	return s.At(0)
+}
+
+// At returns the Pokémon in a battle slot.
+func (s *Side) At(slot int) *Combatant {
+	return s.Team[s.Slots[slot]]
+}
+
+// Standing returns the slots whose Pokémon can still battle.
+func (s *Side) Standing() []int {
+	var slots []int
+	for slot := range s.Slots {
+		if s.At(slot).Hp > 0 {
+			slots = append(slots, slot)
+		}
+	}
+	return slots
+}
+
+// inBattle reports whether the team member is in a slot other than skip.
+func (s *Side) inBattle(index, skip int) bool {
+	for slot, i := range s.Slots {
+		if i == index && slot != skip {
+			return true
+		}
+	}
+	return false
+}
+
+// Alive reports whether the side still has a Pokémon that can battle.
+func (s *Side) Alive() bool {
+	for _, poke := range s.Team {
+		if poke.Hp > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Fainted returns the slots waiting for a replacement: the ones whose
+// Pokémon fainted while someone is left on the bench.
+func (s *Side) Fainted() []int {
+	bench := false
+	for i, poke := range s.Team {
+		bench = bench || (poke.Hp > 0 && !s.inBattle(i, -1))
+	}
+	if !bench {
+		return nil
+	}
+	var slots []int
+	for slot := range s.Slots {
+		if s.At(slot).Hp == 0 {
+			slots = append(slots, slot)
+		}
+	}
+	return slots
+}
+
+// Engine is the state of one match.
+type Engine struct {
+	Format  Format
+	Sides   [2]*Side
+	Turn    int // side whose turn it is
+	Slot    int // slot of that side that acts next
+	Winner  int // -1 while the battle goes on
+	Seed    int64
+	Actions []Action // every accepted action, in order
+	Opening []Event  // what the leads' abilities did when the match started
+	Field   Field
+	// SleepClause comes from the match's rule set; set it before the first
+	// action.
+	SleepClause bool
+
+	rng *rand.Rand
+}
+
+// New starts a match. The first turn goes to the side with the fastest
+// lead, and the seed drives every random roll, so the same seed and
+// actions always give the same battle.
+func New(format Format, team1, team2 []Pokemon, seed int64) *Engine {
+	if format != Doubles {
+		format = Singles
+	}
+	e := &Engine{Format: format, Winner: -1, Seed: seed, rng: rand.New(rand.NewSource(seed))}
+	for i, team := range [2][]Pokemon{team1, team2} {
+		side := &Side{}
+		for _, poke := range team {
+			side.Team = append(side.Team, newCombatant(poke))
+		}
+		for slot := 0; slot < format.Slots() && slot < len(team); slot++ {
+			side.Slots = append(side.Slots, slot)
+			side.Team[slot].Revealed = true
+		}
+		e.Sides[i] = side
+	}
+	for side := range e.Sides {
+		for slot := range e.Sides[side].Slots {
+			e.Opening = append(e.Opening, e.enter(side, slot)...)
+		}
+	}
+	if e.lead(1) > e.lead(0) {
+		e.Turn = 1
+	}
+	return e
+}
+
+// lead is the speed of the side's fastest Pokémon in battle.
+func (e *Engine) lead(side int) float32 {
+	fastest := float32(0)
+	for slot := range e.Sides[side].Slots {
+		fastest = max(fastest, speed(e.Sides[side].At(slot)))
+	}
+	return fastest
+}
+
+// Over reports whether the match has a winner.
+func (e *Engine) Over() bool {
+	return e.Winner >= 0
+}
+
+// MustSwitch reports whether the side has to replace a fainted Pokémon.
+func (e *Engine) MustSwitch(side int) bool {
+	return !e.Over() && len(e.Sides[side].Fainted()) > 0
+}
+
+// Waiting reports whether the match cannot go on until the side acts.
+func (e *Engine) Waiting(side int) bool {
+	if e.Over() {
+		return false
+	}
+	return e.MustSwitch(side) || (e.Turn == side && e.facing(side))
+}
+
+// facing reports whether the side has opponents to act against: some are
+// standing and none is waiting for a replacement.
+func (e *Engine) facing(side int) bool {
+	foe := e.Sides[1-side]
+	return len(foe.Standing()) > 0 && len(foe.Fainted()) == 0
+}
+
+// Apply plays one action and returns what happened. An invalid action
+// changes nothing and returns a single EventRejected.
+func (e *Engine) Apply(a Action) []Event {
+	if a.Side != 0 && a.Side != 1 {
+		return reject(a, "Unknown side.")
+	}
+	if e.Over() {
+		return reject(a, "The battle is over.")
+	}
+	if a.Kind != ActSwitch {
+		a.Slot = e.Slot
+	}
+	switch a.Kind {
+	case ActAttack:
+		return e.attack(a)
+	case ActSwitch:
+		return e.switchIn(a)
+	case ActItem:
+		return e.useItem(a)
+	case ActMove:
+		return e.move(a)
+	case ActSurrender:
+		e.Actions = append(e.Actions, a)
+		e.Winner = 1 - a.Side
+		return []Event{{Kind: EventSurrender, Side: a.Side}, {Kind: EventWin, Side: e.Winner}}
+	}
+	return reject(a, "Unknown action.")
+}
+
+// acting checks that the side can act now and returns its Pokémon that
+// does, or why it cannot.
+func (e *Engine) acting(a Action) (*Combatant, string) {
+	if e.Turn != a.Side {
+		return nil, "Not your turn!"
+	}
+	poke := e.Sides[a.Side].At(a.Slot)
+	if poke.Hp <= 0 {
+		return nil, "Your current Pokémon has fainted! Please switch to another Pokémon."
+	}
+	if !e.facing(a.Side) {
+		return nil, "Your opponent's Pokémon has fainted! Waiting for them to switch Pokémon."
+	}
+	return poke, ""
+}
+
+// target is a Pokémon an attack hits.
+type target struct {
+	side, slot int
+}
+
+// targets returns who the attack hits. An attack on one opponent that has
+// fainted goes to the other one.
+func (e *Engine) targets(a Action) ([]target, string) {
+	if (a.Aim != AimFoe || a.Foe != 0) && e.Format != Doubles {
+		return nil, "You can only pick a target in a double battle."
+	}
+	foes := e.Sides[1-a.Side].Standing()
+	var list []target
+	switch a.Aim {
+	case AimFoe:
+		if a.Foe < 0 || a.Foe >= len(e.Sides[1-a.Side].Slots) {
+			return nil, "Unknown target."
+		}
+		slot := foes[0]
+		for _, s := range foes {
+			if s == a.Foe {
+				slot = s
+			}
+		}
+		return []target{{1 - a.Side, slot}}, ""
+	case AimFoes, AimAll:
+		for _, s := range foes {
+			list = append(list, target{1 - a.Side, s})
+		}
+		if a.Aim == AimFoes {
+			return list, ""
+		}
+		fallthrough
+	case AimAlly:
+		for _, s := range e.Sides[a.Side].Standing() {
+			if s != a.Slot {
+				list = append(list, target{a.Side, s})
+			}
+		}
+		if len(list) == 0 {
+			return nil, "Your partner cannot battle!"
+		}
+		return list, ""
+	}
+	return nil, "Unknown target."
+}
+
+func (e *Engine) attack(a Action) []Event {
+	attacker, reason := e.acting(a)
+	if attacker == nil {
+		return reject(a, reason)
+	}
+	if a.Attack != AttackRandom && a.Attack != AttackNormal && a.Attack != AttackSpecial {
+		return reject(a, "Unknown attack.")
+	}
+	if attacker.Locked && a.Attack != AttackRandom && a.Attack != attacker.Choice {
+		item, _ := held(attacker.Pokemon)
+		return reject(a, fmt.Sprintf("%s is locked into %s attacks by its %s! Switch it out to change.",
+			attacker.Pokemon.Name, attacker.Choice, item.Name))
+	}
+	targets, reason := e.targets(a)
+	if targets == nil {
+		return reject(a, reason)
+	}
+	e.Actions = append(e.Actions, a)
+
+	events, asleep := e.wake(a, attacker)
+	if asleep {
+		return e.afterTurn(a, append(events, e.endTurn(a.Side, a.Slot)...))
+	}
+	if attacker.Status == Paralysis && e.rng.Intn(paralysisChance) == 0 {
+		events := []Event{{Kind: EventParalyzed, Side: a.Side, Slot: a.Slot, Pokemon: attacker.Pokemon.Name}}
+		return e.afterTurn(a, append(events, e.endTurn(a.Side, a.Slot)...))
+	}
+
+	// Random chọn kiểu tấn công
+	kind := a.Attack
+	if attacker.Locked {
+		kind = attacker.Choice
+	} else if kind == AttackRandom {
+		kind = AttackKind(e.rng.Intn(2))
+	}
+	if item, ok := held(attacker.Pokemon); ok && item.Locks {
+		attacker.Locked, attacker.Choice = true, kind
+	}
+	for _, t := range targets {
+		events = append(events, e.strike(a, attacker, t, kind, len(targets) > 1)...)
+	}
+	events = append(events, e.endTurn(a.Side, a.Slot)...)
+	return e.afterTurn(a, events)
+}
+
+// strike lands the attack on one target. A spread attack deals less to
+// each.
+func (e *Engine) strike(a Action, attacker *Combatant, t target, kind AttackKind, spreads bool) []Event {
+	defender := e.Sides[t.side].At(t.slot)
+	damage, events := hit(e.Field, a.Side, t.side, attacker, defender, kind)
+	if spreads {
+		damage = int(float32(damage) * spread)
+	}
+
+	var saved []Event
+	if ab, ok := ability(defender); ok && ab.Survive != nil && damage >= defender.Hp {
+		if taken := ab.Survive(defender, damage); taken < damage {
+			damage = taken
+			saved = append(saved, Event{Kind: EventAbility, Side: t.side, Slot: t.slot, Pokemon: defender.Pokemon.Name, Ability: defender.Pokemon.Ability, Hp: defender.Hp - damage, MaxHp: defender.MaxHp})
+		}
+	}
+	if item, ok := held(defender.Pokemon); ok && item.Survive != nil && damage >= defender.Hp {
+		if taken := item.Survive(defender, damage); taken < damage {
+			damage = taken
+			saved = append(saved, Event{Kind: EventHeldItem, Side: t.side, Slot: t.slot, Pokemon: defender.Pokemon.Name, Item: item.Name, Hp: defender.Hp - damage, MaxHp: defender.MaxHp})
+		}
+	}
+	defender.Hp -= damage
+	if defender.Hp < 0 {
+		defender.Hp = 0
+	}
+	events = append(events, Event{
+		Kind: EventAttack, Side: a.Side, Slot: a.Slot, Pokemon: attacker.Pokemon.Name, Target: defender.Pokemon.Name,
+		Attack: kind, Damage: damage, Hp: defender.Hp, MaxHp: defender.MaxHp,
+	})
+	events = append(events, saved...)
+	return append(events, e.contact(a.Side, t.side, attacker, defender, kind)...)
+}
+
+func (e *Engine) switchIn(a Action) []Event {
+	side := e.Sides[a.Side]
+	// A fainted Pokémon can be replaced at any time, otherwise switching takes the turn
+	fainted := side.Fainted()
+	replacing := len(fainted) > 0
+	if replacing {
+		slot := fainted[0]
+		for _, s := range fainted {
+			if s == a.Slot {
+				slot = s
+			}
+		}
+		a.Slot = slot
+	} else if e.Turn != a.Side {
+		return reject(a, "Not your turn!")
+	} else {
+		a.Slot = e.Slot
+	}
+
+	target, down, partner := -1, -1, -1
+	for i, poke := range side.Team {
+		if poke.Pokemon.ID != a.SwitchTo {
+			continue
+		}
+		if poke.Hp == 0 {
+			down = i
+			continue
+		}
+		if side.inBattle(i, a.Slot) {
+			partner = i
+			continue
+		}
+		target = i
+		break
+	}
+	if target < 0 {
+		if down >= 0 {
+			return reject(a, side.Team[down].Pokemon.Name+" has fainted and cannot battle!")
+		}
+		if partner >= 0 {
+			return reject(a, side.Team[partner].Pokemon.Name+" is already in battle!")
+		}
+		if replacing || len(e.Sides[1-a.Side].Fainted()) > 0 {
+			return reject(a, "Opponent needs to switch Pokémon before continuing.")
+		}
+		return reject(a, "Invalid Pokémon ID. Please try again.")
+	}
+	e.Actions = append(e.Actions, a)
+
+	// Bậc chỉ số mất khi rời sân
+	out := side.At(a.Slot)
+	out.Locked = false
+	out.Stages = Stages{}
+	side.Slots[a.Slot] = target
+	poke := side.At(a.Slot)
+	poke.Revealed = true
+	events := []Event{{Kind: EventSwitchIn, Side: a.Side, Slot: a.Slot, Pokemon: poke.Pokemon.Name, Hp: poke.Hp, MaxHp: poke.MaxHp}}
+	events = append(events, e.enter(a.Side, a.Slot)...)
+	if !replacing {
+		return e.afterTurn(a, append(events, e.endTurn(a.Side, a.Slot)...))
+	}
+	// The side to move keeps the turn; its slot may have just been refilled
+	if standing := e.Sides[e.Turn].Standing(); len(standing) > 0 && e.Sides[e.Turn].At(e.Slot).Hp == 0 {
+		e.Slot = standing[0]
+	}
+	return append(events, Event{Kind: EventTurn, Side: e.Turn, Slot: e.Slot})
+}
+
+func reject(a Action, reason string) []Event {
+	return []Event{{Kind: EventRejected, Side: a.Side, Reason: reason}}
+}
@@ -0,0 +1,383 @@
+package battle
+
+import (
+	"reflect"
+	"testing"
+)
+
+// mon builds a Pokémon with the same attack and defence for both kinds.
+func mon(id string, hp, atk, def, speed int, types ...string) Pokemon {
+	if len(types) == 0 {
+		types = []string{"Normal"}
+	}
+	return Pokemon{
+		ID: id, Name: id, Level: 1, Types: types,
+		Stats: Stats{Hp: hp, Atk: atk, Def: def, SpAtk: atk, SpDef: def, Speed: speed},
+	}
+}
+
+func with(p Pokemon, item, ability string) Pokemon {
+	p.Item, p.Ability = item, ability
+	return p
+}
+
+func kinds(events []Event) []EventKind {
+	var list []EventKind
+	for _, e := range events {
+		list = append(list, e.Kind)
+	}
+	return list
+}
+
+func find(events []Event, kind EventKind) (Event, bool) {
+	for _, e := range events {
+		if e.Kind == kind {
+			return e, true
+		}
+	}
+	return Event{}, false
+}
+
+func mustApply(t *testing.T, e *Engine, a Action) []Event {
+	t.Helper()
+	events := e.Apply(a)
+	if r, ok := find(events, EventRejected); ok {
+		t.Fatalf("%+v was rejected: %s", a, r.Reason)
+	}
+	return events
+}
+
+func mustReject(t *testing.T, e *Engine, a Action) string {
+	t.Helper()
+	before := len(e.Actions)
+	events := e.Apply(a)
+	if len(events) != 1 || events[0].Kind != EventRejected {
+		t.Fatalf("%+v was accepted: %v", a, kinds(events))
+	}
+	if len(e.Actions) != before {
+		t.Fatalf("a rejected action was recorded")
+	}
+	return events[0].Reason
+}
+
+func attack(side int, kind AttackKind) Action {
+	return Action{Side: side, Kind: ActAttack, Attack: kind}
+}
+
+func TestDamage(t *testing.T) {
+	fire := mon("a", 100, 80, 40, 50, "Fire")
+	grass := mon("b", 100, 60, 40, 30, "Grass")
+	grass.TypeDefense = map[string]float32{"Fire": 2}
+	tests := []struct {
+		name            string
+		attacker        Pokemon
+		defender        Pokemon
+		normal, special int
+	}{
+		{"plain", fire, mon("c", 100, 60, 40, 30), 60, 60},
+		{"weak to the type", fire, grass, 60, 140},
+		{"at least 1", mon("d", 100, 10, 10, 10), mon("e", 100, 10, 200, 10), 1, 1},
+		{"choice band", with(fire, "choice-band", ""), grass, 90, 140},
+		{"type booster", with(fire, "charcoal", ""), grass, 60, 168},
+	}
+	for _, tt := range tests {
+		normal, special := Damage(tt.attacker, tt.defender)
+		if normal != tt.normal || special != tt.special {
+			t.Errorf("%s: got %d/%d, want %d/%d", tt.name, normal, special, tt.normal, tt.special)
+		}
+	}
+}
+
+func TestFastestLeadMovesFirst(t *testing.T) {
+	e := New(Singles, []Pokemon{mon("a", 100, 50, 40, 30)}, []Pokemon{mon("b", 100, 50, 40, 60)}, 1)
+	if e.Turn != 1 || !e.Waiting(1) || e.Waiting(0) {
+		t.Fatalf("turn %d, want the faster side 1", e.Turn)
+	}
+	mustReject(t, e, attack(0, AttackNormal))
+}
+
+func TestAttackDealsDamageAndPassesTheTurn(t *testing.T) {
+	e := New(Singles, []Pokemon{mon("a", 100, 80, 40, 50)}, []Pokemon{mon("b", 100, 60, 40, 30)}, 1)
+	events := mustApply(t, e, attack(0, AttackNormal))
+	if got, want := kinds(events), []EventKind{EventAttack, EventTurn}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("events %v, want %v", got, want)
+	}
+	if hit := events[0]; hit.Damage != 60 || hit.Hp != 40 || hit.Target != "b" {
+		t.Errorf("hit %+v, want 60 damage leaving 40 HP", hit)
+	}
+	if e.Turn != 1 || e.Sides[1].Current().Hp != 40 {
+		t.Errorf("turn %d, HP %d", e.Turn, e.Sides[1].Current().Hp)
+	}
+	if len(e.Actions) != 1 {
+		t.Errorf("%d actions recorded, want 1", len(e.Actions))
+	}
+}
+
+func TestFaintForcesASwitch(t *testing.T) {
+	e := New(Singles,
+		[]Pokemon{mon("a", 100, 200, 40, 50)},
+		[]Pokemon{mon("b", 100, 60, 40, 30), mon("c", 100, 60, 40, 30), mon("d", 100, 60, 40, 30)}, 1)
+	events := mustApply(t, e, attack(0, AttackNormal))
+	if got, want := kinds(events), []EventKind{EventAttack, EventFaint, EventMustSwitch}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("events %v, want %v", got, want)
+	}
+	if !e.MustSwitch(1) || !e.Waiting(1) || e.Waiting(0) {
+		t.Fatal("side 1 should have to switch, and only side 1")
+	}
+	mustReject(t, e, attack(0, AttackNormal))
+	mustReject(t, e, attack(1, AttackNormal))
+	if reason := mustReject(t, e, Action{Side: 1, Kind: ActSwitch, SwitchTo: "b"}); reason != "b has fainted and cannot battle!" {
+		t.Errorf("switch to the fainted Pokémon: %q", reason)
+	}
+
+	events = mustApply(t, e, Action{Side: 1, Kind: ActSwitch, SwitchTo: "c"})
+	if in, ok := find(events, EventSwitchIn); !ok || in.Pokemon != "c" {
+		t.Fatalf("events %v", kinds(events))
+	}
+	if next, ok := find(events, EventTurn); !ok || next.Side != e.Turn || !e.Waiting(e.Turn) {
+		t.Errorf("the turn event %+v does not match the engine", next)
+	}
+	if e.MustSwitch(1) || !e.Sides[1].Current().Revealed {
+		t.Error("the replacement should be in battle")
+	}
+}
+
+func TestLastFaintWins(t *testing.T) {
+	e := New(Singles, []Pokemon{mon("a", 100, 200, 40, 50)}, []Pokemon{mon("b", 100, 60, 40, 30)}, 1)
+	events := mustApply(t, e, attack(0, AttackNormal))
+	win, ok := find(events, EventWin)
+	if !ok || win.Side != 0 || !e.Over() || e.Winner != 0 {
+		t.Fatalf("events %v, winner %d", kinds(events), e.Winner)
+	}
+	if reason := mustReject(t, e, attack(1, AttackNormal)); reason != "The battle is over." {
+		t.Errorf("got %q", reason)
+	}
+}
+
+func TestSwitchRules(t *testing.T) {
+	e := New(Singles, []Pokemon{mon("a", 100, 50, 40, 50), mon("b", 100, 50, 40, 50)}, []Pokemon{mon("c", 100, 50, 40, 30)}, 1)
+	mustReject(t, e, Action{Side: 1, Kind: ActSwitch, SwitchTo: "c"})
+	mustReject(t, e, Action{Side: 0, Kind: ActSwitch, SwitchTo: "zz"})
+	mustApply(t, e, Action{Side: 0, Kind: ActSwitch, SwitchTo: "b"})
+	if e.Sides[0].Current().Pokemon.ID != "b" || e.Turn != 1 {
+		t.Errorf("current %s, turn %d", e.Sides[0].Current().Pokemon.ID, e.Turn)
+	}
+}
+
+func TestSurrender(t *testing.T) {
+	e := New(Singles, []Pokemon{mon("a", 100, 50, 40, 50)}, []Pokemon{mon("b", 100, 50, 40, 30)}, 1)
+	events := mustApply(t, e, Action{Side: 1, Kind: ActSurrender})
+	if got, want := kinds(events), []EventKind{EventSurrender, EventWin}; !reflect.DeepEqual(got, want) || e.Winner != 0 {
+		t.Fatalf("events %v, winner %d", got, e.Winner)
+	}
+}
+
+func TestAbilities(t *testing.T) {
+	t.Run("Intimidate", func(t *testing.T) {
+		e := New(Singles, []Pokemon{with(mon("a", 100, 50, 40, 30), "", "Intimidate")}, []Pokemon{mon("b", 100, 60, 40, 50)}, 1)
+		if ev, ok := find(e.Opening, EventAbility); !ok || ev.Ability != "Intimidate" || ev.Target != "b" {
+			t.Fatalf("opening %+v", e.Opening)
+		}
+		if e.Sides[1].Current().Stages.Atk != -1 {
+			t.Fatalf("stage %d", e.Sides[1].Current().Stages.Atk)
+		}
+		// 60 * 2/3 - 40/2
+		if hit := mustApply(t, e, attack(1, AttackNormal))[0]; hit.Damage != 20 {
+			t.Errorf("damage %d, want 20", hit.Damage)
+		}
+	})
+	t.Run("Sturdy", func(t *testing.T) {
+		e := New(Singles, []Pokemon{mon("a", 100, 200, 40, 50)}, []Pokemon{with(mon("b", 100, 60, 40, 30), "", "Sturdy")}, 1)
+		events := mustApply(t, e, attack(0, AttackNormal))
+		if _, ok := find(events, EventAbility); !ok || e.Sides[1].Current().Hp != 1 || e.Over() {
+			t.Fatalf("events %v, HP %d", kinds(events), e.Sides[1].Current().Hp)
+		}
+	})
+	t.Run("Levitate", func(t *testing.T) {
+		e := New(Singles, []Pokemon{mon("a", 100, 80, 40, 50, "Ground")}, []Pokemon{with(mon("b", 100, 60, 40, 30), "", "Levitate")}, 1)
+		events := mustApply(t, e, attack(0, AttackSpecial))
+		if hit, _ := find(events, EventAttack); hit.Damage != 0 {
+			t.Errorf("damage %d, want 0", hit.Damage)
+		}
+		if ev, ok := find(events, EventAbility); !ok || ev.Ability != "Levitate" {
+			t.Errorf("events %v", kinds(events))
+		}
+	})
+	t.Run("Drizzle", func(t *testing.T) {
+		e := New(Singles, []Pokemon{with(mon("a", 100, 50, 40, 50), "", "Drizzle")}, []Pokemon{mon("b", 100, 60, 40, 30)}, 1)
+		if _, ok := find(e.Opening, EventField); !ok || e.Field.Weather != "rain" || e.Field.WeatherTurns != fieldTurns {
+			t.Errorf("field %+v", e.Field)
+		}
+	})
+	t.Run("Sleep Clause", func(t *testing.T) {
+		e := New(Singles, []Pokemon{mon("a", 500, 10, 40, 50), mon("a2", 500, 10, 40, 50)}, []Pokemon{with(mon("b", 500, 10, 40, 30), "", "Effect Spore")}, 1)
+		e.SleepClause = true
+		e.Sides[0].Team[1].Status = Sleep
+		for i := 0; i < 40 && !e.Over(); i++ {
+			for _, ev := range mustApply(t, e, attack(e.Turn, AttackNormal)) {
+				if ev.Kind == EventAbility && ev.Status == Sleep && ev.Reason == "" {
+					t.Fatal("a second Pokémon fell asleep")
+				}
+			}
+		}
+	})
+}
+
+func TestHeldItems(t *testing.T) {
+	t.Run("Leftovers", func(t *testing.T) {
+		e := New(Singles, []Pokemon{with(mon("a", 100, 50, 40, 30), "leftovers", "")}, []Pokemon{mon("b", 100, 60, 40, 50)}, 1)
+		mustApply(t, e, attack(1, AttackNormal))
+		events := mustApply(t, e, attack(0, AttackNormal))
+		if ev, ok := find(events, EventHeldItem); !ok || ev.Healed != 6 || e.Sides[0].Current().Hp != 66 {
+			t.Errorf("events %v, HP %d", kinds(events), e.Sides[0].Current().Hp)
+		}
+	})
+	t.Run("Focus Sash", func(t *testing.T) {
+		e := New(Singles, []Pokemon{mon("a", 100, 200, 40, 50)}, []Pokemon{with(mon("b", 100, 60, 40, 30), "focus-sash", "")}, 1)
+		events := mustApply(t, e, attack(0, AttackNormal))
+		if _, ok := find(events, EventHeldItem); !ok || e.Sides[1].Current().Hp != 1 || !e.Sides[1].Current().ItemUsed {
+			t.Errorf("events %v, HP %d", kinds(events), e.Sides[1].Current().Hp)
+		}
+	})
+	t.Run("Choice lock", func(t *testing.T) {
+		e := New(Singles, []Pokemon{with(mon("a", 300, 50, 40, 50), "choice-band", "")}, []Pokemon{mon("b", 300, 60, 40, 30)}, 1)
+		if hit := mustApply(t, e, attack(0, AttackNormal))[0]; hit.Damage != 45 {
+			t.Errorf("damage %d, want 45", hit.Damage)
+		}
+		mustApply(t, e, attack(1, AttackNormal))
+		mustReject(t, e, attack(0, AttackSpecial))
+		mustApply(t, e, attack(0, AttackRandom))
+	})
+}
+
+func TestFieldMoves(t *testing.T) {
+	e := New(Singles, []Pokemon{mon("a", 400, 50, 40, 50, "Rock")}, []Pokemon{mon("b", 400, 60, 40, 30)}, 1)
+	if reason := mustReject(t, e, Action{Side: 0, Kind: ActMove, Move: "rain-dance"}); reason == "" {
+		t.Error("a Rock type used Rain Dance")
+	}
+	events := mustApply(t, e, Action{Side: 0, Kind: ActMove, Move: "sandstorm"})
+	if _, ok := find(events, EventField); !ok || e.Field.Weather != "sandstorm" || e.Field.WeatherTurns != fieldTurns-1 {
+		t.Fatalf("events %v, field %+v", kinds(events), e.Field)
+	}
+	if _, ok := find(events, EventResidual); ok {
+		t.Error("the sandstorm hurt a Rock type")
+	}
+	events = mustApply(t, e, attack(1, AttackNormal))
+	if ev, ok := find(events, EventResidual); !ok || ev.Damage != 25 {
+		t.Errorf("events %v", kinds(events))
+	}
+	for i := 0; i < fieldTurns && e.Field.Weather != ""; i++ {
+		mustApply(t, e, attack(e.Turn, AttackNormal))
+	}
+	if e.Field.Weather != "" {
+		t.Errorf("the sandstorm lasts longer than %d turns", fieldTurns)
+	}
+}
+
+func TestDoubles(t *testing.T) {
+	newDoubles := func() *Engine {
+		return New(Doubles,
+			[]Pokemon{mon("a1", 100, 80, 40, 50), mon("a2", 100, 300, 40, 40)},
+			[]Pokemon{mon("b1", 100, 60, 40, 30), mon("b2", 100, 60, 40, 20)}, 1)
+	}
+	t.Run("spread", func(t *testing.T) {
+		e := newDoubles()
+		events := mustApply(t, e, Action{Side: 0, Kind: ActAttack, Attack: AttackNormal, Aim: AimFoes})
+		var hits []int
+		for _, ev := range events {
+			if ev.Kind == EventAttack {
+				hits = append(hits, ev.Damage)
+			}
+		}
+		if !reflect.DeepEqual(hits, []int{45, 45}) {
+			t.Errorf("hits %v, want 45 each", hits)
+		}
+		if e.Turn != 0 || e.Slot != 1 {
+			t.Errorf("turn %d slot %d, want the partner next", e.Turn, e.Slot)
+		}
+	})
+	t.Run("ally", func(t *testing.T) {
+		e := newDoubles()
+		mustApply(t, e, Action{Side: 0, Kind: ActAttack, Attack: AttackNormal, Aim: AimAlly})
+		if e.Sides[0].At(1).Hp != 40 {
+			t.Errorf("partner HP %d, want 40", e.Sides[0].At(1).Hp)
+		}
+	})
+	t.Run("redirect", func(t *testing.T) {
+		e := newDoubles()
+		mustApply(t, e, Action{Side: 0, Kind: ActAttack, Attack: AttackNormal, Foe: 1})
+		events := mustApply(t, e, Action{Side: 0, Kind: ActAttack, Attack: AttackNormal, Foe: 1})
+		if _, ok := find(events, EventFaint); !ok || e.Sides[1].At(1).Hp != 0 {
+			t.Fatalf("events %v", kinds(events))
+		}
+		e.Turn, e.Slot = 0, 0
+		events = mustApply(t, e, Action{Side: 0, Kind: ActAttack, Attack: AttackNormal, Foe: 1})
+		if hit, _ := find(events, EventAttack); hit.Target != "b1" {
+			t.Errorf("hit %s, want the standing b1", hit.Target)
+		}
+	})
+	t.Run("singles cannot aim", func(t *testing.T) {
+		e := New(Singles, []Pokemon{mon("a", 100, 50, 40, 50)}, []Pokemon{mon("b", 100, 50, 40, 30)}, 1)
+		mustReject(t, e, Action{Side: 0, Kind: ActAttack, Attack: AttackNormal, Aim: AimFoes})
+		mustReject(t, e, Action{Side: 0, Kind: ActAttack, Attack: AttackNormal, Foe: 1})
+	})
+	t.Run("replacement", func(t *testing.T) {
+		e := New(Doubles,
+			[]Pokemon{mon("a1", 100, 300, 40, 50), mon("a2", 100, 50, 40, 40)},
+			[]Pokemon{mon("b1", 100, 60, 40, 30), mon("b2", 100, 60, 40, 20), mon("b3", 100, 60, 40, 20)}, 1)
+		mustApply(t, e, Action{Side: 0, Kind: ActAttack, Attack: AttackNormal, Foe: 0})
+		if !e.MustSwitch(1) || e.Waiting(0) {
+			t.Fatal("side 1 should replace b1 before anyone acts")
+		}
+		if reason := mustReject(t, e, Action{Side: 1, Kind: ActSwitch, Slot: 0, SwitchTo: "b2"}); reason != "b2 is already in battle!" {
+			t.Errorf("got %q", reason)
+		}
+		mustApply(t, e, Action{Side: 1, Kind: ActSwitch, Slot: 0, SwitchTo: "b3"})
+		if e.Sides[1].At(0).Pokemon.ID != "b3" || !e.Waiting(e.Turn) {
+			t.Errorf("slot 0 holds %s", e.Sides[1].At(0).Pokemon.ID)
+		}
+	})
+}
+
+func TestSameSeedSameBattle(t *testing.T) {
+	play := func() []Event {
+		e := New(Singles,
+			[]Pokemon{mon("a", 120, 70, 40, 50), mon("c", 120, 70, 40, 50)},
+			[]Pokemon{with(mon("b", 120, 60, 40, 30), "", "Static"), mon("d", 120, 60, 40, 30)}, 42)
+		var all []Event
+		for i := 0; i < 100 && !e.Over(); i++ {
+			side := 0
+			if !e.Waiting(side) {
+				side = 1
+			}
+			a := attack(side, AttackRandom)
+			if e.MustSwitch(side) {
+				view := e.View(side)
+				a = Action{Side: side, Kind: ActSwitch, Slot: view.Slot, SwitchTo: view.Bench[0].Pokemon.ID}
+			}
+			all = append(all, mustApply(t, e, a)...)
+		}
+		return all
+	}
+	if a, b := play(), play(); !reflect.DeepEqual(a, b) {
+		t.Error("the same seed and actions gave two battles")
+	}
+}
+
+func TestStatsAtLevel(t *testing.T) {
+	base := Stats{Hp: 45, Atk: 49, Def: 49, SpAtk: 65, SpDef: 65, Speed: 45}
+	tests := []struct {
+		level int
+		want  Stats
+	}{
+		{level: 50, want: Stats{Hp: 105, Atk: 54, Def: 54, SpAtk: 70, SpDef: 70, Speed: 50}},
+		{level: 100, want: Stats{Hp: 200, Atk: 103, Def: 103, SpAtk: 135, SpDef: 135, Speed: 95}},
+		{level: 5, want: Stats{Hp: 19, Atk: 9, Def: 9, SpAtk: 11, SpDef: 11, Speed: 9}},
+		{level: 0, want: Stats{Hp: 11, Atk: 5, Def: 5, SpAtk: 6, SpDef: 6, Speed: 5}},
+	}
+	for _, tt := range tests {
+		if got := base.AtLevel(tt.level); got != tt.want {
+			t.Errorf("level %d: %+v, want %+v", tt.level, got, tt.want)
+		}
+	}
+}
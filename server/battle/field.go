@@ -0,0 +1,287 @@
+package battle
+
+import "sort"
+
+// Field is the weather and the terrain of a match. Each lasts a number of
+// turns, counted down at the end of every side's turn; a new weather or
+// terrain replaces the old one.
+type Field struct {
+	Weather      string `json:"Weather,omitempty"`
+	WeatherTurns int    `json:"Weather-Turns,omitempty"`
+	Terrain      string `json:"Terrain,omitempty"`
+	TerrainTurns int    `json:"Terrain-Turns,omitempty"`
+}
+
+const fieldTurns = 8 // four turns of each side
+
+// Condition is a weather or a terrain. Terrain only works on grounded
+// Pokémon: not Flying types and not the ones with Levitate.
+type Condition struct {
+	Name    string
+	Terrain bool
+	Boost   string  // attacking type powered up
+	BoostBy float32 // how much
+	Weaken  string  // attacking type cut by half
+	Shields string  // attacking type cut by half against grounded Pokémon
+	Guard   string  // defending type whose defence against the attack goes up by half
+	Special bool    // Guard works against special attacks, otherwise normal ones
+	Hurts   bool    // deals 1/16 of max HP at the end of each turn
+	Heals   bool    // gives back 1/16 of max HP at the end of each turn
+	Immune  []string
+}
+
+// Conditions are the weathers and terrains, by ID.
+var Conditions = map[string]Condition{
+	"rain":      {Name: "Rain", Boost: "Water", BoostBy: 1.5, Weaken: "Fire"},
+	"sun":       {Name: "Harsh sunlight", Boost: "Fire", BoostBy: 1.5, Weaken: "Water"},
+	"sandstorm": {Name: "Sandstorm", Guard: "Rock", Special: true, Hurts: true, Immune: []string{"Rock", "Ground", "Steel"}},
+	"snow":      {Name: "Snow", Guard: "Ice"},
+	"electric":  {Name: "Electric Terrain", Terrain: true, Boost: "Electric", BoostBy: 1.3},
+	"grassy":    {Name: "Grassy Terrain", Terrain: true, Boost: "Grass", BoostBy: 1.3, Heals: true},
+	"psychic":   {Name: "Psychic Terrain", Terrain: true, Boost: "Psychic", BoostBy: 1.3},
+	"misty":     {Name: "Misty Terrain", Terrain: true, Shields: "Dragon"},
+}
+
+// FieldMove is a move that sets a weather or a terrain. Only Pokémon of its
+// type can use it, and it takes the turn.
+type FieldMove struct {
+	Name string
+	Type string
+	Sets string // condition ID
+}
+
+// FieldMoves are the field moves, by ID.
+var FieldMoves = map[string]FieldMove{
+	"rain-dance":       {"Rain Dance", "Water", "rain"},
+	"sunny-day":        {"Sunny Day", "Fire", "sun"},
+	"sandstorm":        {"Sandstorm", "Rock", "sandstorm"},
+	"snowscape":        {"Snowscape", "Ice", "snow"},
+	"electric-terrain": {"Electric Terrain", "Electric", "electric"},
+	"grassy-terrain":   {"Grassy Terrain", "Grass", "grassy"},
+	"psychic-terrain":  {"Psychic Terrain", "Psychic", "psychic"},
+	"misty-terrain":    {"Misty Terrain", "Fairy", "misty"},
+}
+
+// MovesOf returns the IDs of the field moves the Pokémon can use.
+func MovesOf(poke Pokemon) []string {
+	var ids []string
+	for id, move := range FieldMoves {
+		if hasType(poke, move.Type) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func hasType(poke Pokemon, t string) bool {
+	for _, own := range poke.Types {
+		if own == t {
+			return true
+		}
+	}
+	return false
+}
+
+func grounded(poke Pokemon) bool {
+	return !hasType(poke, "Flying") && poke.Ability != "Levitate"
+}
+
+// active returns the conditions in play that work on the Pokémon.
+func (f Field) active(poke Pokemon) []Condition {
+	var list []Condition
+	if c, ok := Conditions[f.Weather]; ok {
+		list = append(list, c)
+	}
+	if c, ok := Conditions[f.Terrain]; ok && grounded(poke) {
+		list = append(list, c)
+	}
+	return list
+}
+
+// power scales the damage of a special attack of the attacker's types.
+func (f Field) power(attacker Pokemon, kind AttackKind) float32 {
+	factor := float32(1)
+	if kind != AttackSpecial {
+		return factor
+	}
+	for _, c := range f.active(attacker) {
+		for _, t := range attacker.Types {
+			if t == c.Boost {
+				factor *= c.BoostBy
+			}
+			if t == c.Weaken {
+				factor *= 0.5
+			}
+		}
+	}
+	return factor
+}
+
+// guard scales the defender's Def or SpDef.
+func (f Field) guard(defender *Pokemon) {
+	for _, c := range f.active(*defender) {
+		if c.Guard == "" || !hasType(*defender, c.Guard) {
+			continue
+		}
+		if c.Special {
+			defender.Stats.SpDef = defender.Stats.SpDef * 3 / 2
+		} else {
+			defender.Stats.Def = defender.Stats.Def * 3 / 2
+		}
+	}
+}
+
+// shield cuts the special attacks of a terrain's Shields type against
+// grounded Pokémon.
+func (f Field) shield(attacker, defender Pokemon, kind AttackKind) float32 {
+	for _, c := range f.active(defender) {
+		if c.Shields != "" && kind == AttackSpecial && hasType(attacker, c.Shields) {
+			return 0.5
+		}
+	}
+	return 1
+}
+
+// setField starts a weather or a terrain. who is the Pokémon that brought
+// it, by a move or an ability.
+func (e *Engine) setField(side int, who, id, move, ability string) []Event {
+	c := Conditions[id]
+	if c.Terrain {
+		if e.Field.Terrain == id {
+			return nil
+		}
+		e.Field.Terrain, e.Field.TerrainTurns = id, fieldTurns
+	} else {
+		if e.Field.Weather == id {
+			return nil
+		}
+		e.Field.Weather, e.Field.WeatherTurns = id, fieldTurns
+	}
+	return []Event{{Kind: EventField, Side: side, Pokemon: who, Field: c.Name, Ability: ability, Move: move}}
+}
+
+// move uses a field move.
+func (e *Engine) move(a Action) []Event {
+	poke, reason := e.acting(a)
+	if poke == nil {
+		return reject(a, reason)
+	}
+	move, ok := FieldMoves[a.Move]
+	if !ok {
+		return reject(a, "Unknown move.")
+	}
+	if !hasType(poke.Pokemon, move.Type) {
+		return reject(a, poke.Pokemon.Name+" cannot use "+move.Name+", only "+move.Type+" types can!")
+	}
+	if poke.Locked {
+		return reject(a, poke.Pokemon.Name+" is locked into "+poke.Choice.String()+" attacks! Switch it out to change.")
+	}
+	e.Actions = append(e.Actions, a)
+
+	events, asleep := e.wake(a, poke)
+	if asleep {
+		return e.afterTurn(a, append(events, e.endTurn(a.Side, a.Slot)...))
+	}
+	set := e.setField(a.Side, poke.Pokemon.Name, move.Sets, move.Name, "")
+	if set == nil {
+		set = []Event{{Kind: EventField, Side: a.Side, Slot: a.Slot, Pokemon: poke.Pokemon.Name, Move: move.Name, Reason: "But it failed!"}}
+	}
+	events = append(events, set...)
+	events = append(events, e.endTurn(a.Side, a.Slot)...)
+	return e.afterTurn(a, events)
+}
+
+// weather runs the field after a Pokémon acted: sandstorm damage and Grassy
+// Terrain healing, then the turn counters once the last Pokémon of the side
+// has acted.
+func (e *Engine) weather(side, slot int) []Event {
+	var events []Event
+	poke := e.Sides[side].At(slot)
+	for _, c := range e.Field.active(poke.Pokemon) {
+		if poke.Hp == 0 {
+			break
+		}
+		immune := false
+		for _, t := range c.Immune {
+			immune = immune || hasType(poke.Pokemon, t)
+		}
+		switch {
+		case c.Hurts && !immune:
+			damage := min(max(poke.MaxHp/16, 1), poke.Hp)
+			poke.Hp -= damage
+			events = append(events, Event{Kind: EventResidual, Side: side, Slot: slot, Pokemon: poke.Pokemon.Name, Field: c.Name, Damage: damage, Hp: poke.Hp, MaxHp: poke.MaxHp})
+		case c.Heals && poke.Hp < poke.MaxHp:
+			before := poke.Hp
+			poke.Hp = min(poke.Hp+max(poke.MaxHp/16, 1), poke.MaxHp)
+			events = append(events, Event{Kind: EventResidual, Side: side, Slot: slot, Pokemon: poke.Pokemon.Name, Field: c.Name, Healed: poke.Hp - before, Hp: poke.Hp, MaxHp: poke.MaxHp})
+		}
+	}
+
+	// Đếm lượt khi cả phe đã hành động
+	for _, s := range e.Sides[side].Standing() {
+		if s > slot {
+			return events
+		}
+	}
+	f := &e.Field
+	if f.Weather != "" {
+		if f.WeatherTurns--; f.WeatherTurns == 0 {
+			events = append(events, Event{Kind: EventFieldEnd, Side: side, Field: Conditions[f.Weather].Name})
+			f.Weather = ""
+		}
+	}
+	if f.Terrain != "" {
+		if f.TerrainTurns--; f.TerrainTurns == 0 {
+			events = append(events, Event{Kind: EventFieldEnd, Side: side, Field: Conditions[f.Terrain].Name})
+			f.Terrain = ""
+		}
+	}
+	return events
+}
+
+// afterTurn faints the Pokémon that the action or the end of the turn
+// knocked out, then hands the turn on.
+func (e *Engine) afterTurn(a Action, events []Event) []Event {
+	for _, s := range [2]int{1 - a.Side, a.Side} {
+		side := e.Sides[s]
+		for slot := range side.Slots {
+			poke := side.At(slot)
+			if poke.Hp > 0 || poke.Down {
+				continue
+			}
+			poke.Down = true
+			events = append(events, Event{Kind: EventFaint, Side: s, Slot: slot, Pokemon: poke.Pokemon.Name, MaxHp: poke.MaxHp})
+			if !side.Alive() {
+				e.Winner = 1 - s
+				return append(events, Event{Kind: EventWin, Side: e.Winner})
+			}
+			if len(side.Fainted()) > 0 {
+				events = append(events, Event{Kind: EventMustSwitch, Side: s, Slot: slot})
+			}
+		}
+	}
+	e.pass(a)
+	if e.facing(e.Turn) && e.Sides[e.Turn].At(e.Slot).Hp > 0 {
+		events = append(events, Event{Kind: EventTurn, Side: e.Turn, Slot: e.Slot})
+	}
+	return events
+}
+
+// pass picks who acts next: the side's next standing slot, then the
+// opponent's first. The side keeps the turn while the opponent has no one
+// standing and sends in a replacement.
+func (e *Engine) pass(a Action) {
+	own, foe := e.Sides[a.Side].Standing(), e.Sides[1-a.Side].Standing()
+	for _, slot := range own {
+		if slot > a.Slot {
+			e.Slot = slot
+			return
+		}
+	}
+	if len(foe) > 0 {
+		e.Turn, e.Slot = 1-a.Side, foe[0]
+	} else if len(own) > 0 {
+		e.Slot = own[0]
+	}
+}
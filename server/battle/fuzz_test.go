@@ -0,0 +1,153 @@
+package battle
+
+import (
+	"fmt"
+	"testing"
+)
+
+var (
+	fuzzItems     = []string{"", "leftovers", "focus-sash", "choice-band", "choice-scarf", "charcoal"}
+	fuzzAbilities = []string{"", "Intimidate", "Levitate", "Static", "Effect Spore", "Sturdy", "Drizzle", "Sand Stream", "Blaze"}
+	fuzzTypes     = []string{"Normal", "Fire", "Water", "Rock", "Ground", "Flying", "Grass", "Fairy"}
+	fuzzMoves     = []string{"rain-dance", "sunny-day", "sandstorm", "grassy-terrain", "misty-terrain", "splash"}
+	fuzzAims      = []Aim{AimFoe, AimAlly, AimFoes, AimAll, "nowhere"}
+	fuzzMedicine  = []Medicine{{Name: "Potion", Heal: 20}, {Name: "Full Heal", Cure: true}, {Name: "Revive", Revive: true}}
+)
+
+// fuzzTeam builds a team from the input, a few bytes per Pokémon.
+func fuzzTeam(side int, data []byte) []Pokemon {
+	var team []Pokemon
+	for i := 0; i+4 <= len(data) && len(team) < 3; i += 4 {
+		b := data[i : i+4]
+		p := mon(fmt.Sprintf("s%dp%d", side, len(team)), 20+int(b[0]), 5+int(b[1]%120), 5+int(b[2]%120), 1+int(b[3]), fuzzTypes[int(b[1])%len(fuzzTypes)])
+		p.Item = fuzzItems[int(b[2])%len(fuzzItems)]
+		p.Ability = fuzzAbilities[int(b[0])%len(fuzzAbilities)]
+		team = append(team, p)
+	}
+	if len(team) == 0 {
+		team = append(team, mon(fmt.Sprintf("s%dp0", side), 50, 50, 50, 50))
+	}
+	return team
+}
+
+// fuzzAction turns four bytes into an action, valid or not.
+func fuzzAction(b []byte) Action {
+	a := Action{Side: int(b[0] % 3), Slot: int(b[3] % 3), Foe: int(b[3]%4) - 1}
+	switch b[1] % 6 {
+	case 0, 1:
+		a.Kind, a.Attack, a.Aim = ActAttack, AttackKind(int(b[2]%4)-1), fuzzAims[int(b[2]/4)%len(fuzzAims)]
+	case 2:
+		a.Kind, a.SwitchTo = ActSwitch, fmt.Sprintf("s%dp%d", a.Side, b[2]%4)
+	case 3:
+		a.Kind, a.Move = ActMove, fuzzMoves[int(b[2])%len(fuzzMoves)]
+	case 4:
+		medicine := fuzzMedicine[int(b[2])%len(fuzzMedicine)]
+		a.Kind, a.Item = ActItem, &medicine
+		if b[2]&0x80 != 0 {
+			a.Target = fmt.Sprintf("s%dp%d", a.Side, b[3]%3)
+		}
+	case 5:
+		a.Kind = ActSurrender
+		if b[2]%8 != 0 {
+			a.Kind = "dance"
+		}
+	}
+	return a
+}
+
+// snapshot is the state an action can change.
+func snapshot(e *Engine) string {
+	s := fmt.Sprintf("turn %d slot %d winner %d field %+v actions %d", e.Turn, e.Slot, e.Winner, e.Field, len(e.Actions))
+	for _, side := range e.Sides {
+		s += fmt.Sprint(side.Slots)
+		for _, poke := range side.Team {
+			s += fmt.Sprintf("|%d %s %d %+v %v %v %v", poke.Hp, poke.Status, poke.Sleep, poke.Stages, poke.ItemUsed, poke.Locked, poke.Down)
+		}
+	}
+	return s
+}
+
+// checkState reports the first broken invariant of the engine.
+func checkState(e *Engine) error {
+	if e.Winner < -1 || e.Winner > 1 || e.Turn < 0 || e.Turn > 1 {
+		return fmt.Errorf("winner %d, turn %d", e.Winner, e.Turn)
+	}
+	for i, side := range e.Sides {
+		if e.Slot < 0 || (i == e.Turn && e.Slot >= len(side.Slots)) {
+			return fmt.Errorf("slot %d of side %d with %d slots", e.Slot, i, len(side.Slots))
+		}
+		seen := make(map[int]bool)
+		for _, index := range side.Slots {
+			if index < 0 || index >= len(side.Team) || seen[index] {
+				return fmt.Errorf("side %d slots %v", i, side.Slots)
+			}
+			seen[index] = true
+		}
+		for _, poke := range side.Team {
+			if poke.Hp < 0 || poke.Hp > poke.MaxHp {
+				return fmt.Errorf("%s has %d/%d HP", poke.Pokemon.Name, poke.Hp, poke.MaxHp)
+			}
+		}
+	}
+	if !e.Over() && !e.Waiting(0) && !e.Waiting(1) {
+		return fmt.Errorf("nobody can act: %s", snapshot(e))
+	}
+	return nil
+}
+
+func FuzzApply(f *testing.F) {
+	f.Add([]byte{0, 60, 80, 40, 50, 70, 60, 40, 30, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0})
+	f.Add([]byte{1, 60, 80, 40, 50, 70, 60, 40, 30, 90, 10, 20, 30, 0, 0, 2, 1, 1, 2, 0, 0, 1, 1, 2, 1})
+	f.Add([]byte{1, 200, 3, 50, 9, 120, 8, 2, 40, 5, 5, 5, 5, 0, 3, 0, 0, 1, 4, 130, 1, 0, 5, 0, 0})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) < 1 {
+			return
+		}
+		format := Singles
+		if data[0]%2 == 1 {
+			format = Doubles
+		}
+		rest := data[1:]
+		n := min(len(rest), 24)
+		half := n / 2
+		e := New(format, fuzzTeam(0, rest[:half]), fuzzTeam(1, rest[half:n]), int64(len(data)))
+		e.SleepClause = data[0]&2 != 0
+		if err := checkState(e); err != nil {
+			t.Fatalf("after New: %v", err)
+		}
+		actions := rest[n:]
+		for i := 0; i+4 <= len(actions); i += 4 {
+			a := fuzzAction(actions[i : i+4])
+			before, wasOver, recorded := snapshot(e), e.Over(), len(e.Actions)
+			events := e.Apply(a)
+			if len(events) == 0 {
+				t.Fatalf("%+v gave no events", a)
+			}
+			if events[0].Kind == EventRejected {
+				if len(events) != 1 || snapshot(e) != before {
+					t.Fatalf("rejected %+v changed the match", a)
+				}
+				continue
+			}
+			if wasOver {
+				t.Fatalf("%+v was accepted after the match ended", a)
+			}
+			if len(e.Actions) != recorded+1 {
+				t.Fatalf("%+v recorded %d actions", a, len(e.Actions)-recorded)
+			}
+			won := false
+			for _, ev := range events {
+				if ev.Side < 0 || ev.Side > 1 || ev.Kind == EventRejected {
+					t.Fatalf("bad event %+v", ev)
+				}
+				won = won || ev.Kind == EventWin
+			}
+			if won != e.Over() {
+				t.Fatalf("win event %v but over %v", won, e.Over())
+			}
+			if err := checkState(e); err != nil {
+				t.Fatalf("after %+v: %v", a, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,129 @@
+package battle
+
+// HeldItem is an item a Pokémon holds into battle. Every hook is optional;
+// the engine calls each one at its point of the turn. Held items are not
+// used up for good: an item like Focus Sash works once per battle.
+type HeldItem struct {
+	Name string
+	// Power scales the damage of the holder's attacks.
+	Power func(holder Pokemon, kind AttackKind) float32
+	// Speed scales the holder's speed when picking who moves first.
+	Speed float32
+	// Survive gets the damage of an attack that would knock the holder out
+	// and returns the damage it takes instead.
+	Survive func(holder *Combatant, damage int) int
+	// EndTurn returns the HP the holder gets back after its side acted.
+	EndTurn func(holder *Combatant) int
+	// Locks keeps the holder on the attack kind it used first until it
+	// switches out.
+	Locks bool
+}
+
+// TypeBooster powers up special attacks of holders of one type.
+type TypeBooster struct {
+	ID   string
+	Name string
+	Type string
+}
+
+var TypeBoosters = []TypeBooster{
+	{"silk-scarf", "Silk Scarf", "Normal"},
+	{"charcoal", "Charcoal", "Fire"},
+	{"mystic-water", "Mystic Water", "Water"},
+	{"magnet", "Magnet", "Electric"},
+	{"miracle-seed", "Miracle Seed", "Grass"},
+	{"never-melt-ice", "Never-Melt Ice", "Ice"},
+	{"black-belt", "Black Belt", "Fighting"},
+	{"poison-barb", "Poison Barb", "Poison"},
+	{"soft-sand", "Soft Sand", "Ground"},
+	{"sharp-beak", "Sharp Beak", "Flying"},
+	{"twisted-spoon", "Twisted Spoon", "Psychic"},
+	{"silver-powder", "Silver Powder", "Bug"},
+	{"hard-stone", "Hard Stone", "Rock"},
+	{"spell-tag", "Spell Tag", "Ghost"},
+	{"dragon-fang", "Dragon Fang", "Dragon"},
+	{"black-glasses", "Black Glasses", "Dark"},
+	{"metal-coat", "Metal Coat", "Steel"},
+	{"fairy-feather", "Fairy Feather", "Fairy"},
+}
+
+const typeBoost = 1.2
+
+// HeldItems are the items the engine knows, by ID.
+var HeldItems = map[string]HeldItem{
+	"leftovers": {Name: "Leftovers", EndTurn: func(holder *Combatant) int {
+		return max(holder.MaxHp/16, 1)
+	}},
+	"focus-sash": {Name: "Focus Sash", Survive: func(holder *Combatant, damage int) int {
+		// Chỉ giữ lại khi còn đầy máu, và chỉ một lần mỗi trận
+		if holder.Hp < holder.MaxHp || holder.ItemUsed {
+			return damage
+		}
+		holder.ItemUsed = true
+		return holder.Hp - 1
+	}},
+	"choice-band":  {Name: "Choice Band", Locks: true, Power: boostKind(AttackNormal, 1.5)},
+	"choice-specs": {Name: "Choice Specs", Locks: true, Power: boostKind(AttackSpecial, 1.5)},
+	"choice-scarf": {Name: "Choice Scarf", Locks: true, Speed: 1.5},
+}
+
+func init() {
+	for _, b := range TypeBoosters {
+		HeldItems[b.ID] = HeldItem{Name: b.Name, Power: boostType(b.Type)}
+	}
+}
+
+func boostKind(kind AttackKind, factor float32) func(Pokemon, AttackKind) float32 {
+	return func(_ Pokemon, k AttackKind) float32 {
+		if k == kind {
+			return factor
+		}
+		return 1
+	}
+}
+
+// boostType powers up special attacks, the ones that carry the holder's
+// types.
+func boostType(t string) func(Pokemon, AttackKind) float32 {
+	return func(holder Pokemon, k AttackKind) float32 {
+		if k != AttackSpecial {
+			return 1
+		}
+		for _, own := range holder.Types {
+			if own == t {
+				return typeBoost
+			}
+		}
+		return 1
+	}
+}
+
+// held returns the Pokémon's held item, if the engine knows it.
+func held(poke Pokemon) (HeldItem, bool) {
+	item, ok := HeldItems[poke.Item]
+	return item, ok
+}
+
+func speed(poke *Combatant) float32 {
+	s := float32(poke.Pokemon.Stats.Speed) * stage(poke.Stages.Speed)
+	if item, ok := held(poke.Pokemon); ok && item.Speed > 0 {
+		s *= item.Speed
+	}
+	if poke.Status == Paralysis {
+		s /= 2
+	}
+	return s
+}
+
+// endTurn runs the end of turn hooks of the Pokémon that acted, then the
+// field's.
+func (e *Engine) endTurn(side, slot int) []Event {
+	var events []Event
+	poke := e.Sides[side].At(slot)
+	if item, ok := held(poke.Pokemon); ok && item.EndTurn != nil && poke.Hp > 0 && poke.Hp < poke.MaxHp {
+		before := poke.Hp
+		poke.Hp = min(poke.Hp+item.EndTurn(poke), poke.MaxHp)
+		events = append(events, Event{Kind: EventHeldItem, Side: side, Slot: slot, Pokemon: poke.Pokemon.Name, Item: item.Name, Healed: poke.Hp - before, Hp: poke.Hp, MaxHp: poke.MaxHp})
+	}
+	return append(events, e.weather(side, slot)...)
+}
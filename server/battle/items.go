@@ -0,0 +1,77 @@
+package battle
+
+// Medicine is what an item does when used in battle. The action carries it,
+// so the engine needs no item list and a replay holds everything it needs.
+type Medicine struct {
+	Name   string `json:"Name"`
+	Heal   int    `json:"Heal,omitempty"`   // HP restored
+	Cure   bool   `json:"Cure,omitempty"`   // clears the status
+	Revive bool   `json:"Revive,omitempty"` // brings a fainted Pokémon back with half its HP
+}
+
+// problem tells why the medicine would do nothing for the Pokémon, or ""
+// when it works.
+func (m Medicine) problem(poke *Combatant) string {
+	name := poke.Pokemon.Name
+	switch {
+	case m.Revive && poke.Hp > 0:
+		return name + " has not fainted!"
+	case m.Revive:
+		return ""
+	case poke.Hp == 0:
+		return name + " has fainted! Only a revive can help it."
+	case m.Heal > 0 && poke.Hp < poke.MaxHp, m.Cure && poke.Status != "":
+		return ""
+	}
+	return "It would have no effect on " + name + "."
+}
+
+// useItem uses a medicine on the Pokémon that acts, or on the team member
+// named by Target. A revive without a target picks the first fainted one.
+// Like an attack it takes the turn.
+func (e *Engine) useItem(a Action) []Event {
+	current, reason := e.acting(a)
+	if current == nil {
+		return reject(a, reason)
+	}
+	if a.Item == nil {
+		return reject(a, "Unknown item.")
+	}
+	side := e.Sides[a.Side]
+
+	var target *Combatant
+	reason = "Invalid Pokémon ID. Please try again."
+	for _, poke := range side.Team {
+		if a.Target == "" && !a.Item.Revive && poke != current {
+			continue
+		}
+		if a.Target != "" && poke.Pokemon.ID != a.Target {
+			continue
+		}
+		if reason = a.Item.problem(poke); reason == "" {
+			target = poke
+			break
+		}
+	}
+	if target == nil {
+		if a.Target == "" && a.Item.Revive {
+			reason = "None of your Pokémon has fainted!"
+		}
+		return reject(a, reason)
+	}
+	e.Actions = append(e.Actions, a)
+
+	before := target.Hp
+	if a.Item.Revive {
+		target.Hp = max(target.MaxHp/2, 1)
+		target.Down = false
+	}
+	if a.Item.Heal > 0 {
+		target.Hp = min(target.Hp+a.Item.Heal, target.MaxHp)
+	}
+	if a.Item.Cure {
+		target.Status = ""
+	}
+	events := []Event{{Kind: EventItem, Side: a.Side, Slot: a.Slot, Pokemon: target.Pokemon.Name, Item: a.Item.Name, Healed: target.Hp - before, Hp: target.Hp, MaxHp: target.MaxHp}}
+	return e.afterTurn(a, append(events, e.endTurn(a.Side, a.Slot)...))
+}
@@ -0,0 +1,120 @@
+package battle
+
+// Pokemon is what the engine needs to know about one Pokémon.
+type Pokemon struct {
+	ID    string
+	Name  string
+	Level int
+	Types []string
+	Stats Stats
+	Item  string // ID of the held item, see HeldItems
+	// Ability is the name of its ability, see Abilities.
+	Ability string
+	// TypeDefense is the damage multiplier taken from each attacking type.
+	TypeDefense map[string]float32
+}
+
+type Stats struct {
+	Hp    int
+	Atk   int
+	Def   int
+	SpAtk int
+	SpDef int
+	Speed int
+}
+
+// AtLevel returns the stats at the level for these base stats, by the
+// games' formula without IVs or EVs. At level 50 they are close to the base
+// stats, which unleveled matches battle with.
+func (s Stats) AtLevel(level int) Stats {
+	level = max(1, min(level, 100))
+	stat := func(base int) int { return base*2*level/100 + 5 }
+	return Stats{
+		Hp:    s.Hp*2*level/100 + level + 10,
+		Atk:   stat(s.Atk),
+		Def:   stat(s.Def),
+		SpAtk: stat(s.SpAtk),
+		SpDef: stat(s.SpDef),
+		Speed: stat(s.Speed),
+	}
+}
+
+// Stages are the in-battle stat modifiers, from -6 to +6.
+type Stages struct {
+	Atk   int
+	Def   int
+	SpAtk int
+	SpDef int
+	Speed int
+}
+
+// Combatant is the per-battle state of one Pokémon. It is built fresh when
+// the match starts so damage never touches the player's collection.
+type Combatant struct {
+	Pokemon  Pokemon
+	Hp       int
+	MaxHp    int
+	Status   string
+	Sleep    int // turns it stays asleep
+	Stages   Stages
+	Revealed bool // seen by the opponent and spectators
+	ItemUsed bool // a once per battle held item has worked
+	Locked   bool // a Choice item keeps it on Choice until it switches out
+	Choice   AttackKind
+	Down     bool // its faint has been reported
+}
+
+func newCombatant(poke Pokemon) *Combatant {
+	return &Combatant{Pokemon: poke, Hp: poke.Stats.Hp, MaxHp: poke.Stats.Hp}
+}
+
+type AttackKind int
+
+const (
+	AttackRandom  AttackKind = -1
+	AttackNormal  AttackKind = 0
+	AttackSpecial AttackKind = 1
+)
+
+func (k AttackKind) String() string {
+	switch k {
+	case AttackNormal:
+		return "Normal"
+	case AttackSpecial:
+		return "Special"
+	}
+	return "Random"
+}
+
+// Damage returns the damage of a normal and of a special attack.
+func Damage(attacker, defender Pokemon) (int, int) {
+	// Tính sát thương tấn công thường (Normal Attack)
+	normal := float32(attacker.Stats.Atk) - float32(defender.Stats.Def)*0.5
+	if normal < 1 {
+		normal = 1 // Sát thương tối thiểu
+	}
+
+	// Tính sát thương tấn công đặc biệt (Special Attack)
+	typeDefense := float32(1.0) // Hệ số kháng mặc định
+	for _, atkType := range attacker.Types {
+		if def, ok := defender.TypeDefense[atkType]; ok {
+			if def > typeDefense { // Chọn hệ số kháng mạnh nhất
+				typeDefense = def
+			}
+		}
+	}
+
+	special := (float32(attacker.Stats.SpAtk) * typeDefense) - float32(defender.Stats.SpDef)*0.5
+	if special < 1 {
+		special = 1 // Sát thương tối thiểu
+	}
+
+	// Vật phẩm cầm tăng sát thương
+	if item, ok := held(attacker); ok && item.Power != nil {
+		normal *= item.Power(attacker, AttackNormal)
+		special *= item.Power(attacker, AttackSpecial)
+	}
+
+	// Trả về sát thương bình thường và đặc biệt
+	return int(normal), int(special)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Chat goes to everyone online (/say), to one player (/w) or to the match
+// the player is in or watching (/m). The lobby and the matches route the
+// lines to the sessions, and each session drops what its player muted.
+
+const chatUsage = "(Usage: /say <message>, /w <player> <message>, /m <message>, /mute [player], /unmute <player>)"
+
+// handleChat runs a chat command; rest is the message as it was typed.
+func (s *Session) handleChat(command, rest string) {
+	addr, conn := s.addr, s.conn
+	name := s.client.Name
+	// One line per message, so nobody can fake a server message
+	rest = strings.Join(strings.Fields(rest), " ")
+	switch command {
+	case "/mute":
+		s.mute(rest)
+		return
+	case "/unmute":
+		s.unmute(rest)
+		return
+	}
+
+	target := ""
+	if command == "/w" {
+		target, rest, _ = strings.Cut(rest, " ")
+		rest = strings.TrimSpace(rest)
+	}
+	if rest == "" {
+		sendMessageToClient("Invalid command!\n"+chatUsage, addr, conn)
+		return
+	}
+	if problem := s.allowChat(rest); problem != "" {
+		sendMessageToClient(problem, addr, conn)
+		return
+	}
+
+	switch command {
+	case "/say":
+		s.lobby.post(func(l *Lobby) { l.say(name, rest) })
+	case "/w":
+		s.lobby.post(func(l *Lobby) { l.whisper(name, target, rest) })
+	case "/m":
+		s.lobby.post(func(l *Lobby) { l.matchChat(name, rest) })
+	}
+}
+
+// allowChat checks the length of a message and how many the player sent
+// lately. It returns why the message is refused, or "".
+func (s *Session) allowChat(text string) string {
+	if n := len([]rune(text)); n > config.Chat.MaxLength {
+		return fmt.Sprintf("Message too long! %d characters, the limit is %d.", n, config.Chat.MaxLength)
+	}
+	now := time.Now()
+	window := time.Duration(config.Chat.RateWindow) * time.Second
+	recent := s.chatTimes[:0]
+	for _, t := range s.chatTimes {
+		if now.Sub(t) < window {
+			recent = append(recent, t)
+		}
+	}
+	s.chatTimes = recent
+	if len(s.chatTimes) >= config.Chat.RateCount {
+		return fmt.Sprintf("Slow down! You can send %d messages every %d seconds.", config.Chat.RateCount, config.Chat.RateWindow)
+	}
+	s.chatTimes = append(s.chatTimes, now)
+	return ""
+}
+
+// receiveChat shows a chat line unless its sender is muted.
+func (s *Session) receiveChat(from, line string) {
+	if s.client == nil || containsName(s.client.muted, from) {
+		return
+	}
+	sendMessageToClient(line, s.addr, s.conn)
+}
+
+func (s *Session) mute(name string) {
+	client := s.client
+	if name == "" {
+		if len(client.muted) == 0 {
+			sendMessageToClient("You have not muted anyone.", s.addr, s.conn)
+		} else {
+			sendMessageToClient("Muted players: "+strings.Join(client.muted, ", "), s.addr, s.conn)
+		}
+		return
+	}
+	if name == client.Name {
+		sendMessageToClient("Cannot mute yourself!", s.addr, s.conn)
+		return
+	}
+	if containsName(client.muted, name) {
+		sendMessageToClient(name+" is already muted.", s.addr, s.conn)
+		return
+	}
+	client.muted = append(client.muted, name)
+	saveClient(client)
+	sendMessageToClient("You muted "+name+".", s.addr, s.conn)
+}
+
+func (s *Session) unmute(name string) {
+	client := s.client
+	for i, muted := range client.muted {
+		if muted == name {
+			client.muted = append(client.muted[:i], client.muted[i+1:]...)
+			saveClient(client)
+			sendMessageToClient("You unmuted "+name+".", s.addr, s.conn)
+			return
+		}
+	}
+	sendMessageToClient(name+" is not muted.", s.addr, s.conn)
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// tell hands a chat line to the player's session. CPU players have none.
+func (p *Player) tell(from, line string) {
+	if s := p.session; s != nil {
+		go s.post(func() { s.receiveChat(from, line) })
+	}
+}
+
+func (l *Lobby) say(from, text string) {
+	line := fmt.Sprintf("[Lobby] %s: %s", from, text)
+	for _, p := range l.players {
+		p.tell(from, line)
+	}
+}
+
+func (l *Lobby) whisper(from, to, text string) {
+	sender := l.players[from]
+	if sender == nil {
+		return
+	}
+	if to == from {
+		sendMessageToClient("Cannot whisper to yourself!", sender.Addr, l.conn)
+		return
+	}
+	target := l.players[to]
+	if target == nil {
+		sendMessageToClient(to+" is not online.", sender.Addr, l.conn)
+		return
+	}
+	target.tell(from, fmt.Sprintf("[From %s] %s", from, text))
+	sendMessageToClient(fmt.Sprintf("[To %s] %s", to, text), sender.Addr, l.conn)
+}
+
+// matchChat sends a line to the players and spectators of the match the
+// sender plays or watches.
+func (l *Lobby) matchChat(from, text string) {
+	sender := l.players[from]
+	if sender == nil {
+		return
+	}
+	game := sender.game
+	if game == nil {
+		game = sender.watching
+	}
+	if game == nil {
+		sendMessageToClient("You are not in a match or watching one!", sender.Addr, l.conn)
+		return
+	}
+	game.post(func(g *Battle) { g.chat(from, text) })
+}
+
+func (game *Battle) chat(from, text string) {
+	line := fmt.Sprintf("[Match %d] %s: %s", game.ID, from, text)
+	for _, p := range game.Players {
+		p.tell(from, line)
+	}
+	for _, p := range game.Spectators {
+		p.tell(from, line)
+	}
+}
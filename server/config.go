@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"pokegame/server/rules"
+)
+
+// defaultConfigFile is read when it exists; server.example.json lists every
+// setting with its default.
+const defaultConfigFile = "server.json"
+
+// Config holds the server settings. Every value comes from, in increasing
+// priority: the defaults, the JSON config file, POKEGAME_* environment
+// variables and the command-line flags.
+type Config struct {
+	Network     NetworkConfig        `json:"Network"`
+	Gameplay    GameplayConfig       `json:"Gameplay"`
+	Storage     StorageConfig        `json:"Storage"`
+	Chat        ChatConfig           `json:"Chat"`
+	Matchmaking MatchmakingConfig    `json:"Matchmaking"`
+	Economy     EconomyConfig        `json:"Economy"`
+	Rules       map[string]rules.Set `json:"Rules"`  // rule sets by name; the file adds to the built-in ones or replaces them by name
+	Admins      []string             `json:"Admins"` // players who can use the admin commands
+}
+
+type NetworkConfig struct {
+	Listen     string `json:"Listen"`
+	ChunkSize  int    `json:"Chunk-Size"`  // largest datagram sent to a client
+	BufferSize int    `json:"Buffer-Size"` // largest datagram read from a client
+}
+
+type GameplayConfig struct {
+	Starter   string `json:"Starter"`    // Pokémon ID every new player starts with
+	RollCount int    `json:"Roll-Count"` // Pokémon drawn by one roll
+	IdleAfter int    `json:"Idle-After"` // seconds without a command before a player shows as idle
+	Rules     string `json:"Rules"`      // rule set of challenges and queues that name none
+}
+
+type StorageConfig struct {
+	Catalogue  string `json:"Catalogue"`
+	SaveDir    string `json:"Save-Dir"`
+	ReplayDir  string `json:"Replay-Dir"`
+	Ladder     string `json:"Ladder"`     // ratings of every player
+	Tournament string `json:"Tournament"` // the running tournament
+}
+
+type ChatConfig struct {
+	MaxLength  int `json:"Max-Length"`  // longest message, in characters
+	RateCount  int `json:"Rate-Count"`  // messages a player can send ...
+	RateWindow int `json:"Rate-Window"` // ... every this many seconds
+}
+
+type EconomyConfig struct {
+	StartingCoins int `json:"Starting-Coins"`
+	StartingBalls int `json:"Starting-Balls"`
+	WinReward     int `json:"Win-Reward"`   // coins for winning a battle
+	CatchReward   int `json:"Catch-Reward"` // coins for each Pokémon caught
+}
+
+type MatchmakingConfig struct {
+	BaseGap      int `json:"Base-Gap"`       // rating gap accepted right away
+	GapPerSecond int `json:"Gap-Per-Second"` // added for every second waited
+	MaxGap       int `json:"Max-Gap"`
+}
+
+// config is set once in main before any goroutine starts and only read
+// afterwards.
+var config = defaultConfig()
+
+func defaultConfig() Config {
+	return Config{
+		Network:     NetworkConfig{Listen: "localhost:8080", ChunkSize: 512, BufferSize: 1024},
+		Gameplay:    GameplayConfig{Starter: "#0001", RollCount: 4, IdleAfter: 300, Rules: "open"},
+		Storage:     StorageConfig{Catalogue: "data/pokedex.json", SaveDir: ".", ReplayDir: "replays", Ladder: "ladder.json", Tournament: "tournament.json"},
+		Chat:        ChatConfig{MaxLength: 200, RateCount: 5, RateWindow: 10},
+		Matchmaking: MatchmakingConfig{BaseGap: 100, GapPerSecond: 10, MaxGap: 1000},
+		Economy:     EconomyConfig{StartingCoins: 1000, StartingBalls: 5, WinReward: 300, CatchReward: 10},
+		Rules: map[string]rules.Set{
+			"open": {},
+			"standard": {MinTeam: 1, MaxTeam: 6, SpeciesClause: true, ItemClause: true, SleepClause: true, TurnSeconds: 60, BankSeconds: 600,
+				Banned: []string{"Mewtwo", "Lugia", "Ho-Oh", "Kyogre", "Groudon", "Rayquaza", "Dialga", "Palkia", "Giratina", "Arceus"}},
+			"flat":   {AutoLevel: 50, MinTeam: 3, MaxTeam: 6, SpeciesClause: true, ItemClause: true, SleepClause: true, TurnSeconds: 60, BankSeconds: 600},
+			"little": {LevelCap: 5, MinTeam: 1, MaxTeam: 6, SpeciesClause: true, TurnSeconds: 60, BankSeconds: 600},
+		},
+	}
+}
+
+// setting is one value that can be overridden by a flag or an environment
+// variable.
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	get   func(c *Config) string
+	set   func(c *Config, value string) error
+}
+
+func stringSetting(name, env, usage string, field func(c *Config) *string) setting {
+	return setting{name, env, usage,
+		func(c *Config) string { return *field(c) },
+		func(c *Config, value string) error { *field(c) = value; return nil },
+	}
+}
+
+// listSetting reads a comma-separated list.
+func listSetting(name, env, usage string, field func(c *Config) *[]string) setting {
+	return setting{name, env, usage,
+		func(c *Config) string { return strings.Join(*field(c), ",") },
+		func(c *Config, value string) error {
+			*field(c) = nil
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					*field(c) = append(*field(c), item)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func intSetting(name, env, usage string, field func(c *Config) *int) setting {
+	return setting{name, env, usage,
+		func(c *Config) string { return strconv.Itoa(*field(c)) },
+		func(c *Config, value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%s must be a number, got %q", name, value)
+			}
+			*field(c) = n
+			return nil
+		},
+	}
+}
+
+var settings = []setting{
+	stringSetting("listen", "POKEGAME_LISTEN", "UDP address to listen on",
+		func(c *Config) *string { return &c.Network.Listen }),
+	intSetting("chunk-size", "POKEGAME_CHUNK_SIZE", "largest datagram sent to a client, in bytes",
+		func(c *Config) *int { return &c.Network.ChunkSize }),
+	intSetting("buffer-size", "POKEGAME_BUFFER_SIZE", "largest datagram read from a client, in bytes",
+		func(c *Config) *int { return &c.Network.BufferSize }),
+	stringSetting("starter", "POKEGAME_STARTER", "ID of the Pokémon new players start with",
+		func(c *Config) *string { return &c.Gameplay.Starter }),
+	intSetting("roll-count", "POKEGAME_ROLL_COUNT", "number of Pokémon drawn by one roll",
+		func(c *Config) *int { return &c.Gameplay.RollCount }),
+	intSetting("idle-after", "POKEGAME_IDLE_AFTER", "seconds without a command before a player shows as idle",
+		func(c *Config) *int { return &c.Gameplay.IdleAfter }),
+	stringSetting("rules", "POKEGAME_RULES", "rule set of challenges and queues that name none",
+		func(c *Config) *string { return &c.Gameplay.Rules }),
+	stringSetting("catalogue", "POKEGAME_CATALOGUE", "Pokédex catalogue made by the crawler",
+		func(c *Config) *string { return &c.Storage.Catalogue }),
+	stringSetting("save-dir", "POKEGAME_SAVE_DIR", "folder of the player save files",
+		func(c *Config) *string { return &c.Storage.SaveDir }),
+	stringSetting("replay-dir", "POKEGAME_REPLAY_DIR", "folder of the match replays",
+		func(c *Config) *string { return &c.Storage.ReplayDir }),
+	stringSetting("ladder", "POKEGAME_LADDER", "file of the player ratings",
+		func(c *Config) *string { return &c.Storage.Ladder }),
+	stringSetting("tournament", "POKEGAME_TOURNAMENT", "file of the running tournament",
+		func(c *Config) *string { return &c.Storage.Tournament }),
+	intSetting("chat-max-length", "POKEGAME_CHAT_MAX_LENGTH", "longest chat message, in characters",
+		func(c *Config) *int { return &c.Chat.MaxLength }),
+	intSetting("chat-rate-count", "POKEGAME_CHAT_RATE_COUNT", "chat messages a player can send per rate window",
+		func(c *Config) *int { return &c.Chat.RateCount }),
+	intSetting("chat-rate-window", "POKEGAME_CHAT_RATE_WINDOW", "length of the chat rate window, in seconds",
+		func(c *Config) *int { return &c.Chat.RateWindow }),
+	intSetting("match-base-gap", "POKEGAME_MATCH_BASE_GAP", "rating gap the matchmaker accepts right away",
+		func(c *Config) *int { return &c.Matchmaking.BaseGap }),
+	intSetting("match-gap-per-second", "POKEGAME_MATCH_GAP_PER_SECOND", "rating gap added for every second in the queue",
+		func(c *Config) *int { return &c.Matchmaking.GapPerSecond }),
+	intSetting("match-max-gap", "POKEGAME_MATCH_MAX_GAP", "largest rating gap the matchmaker accepts",
+		func(c *Config) *int { return &c.Matchmaking.MaxGap }),
+	intSetting("starting-coins", "POKEGAME_STARTING_COINS", "coins new players start with",
+		func(c *Config) *int { return &c.Economy.StartingCoins }),
+	intSetting("starting-balls", "POKEGAME_STARTING_BALLS", "Poké Balls new players start with",
+		func(c *Config) *int { return &c.Economy.StartingBalls }),
+	intSetting("win-reward", "POKEGAME_WIN_REWARD", "coins for winning a battle",
+		func(c *Config) *int { return &c.Economy.WinReward }),
+	intSetting("catch-reward", "POKEGAME_CATCH_REWARD", "coins for each Pokémon caught",
+		func(c *Config) *int { return &c.Economy.CatchReward }),
+	listSetting("admins", "POKEGAME_ADMINS", "comma-separated players who can use the admin commands",
+		func(c *Config) *[]string { return &c.Admins }),
+}
+
+// configFlags registers the config flags and returns a loader to call after
+// flag.Parse.
+func configFlags() func() (Config, error) {
+	defaults := defaultConfig()
+	fileName := flag.String("config", defaultConfigFile, "JSON config file (env POKEGAME_CONFIG)")
+	for _, s := range settings {
+		flag.String(s.flag, s.get(&defaults), fmt.Sprintf("%s (env %s)", s.usage, s.env))
+	}
+	return func() (Config, error) {
+		explicit := false
+		flag.Visit(func(f *flag.Flag) { explicit = explicit || f.Name == "config" })
+		if env := os.Getenv("POKEGAME_CONFIG"); env != "" && !explicit {
+			*fileName, explicit = env, true
+		}
+		return loadConfig(*fileName, explicit)
+	}
+}
+
+// loadConfig layers the file, the environment and the flags that were set
+// over the defaults. A missing config file is only an error when it was
+// asked for.
+func loadConfig(fileName string, required bool) (Config, error) {
+	c := defaultConfig()
+	data, err := ioutil.ReadFile(fileName)
+	if err == nil {
+		if err := json.Unmarshal(data, &c); err != nil {
+			return c, fmt.Errorf("reading %s: %v", fileName, err)
+		}
+	} else if required || !os.IsNotExist(err) {
+		return c, err
+	}
+
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(s.env); ok {
+			if err := s.set(&c, value); err != nil {
+				return c, fmt.Errorf("%s: %v", s.env, err)
+			}
+		}
+	}
+	var flagErr error
+	flag.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name && flagErr == nil {
+				flagErr = s.set(&c, f.Value.String())
+			}
+		}
+	})
+	if flagErr != nil {
+		return c, flagErr
+	}
+	return c, c.validate()
+}
+
+// validate checks the settings that can be checked before the catalogue is
+// loaded and lists every problem at once.
+func (c Config) validate() error {
+	var problems []string
+	if _, _, err := net.SplitHostPort(c.Network.Listen); err != nil {
+		problems = append(problems, fmt.Sprintf("listen address %q: %v", c.Network.Listen, err))
+	}
+	// 65507 is the largest UDP payload over IPv4
+	if c.Network.ChunkSize < 64 || c.Network.ChunkSize > 65507 {
+		problems = append(problems, fmt.Sprintf("chunk size must be between 64 and 65507, got %d", c.Network.ChunkSize))
+	}
+	if c.Network.BufferSize < 64 || c.Network.BufferSize > 65507 {
+		problems = append(problems, fmt.Sprintf("buffer size must be between 64 and 65507, got %d", c.Network.BufferSize))
+	}
+	if !strings.HasPrefix(c.Gameplay.Starter, "#") {
+		problems = append(problems, fmt.Sprintf("starter must be a Pokémon ID like #0001, got %q", c.Gameplay.Starter))
+	}
+	if c.Gameplay.RollCount < 1 || c.Gameplay.RollCount > 20 {
+		problems = append(problems, fmt.Sprintf("roll count must be between 1 and 20, got %d", c.Gameplay.RollCount))
+	}
+	if c.Gameplay.IdleAfter < 1 {
+		problems = append(problems, fmt.Sprintf("idle after must be at least 1 second, got %d", c.Gameplay.IdleAfter))
+	}
+	if c.Storage.Catalogue == "" || c.Storage.SaveDir == "" || c.Storage.ReplayDir == "" || c.Storage.Ladder == "" || c.Storage.Tournament == "" {
+		problems = append(problems, "catalogue, save dir, replay dir, ladder and tournament cannot be empty")
+	}
+	if c.Chat.MaxLength < 1 || c.Chat.MaxLength > 1000 {
+		problems = append(problems, fmt.Sprintf("chat max length must be between 1 and 1000, got %d", c.Chat.MaxLength))
+	}
+	if c.Chat.RateCount < 1 || c.Chat.RateWindow < 1 {
+		problems = append(problems, fmt.Sprintf("chat rate count and window must be at least 1, got %d and %d", c.Chat.RateCount, c.Chat.RateWindow))
+	}
+	if c.Matchmaking.BaseGap < 0 || c.Matchmaking.GapPerSecond < 0 || c.Matchmaking.MaxGap < c.Matchmaking.BaseGap {
+		problems = append(problems, fmt.Sprintf("matchmaking gaps must not be negative and max gap must be at least base gap, got %d, %d and %d",
+			c.Matchmaking.BaseGap, c.Matchmaking.GapPerSecond, c.Matchmaking.MaxGap))
+	}
+	if e := c.Economy; e.StartingCoins < 0 || e.StartingBalls < 0 || e.WinReward < 0 || e.CatchReward < 0 {
+		problems = append(problems, fmt.Sprintf("starting coins, starting balls, win reward and catch reward must not be negative, got %d, %d, %d and %d",
+			e.StartingCoins, e.StartingBalls, e.WinReward, e.CatchReward))
+	}
+	for name, set := range c.Rules {
+		if name == "" || name != strings.ToLower(name) || strings.ContainsAny(name, " \t") {
+			problems = append(problems, fmt.Sprintf("rule set names must be one lowercase word, got %q", name))
+		}
+		if err := set.Validate(); err != nil {
+			problems = append(problems, fmt.Sprintf("rule set %s: %v", name, err))
+		}
+	}
+	if _, ok := c.Rules[c.Gameplay.Rules]; !ok {
+		problems = append(problems, fmt.Sprintf("default rule set %q is not one of the rule sets", c.Gameplay.Rules))
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// findStarter returns the configured starter from the catalogue.
+func findStarter() (Pokedex, bool) {
+	return findPokemon(config.Gameplay.Starter)
+}
+
+func printConfig(c Config) {
+	jsonData, _ := json.MarshalIndent(c, "", "  ")
+	fmt.Println("Effective config:\n" + string(jsonData))
+}
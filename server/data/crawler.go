@@ -28,6 +28,9 @@ type PokeInfo struct {
 	SpDef       int     `json:"Sp.Def"`
 	Speed       int     `json:"Speed"`
 	TypeDefense TypeDef `json:"Type-Defenses"`
+	// Abilities the species can have, and its hidden one
+	Abilities     []string `json:"Abilities,omitempty"`
+	HiddenAbility string   `json:"Hidden-Ability,omitempty"`
 }
 type TypeDef struct {
 	Normal   float32
@@ -189,6 +192,12 @@ func fetchDetailInfo(url string) PokeInfo {
 				}
 			}
 		}
+		// Hàng Abilities trong bảng vitals, chỉ lấy dạng đầu tiên của trang
+		if n.Type == html.ElementNode && n.Data == "tr" && strings.TrimSpace(extractOnce(n, "th")) == "Abilities" {
+			if len(pokeInfo.Abilities) == 0 && pokeInfo.HiddenAbility == "" {
+				pokeInfo.Abilities, pokeInfo.HiddenAbility = extractAbilities(n, false)
+			}
+		}
 		if n.Type == html.ElementNode && n.Data == "table" {
 			for _, attr := range n.Attr {
 				if attr.Key == "class" && attr.Val == "type-table type-table-pokedex" {
@@ -310,6 +319,31 @@ func extractStatNumber(n *html.Node, tagName, key, val string) []int {
 	}
 	return numbers
 }
+
+// extractAbilities reads the ability links of a vitals row. The hidden
+// ability is the one inside a <small> tag.
+func extractAbilities(n *html.Node, hidden bool) ([]string, string) {
+	var abilities []string
+	var hiddenAbility string
+	if n.Type == html.ElementNode && n.Data == "small" {
+		hidden = true
+	}
+	if n.Type == html.ElementNode && n.Data == "a" && strings.HasPrefix(extractStringElement(n, "a", "href"), "/ability/") {
+		name := strings.TrimSpace(extractOnce(n, "a"))
+		if hidden {
+			return nil, name
+		}
+		return []string{name}, ""
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		names, hiddenName := extractAbilities(c, hidden)
+		abilities = append(abilities, names...)
+		if hiddenName != "" {
+			hiddenAbility = hiddenName
+		}
+	}
+	return abilities, hiddenAbility
+}
 func extractRatioDef(n *html.Node) []int {
 	var percentg []int
 	if n.Type == html.ElementNode && n.Data == "td" {
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"pokegame/server/battle"
+)
+
+// Clients that send "events on" get, next to the usual text, the state they
+// show as structured events: one datagram "@event <kind> <json>" each. An
+// event is never split into chunks, so it must be read with a buffer of at
+// least 64 KiB.
+
+// BagEvent lists the Pokémon the player owns. A big bag does not fit one
+// datagram, so the Pokémon are sent in pages of bagPage: page 1 carries the
+// rest of the bag and the later pages only Pokémon. A client shows the bag
+// once it has every page.
+type BagEvent struct {
+	Page        int            `json:"Page"`  // from 1
+	Pages       int            `json:"Pages"` // pages of this bag
+	Pokemon     []BagEntry     `json:"Pokemon"`
+	Team        []string       `json:"Team"` // IDs of the team the player would battle with
+	ActiveParty string         `json:"Active-Party"`
+	Parties     []string       `json:"Parties"` // names of the player's parties
+	Coins       int            `json:"Coins"`
+	Items       map[string]int `json:"Items"` // count by item ID
+}
+
+type BagEntry struct {
+	ID      string   `json:"ID"`
+	Name    string   `json:"Name"`
+	Level   int      `json:"Level"`
+	Exp     int      `json:"Exp"`
+	Types   []string `json:"Types"`
+	Hp      int      `json:"HP"`
+	Atk     int      `json:"ATK"`
+	Def     int      `json:"DEF"`
+	Speed   int      `json:"Speed"`
+	Held    string   `json:"Held,omitempty"` // item ID
+	Ability string   `json:"Ability,omitempty"`
+}
+
+// LobbyEvent lists who is online and the live matches.
+type LobbyEvent struct {
+	Players []LobbyPlayer `json:"Players"`
+	Matches []LobbyMatch  `json:"Matches"`
+}
+
+type LobbyPlayer struct {
+	Name   string `json:"Name"`
+	Status string `json:"Status"` // online, idle, in queue, invited or in battle
+}
+
+type LobbyMatch struct {
+	ID       int    `json:"ID"`
+	Player1  string `json:"Player1"`
+	Player2  string `json:"Player2"`
+	Watching int    `json:"Watching"`
+}
+
+// BattleEvent is the match as one player sees it.
+type BattleEvent struct {
+	Match      int           `json:"Match"`
+	Format     battle.Format `json:"Format"`
+	YourTurn   bool          `json:"Your-Turn"`
+	MustSwitch bool          `json:"Must-Switch"`
+	Slot       int           `json:"Slot"` // your slot that acts or waits for a replacement
+	Over       bool          `json:"Over"`
+	Won        bool          `json:"Won"`
+	You        BattleSide    `json:"You"`
+	Opponent   BattleSide    `json:"Opponent"`
+	Moves      []Move        `json:"Moves"`
+	Field      battle.Field  `json:"Field"`
+}
+
+type BattleSide struct {
+	Player string          `json:"Player"`
+	Active int             `json:"Active"` // index into Team of the first slot
+	Slots  []int           `json:"Slots"`  // index into Team of each slot in battle
+	Team   []BattlePokemon `json:"Team"`
+	Timer  *BattleTimer    `json:"Timer,omitempty"` // only in timed matches
+}
+
+// BattleTimer is a player's time in seconds, as of when the event was sent.
+type BattleTimer struct {
+	Running bool `json:"Running"` // they have to act and their time is running
+	Turn    int  `json:"Turn"`    // left on this turn, 0 without a turn timer
+	Bank    int  `json:"Bank"`    // left on the chess clock, 0 without one
+}
+
+// BattlePokemon is a team member. Opponent Pokémon that were never sent out
+// only show as hidden.
+type BattlePokemon struct {
+	ID      string   `json:"ID,omitempty"`
+	Name    string   `json:"Name,omitempty"`
+	Level   int      `json:"Level,omitempty"`
+	Types   []string `json:"Types,omitempty"`
+	Hp      int      `json:"HP"`
+	MaxHp   int      `json:"Max-HP"`
+	Status  string   `json:"Status,omitempty"`
+	Item    string   `json:"Item,omitempty"`    // held item, own team only
+	Ability string   `json:"Ability,omitempty"` // own team only
+	Hidden  bool     `json:"Hidden,omitempty"`
+}
+
+// Move is an attack the active Pokémon can use, with the damage it would
+// deal to the opponent's active Pokémon, the weakest one in doubles.
+type Move struct {
+	Name   string `json:"Name"`
+	Damage int    `json:"Damage"`
+}
+
+func sendEvent(kind string, payload interface{}, addr *net.UDPAddr, conn *net.UDPConn) {
+	if addr == nil {
+		return
+	}
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		fmt.Println("Error marshalling event: ", err)
+		return
+	}
+	if _, err := conn.WriteToUDP([]byte("@event "+kind+" "+string(jsonData)), addr); err != nil {
+		fmt.Println("Error sending event:", err)
+	}
+}
+
+// bagPage is how many Pokémon a bag event holds, far under the size of a
+// datagram at about 150 bytes each.
+const bagPage = 200
+
+// bagEvents splits the bag into the events that send it.
+func bagEvents(client *Client) []BagEvent {
+	bag := bagEvent(client)
+	pages := max(1, (len(bag.Pokemon)+bagPage-1)/bagPage)
+	events := make([]BagEvent, pages)
+	for i := range events {
+		if i == 0 {
+			events[i] = bag
+		}
+		events[i].Page, events[i].Pages = i+1, pages
+		events[i].Pokemon = bag.Pokemon[i*bagPage : min(len(bag.Pokemon), (i+1)*bagPage)]
+	}
+	return events
+}
+
+func bagEvent(client *Client) BagEvent {
+	event := BagEvent{ActiveParty: client.activeParty, Coins: client.wallet.Coins, Items: client.wallet.Items}
+	for _, poke := range client.userPokedex {
+		info := poke.PokeInfo
+		event.Pokemon = append(event.Pokemon, BagEntry{
+			ID: poke.Id, Name: poke.Name, Level: poke.Level, Exp: poke.Exp, Types: poke.Types,
+			Hp: info.Hp, Atk: info.Atk, Def: info.Def, Speed: info.Speed, Held: poke.Held,
+			Ability: poke.Ability,
+		})
+	}
+	for _, party := range client.parties {
+		event.Parties = append(event.Parties, party.Name)
+	}
+	team, _ := battleTeam(client)
+	for _, poke := range team {
+		event.Team = append(event.Team, poke.Id)
+	}
+	return event
+}
+
+func (l *Lobby) lobbyEvent() LobbyEvent {
+	var event LobbyEvent
+	for _, p := range l.players {
+		event.Players = append(event.Players, LobbyPlayer{Name: p.Name, Status: p.presence()})
+	}
+	sort.Slice(event.Players, func(i, j int) bool { return event.Players[i].Name < event.Players[j].Name })
+	for _, game := range l.games {
+		event.Matches = append(event.Matches, LobbyMatch{
+			ID: game.ID, Player1: game.Players[0].Name, Player2: game.Players[1].Name, Watching: l.watchers(game),
+		})
+	}
+	sort.Slice(event.Matches, func(i, j int) bool { return event.Matches[i].ID < event.Matches[j].ID })
+	return event
+}
+
+// broadcastLobby sends the lobby to everyone who asked for events. It runs
+// whenever someone joins, leaves, pairs up or a match starts or ends.
+func (l *Lobby) broadcastLobby() {
+	event := l.lobbyEvent()
+	for _, p := range l.players {
+		if p.events {
+			sendEvent("lobby", event, p.Addr, l.conn)
+		}
+	}
+}
+
+// sendState sends the match to the players who asked for events.
+func (game *Battle) sendState() {
+	for side, player := range game.Players {
+		if game.events[side] {
+			sendEvent("battle", game.battleEvent(side), player.Addr, game.conn)
+		}
+	}
+}
+
+func (game *Battle) battleEvent(side int) BattleEvent {
+	engine := game.Engine
+	event := BattleEvent{
+		Match:      game.ID,
+		Format:     engine.Format,
+		YourTurn:   engine.Waiting(side) && !engine.MustSwitch(side),
+		MustSwitch: engine.MustSwitch(side),
+		Over:       engine.Over(),
+		Won:        engine.Winner == side,
+		You:        battleSide(game.Players[side], engine.Sides[side], false),
+		Opponent:   battleSide(game.Players[1-side], engine.Sides[1-side], true),
+	}
+	view := engine.View(side)
+	event.Slot = view.Slot
+	normal, special := engine.Preview(side, view.Slot, view.Foe, battle.AttackNormal), engine.Preview(side, view.Slot, view.Foe, battle.AttackSpecial)
+	event.Moves = []Move{{Name: battle.AttackNormal.String(), Damage: normal}, {Name: battle.AttackSpecial.String(), Damage: special}}
+	// Chiêu thời tiết và địa hình không gây sát thương
+	for _, id := range battle.MovesOf(view.Active.Pokemon) {
+		event.Moves = append(event.Moves, Move{Name: id})
+	}
+	event.Field = engine.Field
+	now := time.Now()
+	event.You.Timer, event.Opponent.Timer = game.clocks[side].view(now), game.clocks[1-side].view(now)
+	return event
+}
+
+func battleSide(player *Player, side *battle.Side, hideBench bool) BattleSide {
+	view := BattleSide{Player: player.Name, Active: side.Slots[0], Slots: side.Slots}
+	for _, poke := range side.Team {
+		if hideBench && !poke.Revealed {
+			view.Team = append(view.Team, BattlePokemon{Hidden: true})
+			continue
+		}
+		entry := BattlePokemon{
+			ID: poke.Pokemon.ID, Name: poke.Pokemon.Name, Level: poke.Pokemon.Level, Types: poke.Pokemon.Types,
+			Hp: poke.Hp, MaxHp: poke.MaxHp, Status: poke.Status,
+		}
+		if !hideBench {
+			entry.Item, entry.Ability = poke.Pokemon.Item, poke.Pokemon.Ability
+		}
+		view.Team = append(view.Team, entry)
+	}
+	return view
+}
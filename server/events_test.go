@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestBagEventsFitADatagram(t *testing.T) {
+	withCatalogue(t)
+	for _, owned := range []int{0, 1, bagPage, bagPage + 1, 2000} {
+		client := &Client{Name: "alice"}
+		for i := 0; i < owned; i++ {
+			poke := pokedex[i%len(pokedex)]
+			poke.Types, poke.Held, poke.Ability = []string{"Fairy", "Steel"}, "choice-scarf", "Effect Spore"
+			client.userPokedex = append(client.userPokedex, poke)
+		}
+		events := bagEvents(client)
+		total := 0
+		for i, event := range events {
+			data, err := json.Marshal(event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size := len("@event bag ") + len(data); size > 65507 {
+				t.Errorf("%d Pokémon: page %d is %d bytes", owned, i+1, size)
+			}
+			if event.Page != i+1 || event.Pages != len(events) {
+				t.Errorf("%d Pokémon: page %d says %d of %d", owned, i+1, event.Page, event.Pages)
+			}
+			total += len(event.Pokemon)
+		}
+		if total != owned {
+			t.Errorf("%d Pokémon: the pages hold %d", owned, total)
+		}
+		if want := max(1, (owned+bagPage-1)/bagPage); len(events) != want {
+			t.Errorf("%d Pokémon: %d pages, want %d", owned, len(events), want)
+		}
+		if fmt.Sprint(events[0].Coins, events[0].Items) != fmt.Sprint(client.wallet.Coins, client.wallet.Items) {
+			t.Errorf("%d Pokémon: the first page lost the wallet", owned)
+		}
+	}
+}
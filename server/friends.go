@@ -0,0 +1,309 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// FriendList is a player's friends file. It is kept apart from the save
+// file because the lobby changes it while the player is offline, when a
+// request arrives or a friend removes them.
+type FriendList struct {
+	Friends  []string  `json:"Friends"`
+	Requests []string  `json:"Requests"` // players waiting for an answer
+	LastSeen time.Time `json:"Last-Seen"`
+}
+
+const friendUsage = "(Usage: friends, friend add <player>, friend accept <player>, friend decline <player>, friend remove <player>)"
+
+func friendsPath(name string) string {
+	return playerFile(name, "_Friends.json")
+}
+
+// loadFriends reads the friends files of the players. Sessions call it
+// before handing the lists to the lobby, so the lobby never waits on disk.
+func loadFriends(names ...string) (map[string]*FriendList, error) {
+	lists := make(map[string]*FriendList)
+	for _, name := range names {
+		list := &FriendList{}
+		if validName(name) && fileExists(friendsPath(name)) {
+			if err := OpenFile(friendsPath(name), list); err != nil {
+				return nil, err
+			}
+		}
+		lists[name] = list
+	}
+	return lists, nil
+}
+
+// loadFriendsOf reads the player's list and the lists of everyone on it,
+// whose last seen the player list shows.
+func loadFriendsOf(name string) (map[string]*FriendList, error) {
+	lists, err := loadFriends(name)
+	if err != nil {
+		return nil, err
+	}
+	own := lists[name]
+	others, err := loadFriends(append(own.Friends, own.Requests...)...)
+	if err != nil {
+		return nil, err
+	}
+	for other, list := range others {
+		lists[other] = list
+	}
+	return lists, nil
+}
+
+// adoptFriends keeps the lists a session loaded. A list the lobby already
+// holds may have changes newer than the file, so it stays.
+func (l *Lobby) adoptFriends(lists map[string]*FriendList) {
+	for name, list := range lists {
+		if _, ok := l.friends[name]; !ok {
+			l.friends[name] = list
+		}
+	}
+}
+
+// forgetFriends drops the lists no online player needs. They are saved on
+// every change, so they are loaded again when needed.
+func (l *Lobby) forgetFriends() {
+	needed := make(map[string]bool)
+	for name := range l.players {
+		needed[name] = true
+		if list, ok := l.friends[name]; ok {
+			for _, other := range list.Friends {
+				needed[other] = true
+			}
+			for _, other := range list.Requests {
+				needed[other] = true
+			}
+		}
+	}
+	for name := range l.friends {
+		if !needed[name] {
+			delete(l.friends, name)
+		}
+	}
+}
+
+// friendList returns the player's friends. Sessions load the lists before
+// the lobby needs them, so a missing one belongs to a new player.
+func (l *Lobby) friendList(name string) *FriendList {
+	list, ok := l.friends[name]
+	if !ok {
+		list = &FriendList{}
+		l.friends[name] = list
+	}
+	return list
+}
+
+func (l *Lobby) saveFriends(name string) {
+	saveFile(friendsPath(name), l.friendList(name))
+}
+
+// accountExists reports whether someone ever joined with the name, from a
+// save still queued, the save file or an older Pokédex file. Sessions ask
+// it before posting to the lobby, so the lobby never waits on disk.
+func accountExists(name string) bool {
+	return validName(name) && (fileExists(savePath(name)) || fileExists(legacyPath(name)))
+}
+
+// friendCommand runs friends and friend <add|accept|decline|remove> <player>.
+// saved tells whether the named player has an account on disk.
+func (l *Lobby) friendCommand(name string, parts []string, saved bool) {
+	player := l.players[name]
+	if player == nil {
+		return
+	}
+	if parts[0] == "friends" {
+		sendMessageToClient(l.describeFriends(name), player.Addr, l.conn)
+		return
+	}
+	if len(parts) != 3 {
+		sendMessageToClient("Invalid command!\n"+friendUsage, player.Addr, l.conn)
+		return
+	}
+	var msg string
+	switch parts[1] {
+	case "add":
+		msg = l.addFriend(name, parts[2], saved)
+	case "accept":
+		msg = l.acceptFriend(name, parts[2])
+	case "decline":
+		msg = l.declineFriend(name, parts[2])
+	case "remove":
+		msg = l.removeFriend(name, parts[2])
+	default:
+		msg = "Invalid command!\n" + friendUsage
+	}
+	sendMessageToClient(msg, player.Addr, l.conn)
+	l.forgetFriends()
+}
+
+func (l *Lobby) addFriend(name, other string, saved bool) string {
+	if other == name {
+		return "Cannot add yourself!"
+	}
+	if _, online := l.players[other]; !online && !saved {
+		return "No player named " + other + "."
+	}
+	mine, theirs := l.friendList(name), l.friendList(other)
+	if containsName(mine.Friends, other) {
+		return other + " is already your friend."
+	}
+	if containsName(mine.Requests, other) {
+		// They asked first, so adding them back accepts
+		return l.acceptFriend(name, other)
+	}
+	if containsName(theirs.Requests, name) {
+		return "You already sent " + other + " a friend request."
+	}
+	theirs.Requests = append(theirs.Requests, name)
+	l.saveFriends(other)
+	l.tellFriend(other, name+" sent you a friend request (friend accept "+name+").")
+	return "Friend request sent to " + other + "."
+}
+
+func (l *Lobby) acceptFriend(name, other string) string {
+	mine, theirs := l.friendList(name), l.friendList(other)
+	if !containsName(mine.Requests, other) {
+		return other + " did not send you a friend request."
+	}
+	mine.Requests = removeName(mine.Requests, other)
+	mine.Friends = append(mine.Friends, other)
+	theirs.Friends = append(removeName(theirs.Friends, name), name)
+	theirs.Requests = removeName(theirs.Requests, name)
+	l.saveFriends(name)
+	l.saveFriends(other)
+	l.tellFriend(other, name+" accepted your friend request.")
+	return "You and " + other + " are now friends."
+}
+
+func (l *Lobby) declineFriend(name, other string) string {
+	mine := l.friendList(name)
+	if !containsName(mine.Requests, other) {
+		return other + " did not send you a friend request."
+	}
+	mine.Requests = removeName(mine.Requests, other)
+	l.saveFriends(name)
+	return "You declined " + other + "'s friend request."
+}
+
+func (l *Lobby) removeFriend(name, other string) string {
+	mine, theirs := l.friendList(name), l.friendList(other)
+	if containsName(theirs.Requests, name) {
+		theirs.Requests = removeName(theirs.Requests, name)
+		l.saveFriends(other)
+		return "You took back your friend request to " + other + "."
+	}
+	if !containsName(mine.Friends, other) {
+		return other + " is not your friend."
+	}
+	mine.Friends = removeName(mine.Friends, other)
+	theirs.Friends = removeName(theirs.Friends, name)
+	l.saveFriends(name)
+	l.saveFriends(other)
+	return other + " was removed from your friends."
+}
+
+func removeName(names []string, name string) []string {
+	var kept []string
+	for _, n := range names {
+		if n != name {
+			kept = append(kept, n)
+		}
+	}
+	return kept
+}
+
+func (l *Lobby) describeFriends(name string) string {
+	list := l.friendList(name)
+	msg := "Your friends:\n"
+	if len(list.Friends) == 0 {
+		msg += "No friends yet. (Usage: friend add <player>)\n"
+	}
+	for _, friend := range list.Friends {
+		msg += fmt.Sprintf("[Friend: %s] - %s\n", friend, l.presenceOf(friend))
+	}
+	if len(list.Requests) > 0 {
+		msg += "Friend requests: " + strings.Join(list.Requests, ", ") + "\n(Usage: friend accept <player> or friend decline <player>)\n"
+	}
+	return msg
+}
+
+// presenceOf describes a player who may be offline.
+func (l *Lobby) presenceOf(name string) string {
+	if p := l.players[name]; p != nil {
+		return p.presence()
+	}
+	return "offline, last seen " + lastSeen(l.friendList(name).LastSeen)
+}
+
+// presence is online, idle (no command for a while), in queue, invited or
+// in battle.
+func (p *Player) presence() string {
+	switch {
+	case p.game != nil:
+		return "in battle"
+	case p.rival != nil:
+		return "invited"
+	case p.queued != "":
+		return "in queue"
+	case p.session != nil && p.session.idleFor() > time.Duration(config.Gameplay.IdleAfter)*time.Second:
+		return "idle"
+	}
+	return "online"
+}
+
+func lastSeen(t time.Time) string {
+	if t.IsZero() {
+		return "a while ago"
+	}
+	since := time.Since(t)
+	switch {
+	case since < time.Minute:
+		return "just now"
+	case since < time.Hour:
+		return fmt.Sprintf("%dm ago", int(since.Minutes()))
+	case since < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(since.Hours()))
+	}
+	return t.Format("2006-01-02")
+}
+
+// tellFriend sends a friends notice to the player if they are online.
+func (l *Lobby) tellFriend(name, msg string) {
+	if p := l.players[name]; p != nil {
+		sendMessageToClient("[Friends] "+msg, p.Addr, l.conn)
+	}
+}
+
+// notifyFriends tells the player's friends who are online.
+func (l *Lobby) notifyFriends(name, msg string) {
+	for _, friend := range l.friendList(name).Friends {
+		l.tellFriend(friend, msg)
+	}
+}
+
+// cameOnline runs once the player logged in with the friends lists the
+// session loaded: friends hear about it and the player hears about the
+// requests that came while they were away.
+func (l *Lobby) cameOnline(name string, lists map[string]*FriendList) {
+	l.adoptFriends(lists)
+	l.notifyFriends(name, name+" is now online.")
+	if requests := l.friendList(name).Requests; len(requests) > 0 {
+		l.tellFriend(name, "Friend requests: "+strings.Join(requests, ", ")+" (friend accept <player>)")
+	}
+	l.startPairings()
+}
+
+// wentOffline keeps when the player was last seen. The lobby lets go of
+// the lists only the player needed.
+func (l *Lobby) wentOffline(name string) {
+	if list, ok := l.friends[name]; ok {
+		list.LastSeen = time.Now()
+		l.saveFriends(name)
+	}
+	l.forgetFriends()
+}
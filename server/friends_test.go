@@ -0,0 +1,77 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestPlayerFilesStayInTheSaveDir(t *testing.T) {
+	for _, name := range []string{"alice", "../alice", "a/b", `..\..\x`, ".."} {
+		for _, path := range []string{savePath(name), legacyPath(name), friendsPath(name)} {
+			if dir := filepath.Dir(path); dir != filepath.Clean(config.Storage.SaveDir) {
+				t.Errorf("%q is saved in %s", name, dir)
+			}
+		}
+	}
+	for name, want := range map[string]bool{"alice": true, "Bob_2": true, "": false, "../alice": false, "a/b": false, `a\b`: false, "..": false} {
+		if got := validName(name); got != want {
+			t.Errorf("validName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestLobbyForgetsFriendsOfPlayersWhoLeft(t *testing.T) {
+	l := newLobby(nil)
+	l.players["alice"] = &Player{Name: "alice"}
+	l.adoptFriends(map[string]*FriendList{
+		"alice": {Friends: []string{"bob"}},
+		"bob":   {Friends: []string{"alice", "carol"}},
+		"carol": {},
+	})
+	l.forgetFriends()
+	if _, ok := l.friends["carol"]; ok {
+		t.Error("kept the list of carol, whom no online player knows")
+	}
+	if _, ok := l.friends["bob"]; !ok {
+		t.Error("dropped the list of bob, a friend of alice")
+	}
+
+	// A list the lobby holds is newer than one read from disk
+	l.friends["alice"].Friends = append(l.friends["alice"].Friends, "dave")
+	l.adoptFriends(map[string]*FriendList{"alice": {}})
+	if got := len(l.friends["alice"].Friends); got != 2 {
+		t.Errorf("alice has %d friends, want 2", got)
+	}
+
+	delete(l.players, "alice")
+	l.forgetFriends()
+	if len(l.friends) != 0 {
+		t.Errorf("kept %d lists with nobody online", len(l.friends))
+	}
+}
+
+func TestAccountExists(t *testing.T) {
+	saved := config
+	t.Cleanup(func() { config = saved })
+	config = defaultConfig()
+	config.Storage.SaveDir = t.TempDir()
+
+	if err := writeFile(legacyPath("old"), []byte("[]")); err != nil {
+		t.Fatal(err)
+	}
+	// A first save still waiting for the saver
+	pendingMu.Lock()
+	pending[savePath("new")] = &pendingSave{data: []byte("{}"), count: 1}
+	pendingMu.Unlock()
+	t.Cleanup(func() {
+		pendingMu.Lock()
+		delete(pending, savePath("new"))
+		pendingMu.Unlock()
+	})
+
+	for name, want := range map[string]bool{"old": true, "new": true, "nobody": false} {
+		if got := accountExists(name); got != want {
+			t.Errorf("accountExists(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"pokegame/server/battle"
+)
+
+// Medicine only works in battle and takes the player's turn. Stones and
+// rare candies only work outside battles and change the owned Pokémon for
+// good. Either way an item is only gone once it did something.
+
+const useUsage = "(Usage: use <item> [#id])"
+
+// stoneEvolutions lists what each stone turns a Pokémon into, by ID.
+var stoneEvolutions = map[string]map[string]string{
+	"fire-stone":    {"#0037": "#0038", "#0058": "#0059", "#0133": "#0136", "#0513": "#0514"},
+	"water-stone":   {"#0061": "#0062", "#0090": "#0091", "#0120": "#0121", "#0133": "#0134", "#0271": "#0272", "#0515": "#0516"},
+	"thunder-stone": {"#0025": "#0026", "#0133": "#0135", "#0603": "#0604"},
+	"leaf-stone":    {"#0044": "#0045", "#0070": "#0071", "#0102": "#0103", "#0274": "#0275", "#0511": "#0512"},
+	"moon-stone":    {"#0030": "#0031", "#0033": "#0034", "#0035": "#0036", "#0039": "#0040", "#0300": "#0301", "#0517": "#0518"},
+}
+
+const maxLevel = 100
+
+func (s *Session) useItem(args []string) {
+	client := s.client
+	if len(args) < 1 || len(args) > 2 {
+		sendMessageToClient("Invalid command!\n"+useUsage, s.addr, s.conn)
+		return
+	}
+	item := findItem(args[0])
+	if item == nil {
+		sendMessageToClient("There is no item called "+args[0]+".", s.addr, s.conn)
+		return
+	}
+	if client.wallet.Items[item.ID] == 0 {
+		sendMessageToClient(fmt.Sprintf("You have no %s!\n(Usage: buy %s [qty])", item.Name, item.ID), s.addr, s.conn)
+		return
+	}
+	target := ""
+	if len(args) == 2 {
+		target = args[1]
+	}
+	name, id := client.Name, item.ID
+
+	switch item.Kind {
+	case "ball":
+		sendMessageToClient("Poké Balls are thrown when you roll.", s.addr, s.conn)
+	case "held":
+		sendMessageToClient(fmt.Sprintf("A %s works when a Pokémon holds it.\n(Usage: give %s #id)", item.Name, item.ID), s.addr, s.conn)
+	case "medicine":
+		// Taken now so it cannot be used twice, given back if the battle refuses it
+		client.wallet.take(id)
+		saveClient(client)
+		s.sendBag()
+		medicine := item.Medicine
+		medicine.Name = item.Name
+		s.lobby.post(func(l *Lobby) { l.useMedicine(name, id, medicine, target) })
+	default:
+		if target == "" {
+			sendMessageToClient(fmt.Sprintf("Which Pokémon should get the %s?\n(Usage: use %s #id)", item.Name, item.ID), s.addr, s.conn)
+			return
+		}
+		s.lobby.post(func(l *Lobby) { l.useOutside(name, id, target) })
+	}
+}
+
+// giveBack returns an item the battle did not use.
+func (s *Session) giveBack(id string) {
+	s.client.wallet.add(id, 1)
+	saveClient(s.client)
+	s.sendBag()
+}
+
+func (l *Lobby) useMedicine(name, id string, medicine battle.Medicine, target string) {
+	p := l.players[name]
+	if p == nil {
+		return
+	}
+	s := p.session
+	refund := func() { go s.post(func() { s.giveBack(id) }) }
+	if p.game == nil {
+		refund()
+		sendMessageToClient(fmt.Sprintf("You can only use a %s in battle.", medicine.Name), p.Addr, l.conn)
+		return
+	}
+	action := battle.Action{Side: p.game.side(p), Kind: battle.ActItem, Item: &medicine, Target: target}
+	p.game.post(func(g *Battle) {
+		events := g.Engine.Apply(action)
+		if len(events) == 1 && events[0].Kind == battle.EventRejected {
+			refund()
+		}
+		g.deliver(events)
+	})
+}
+
+// useOutside hands a stone or candy back to the session once it is sure
+// the player is not battling.
+func (l *Lobby) useOutside(name, id, target string) {
+	p := l.players[name]
+	if p == nil {
+		return
+	}
+	if p.game != nil {
+		sendMessageToClient("You cannot use a "+findItem(id).Name+" in battle!", p.Addr, l.conn)
+		return
+	}
+	s := p.session
+	go s.post(func() { s.applyItem(id, target) })
+}
+
+// applyItem uses a stone or a rare candy on the first owned Pokémon with
+// the ID it works on.
+func (s *Session) applyItem(id, target string) {
+	client := s.client
+	item := findItem(id)
+	if client.wallet.Items[id] == 0 {
+		sendMessageToClient("You have no "+item.Name+"!", s.addr, s.conn)
+		return
+	}
+	slot, found := -1, ""
+	for i, poke := range client.userPokedex {
+		if poke.Id != target {
+			continue
+		}
+		found = poke.Name
+		if item.Kind == "candy" && poke.Level < maxLevel || item.Kind == "stone" && stoneEvolutions[id][poke.Id] != "" {
+			slot = i
+			break
+		}
+	}
+	if found == "" {
+		sendMessageToClient("Pokemon "+target+" is not in your bag!", s.addr, s.conn)
+		return
+	}
+	if slot < 0 {
+		sendMessageToClient(fmt.Sprintf("The %s has no effect on %s.", item.Name, found), s.addr, s.conn)
+		return
+	}
+
+	owned := &client.userPokedex[slot]
+	var msg string
+	if item.Kind == "candy" {
+		// Kẹo cho đủ kinh nghiệm để lên một cấp
+		next, _ := getLevelExp(owned.Level)
+		owned.Exp = max(owned.Exp, next)
+		owned.Level++
+		msg = fmt.Sprintf("%s grew to level %d!", owned.Name, owned.Level)
+	} else {
+		evolved, ok := findPokemon(stoneEvolutions[id][owned.Id])
+		if !ok {
+			sendMessageToClient(fmt.Sprintf("The %s has no effect on %s.", item.Name, owned.Name), s.addr, s.conn)
+			return
+		}
+		evolved.Level, evolved.Exp, evolved.Held = owned.Level, owned.Exp, owned.Held
+		evolved.Ability = evolvedAbility(*owned, evolved)
+		msg = fmt.Sprintf("%s evolved into %s!", owned.Name, evolved.Name)
+		*owned = evolved
+	}
+	client.wallet.take(id)
+	saveClient(client)
+	s.publishTeam()
+	s.sendBag()
+	sendMessageToClient(msg, s.addr, s.conn)
+}
+
+// giveItem lets an owned Pokémon hold an item from the bag. What it held
+// before goes back to the bag. A match keeps the items its teams started
+// with.
+func giveItem(client *Client, args []string, addr *net.UDPAddr, conn *net.UDPConn) bool {
+	if len(args) != 2 {
+		sendMessageToClient("Invalid command!\n(Usage: give <item> #id)", addr, conn)
+		return false
+	}
+	item := findItem(args[0])
+	if item == nil || item.Kind != "held" {
+		sendMessageToClient(args[0]+" is not an item a Pokémon can hold.", addr, conn)
+		return false
+	}
+	if client.wallet.Items[item.ID] == 0 {
+		sendMessageToClient(fmt.Sprintf("You have no %s!\n(Usage: buy %s)", item.Name, item.ID), addr, conn)
+		return false
+	}
+	// Ưu tiên Pokémon chưa cầm gì
+	slot := -1
+	for i, poke := range client.userPokedex {
+		if poke.Id == args[1] && (slot < 0 || client.userPokedex[slot].Held != "" && poke.Held == "") {
+			slot = i
+		}
+	}
+	if slot < 0 {
+		sendMessageToClient("Pokemon "+args[1]+" is not in your bag!", addr, conn)
+		return false
+	}
+	owned := &client.userPokedex[slot]
+	msg := fmt.Sprintf("%s is now holding the %s.", owned.Name, item.Name)
+	if owned.Held != "" {
+		client.wallet.add(owned.Held, 1)
+		msg += fmt.Sprintf(" The %s went back to your bag.", heldName(owned.Held))
+	}
+	client.wallet.take(item.ID)
+	owned.Held = item.ID
+	saveClient(client)
+	sendMessageToClient(msg, addr, conn)
+	return true
+}
+
+func takeItem(client *Client, args []string, addr *net.UDPAddr, conn *net.UDPConn) bool {
+	if len(args) != 1 {
+		sendMessageToClient("Invalid command!\n(Usage: take #id)", addr, conn)
+		return false
+	}
+	found := false
+	for i, poke := range client.userPokedex {
+		if poke.Id != args[0] {
+			continue
+		}
+		found = true
+		if poke.Held == "" {
+			continue
+		}
+		owned := &client.userPokedex[i]
+		client.wallet.add(owned.Held, 1)
+		sendMessageToClient(fmt.Sprintf("You took the %s from %s.", heldName(owned.Held), owned.Name), addr, conn)
+		owned.Held = ""
+		saveClient(client)
+		return true
+	}
+	if !found {
+		sendMessageToClient("Pokemon "+args[0]+" is not in your bag!", addr, conn)
+	} else {
+		sendMessageToClient(args[0]+" is not holding anything.", addr, conn)
+	}
+	return false
+}
+
+func heldName(id string) string {
+	if item := findItem(id); item != nil {
+		return item.Name
+	}
+	return id
+}
+
+// findPokemon looks a species up in the catalogue.
+func findPokemon(id string) (Pokedex, bool) {
+	for _, poke := range pokedex {
+		if poke.Id == id {
+			return poke, true
+		}
+	}
+	return Pokedex{}, false
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"pokegame/server/rating"
+)
+
+// Standing is a player's place on the ladder: their Glicko-2 rating, their
+// record and their last rated matches. Only rated matches count; matches
+// against the CPU and unrated matches between friends leave it alone.
+type Standing struct {
+	rating.Rating
+	Wins    int          `json:"Wins"`
+	Losses  int          `json:"Losses"`
+	Draws   int          `json:"Draws"`
+	History []RatedMatch `json:"History"`
+}
+
+// RatedMatch is one line of a player's rating history.
+type RatedMatch struct {
+	Time     time.Time `json:"Time"`
+	Match    int       `json:"Match"`
+	Opponent string    `json:"Opponent"`
+	Result   string    `json:"Result"` // win, loss or draw
+	Before   float64   `json:"Before"`
+	After    float64   `json:"After"`
+}
+
+const (
+	ratingHistory = 20 // rated matches kept per player
+	ladderDefault = 10 // players shown by ladder without a number
+	ladderMax     = 100
+)
+
+// loadLadder reads the ratings saved by earlier runs.
+func loadLadder(l *Lobby) {
+	if _, err := os.Stat(config.Storage.Ladder); err == nil {
+		if err := OpenFile(config.Storage.Ladder, &l.ladder); err != nil {
+			fmt.Println("Error loading the ladder:", err)
+			os.Exit(1)
+		}
+	}
+	if l.ladder == nil {
+		l.ladder = make(map[string]*Standing)
+	}
+}
+
+// standing returns the player's standing, creating it at the default rating.
+func (l *Lobby) standing(name string) *Standing {
+	s, ok := l.ladder[name]
+	if !ok {
+		s = &Standing{Rating: rating.Default()}
+		l.ladder[name] = s
+	}
+	return s
+}
+
+// rateMatch updates both ratings after a rated match. winner is -1 for a
+// draw.
+func (l *Lobby) rateMatch(game *Battle, winner int) {
+	var before [2]rating.Rating
+	for side, player := range game.Players {
+		before[side] = l.standing(player.Name).Rating
+	}
+	for side, player := range game.Players {
+		score, result := 0.5, "draw"
+		if winner == side {
+			score, result = 1, "win"
+		} else if winner == 1-side {
+			score, result = 0, "loss"
+		}
+		s := l.standing(player.Name)
+		s.Rating = rating.Update(before[side], []rating.Result{{Opponent: before[1-side], Score: score}})
+		switch result {
+		case "win":
+			s.Wins++
+		case "loss":
+			s.Losses++
+		default:
+			s.Draws++
+		}
+		s.History = append(s.History, RatedMatch{
+			Time: time.Now(), Match: game.ID, Opponent: game.Players[1-side].Name, Result: result,
+			Before: before[side].Rating, After: s.Rating.Rating,
+		})
+		if len(s.History) > ratingHistory {
+			s.History = s.History[len(s.History)-ratingHistory:]
+		}
+		sendMessageToClient(fmt.Sprintf("Rating: %d → %d (%+d)", round(before[side].Rating), round(s.Rating.Rating),
+			round(s.Rating.Rating)-round(before[side].Rating)), player.Addr, l.conn)
+	}
+	saveFile(config.Storage.Ladder, l.ladder)
+}
+
+func round(x float64) int {
+	return int(math.Round(x))
+}
+
+// ranked lists the players with at least one rated match, best first.
+func (l *Lobby) ranked() []string {
+	var names []string
+	for name, s := range l.ladder {
+		if s.Wins+s.Losses+s.Draws > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := l.ladder[names[i]], l.ladder[names[j]]
+		if a.Rating.Rating != b.Rating.Rating {
+			return a.Rating.Rating > b.Rating.Rating
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+func (l *Lobby) showLadder(args []string, addr *net.UDPAddr) {
+	n := ladderDefault
+	if len(args) > 0 {
+		count, err := strconv.Atoi(args[0])
+		if err != nil || count < 1 || count > ladderMax {
+			sendMessageToClient(fmt.Sprintf("Invalid command!\n(Usage: ladder [1-%d])", ladderMax), addr, l.conn)
+			return
+		}
+		n = count
+	}
+	names := l.ranked()
+	if len(names) == 0 {
+		sendMessageToClient("Nobody has played a rated match yet.", addr, l.conn)
+		return
+	}
+	if len(names) > n {
+		names = names[:n]
+	}
+	msg := fmt.Sprintf("Ladder (top %d):\n", len(names))
+	for i, name := range names {
+		s := l.ladder[name]
+		msg += fmt.Sprintf("%d. %s %d ±%d - %dW %dL %dD\n", i+1, name, round(s.Rating.Rating), round(s.Deviation), s.Wins, s.Losses, s.Draws)
+	}
+	sendMessageToClient(msg, addr, l.conn)
+}
+
+// showProfile shows a player's ladder standing. saved tells whether they
+// have an account on disk.
+func (l *Lobby) showProfile(name string, saved bool, addr *net.UDPAddr) {
+	if _, online := l.players[name]; !online && !saved {
+		sendMessageToClient("No player named "+name+".", addr, l.conn)
+		return
+	}
+	s, ok := l.ladder[name]
+	if !ok || s.Wins+s.Losses+s.Draws == 0 {
+		sendMessageToClient(name+" has not played a rated match yet.", addr, l.conn)
+		return
+	}
+	rank := 0
+	names := l.ranked()
+	for i, n := range names {
+		if n == name {
+			rank = i + 1
+		}
+	}
+	msg := fmt.Sprintf("Profile of %s:\nRating: %d ±%d (volatility %.4f)\nRecord: %dW %dL %dD\nRank: %d of %d\nRecent rated matches:\n",
+		name, round(s.Rating.Rating), round(s.Deviation), s.Volatility, s.Wins, s.Losses, s.Draws, rank, len(names))
+	for i := len(s.History) - 1; i >= 0; i-- {
+		m := s.History[i]
+		msg += fmt.Sprintf("%s Match %d vs %s: %s %d → %d (%+d)\n", m.Time.Format("2006-01-02 15:04"), m.Match, m.Opponent,
+			m.Result, round(m.Before), round(m.After), round(m.After)-round(m.Before))
+	}
+	sendMessageToClient(msg, addr, l.conn)
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"pokegame/server/battle"
+	"pokegame/server/tournament"
+)
+
+// Player is the lobby's view of someone who joined: who they are, the team
+// their session published and who they are battling or watching. Only the
+// lobby goroutine changes it; matches read the name and address.
+type Player struct {
+	Name     string
+	Addr     *net.UDPAddr
+	session  *Session  // nil for CPU players
+	team     []Pokedex // the team the player would battle with right now
+	slots    []int     // index of each team member in the owner's userPokedex
+	rival    *Player   // opponent after an accepted invitation
+	game     *Battle
+	watching *Battle       // match followed as a spectator
+	events   bool          // the client asked for structured events
+	rated    bool          // the accepted challenge counts for the ladder
+	format   battle.Format // singles or doubles, from the accepted challenge
+	rules    string        // rule set of the challenge or queue
+	queued   string        // ranked or casual while in a matchmaking queue
+}
+
+// Challenge is a pending invitation and the terms it was sent with.
+type Challenge struct {
+	From   string
+	Rated  bool
+	Format battle.Format
+	Rules  string
+}
+
+// Lobby is the goroutine that owns everything shared between players: who
+// is online, the pending invitations and the live matches. Sessions and
+// matches talk to it by posting functions to its inbox.
+type Lobby struct {
+	conn        *net.UDPConn
+	players     map[string]*Player
+	invitations map[string]Challenge // by invitee
+	games       map[int]*Battle
+	nextMatchID int
+	friends     map[string]*FriendList // lists of the online players and of those on them
+	ladder      map[string]*Standing
+	matchmaker  *Matchmaker
+	tournament  *tournament.Tournament // nil when there is none
+	rulesWarned map[string]int         // tournament round each player was told their team breaks the rules
+	inbox       chan func(*Lobby)
+}
+
+func newLobby(conn *net.UDPConn) *Lobby {
+	return &Lobby{
+		conn:        conn,
+		players:     make(map[string]*Player),
+		invitations: make(map[string]Challenge),
+		games:       make(map[int]*Battle),
+		nextMatchID: 1,
+		friends:     make(map[string]*FriendList),
+		rulesWarned: make(map[string]int),
+		inbox:       make(chan func(*Lobby), 256),
+	}
+}
+
+func (l *Lobby) run() {
+	for f := range l.inbox {
+		f(l)
+	}
+}
+
+// post runs f in the lobby goroutine.
+func (l *Lobby) post(f func(*Lobby)) {
+	l.inbox <- f
+}
+
+// join reserves the name and lets the session load the player's save.
+func (l *Lobby) join(s *Session, username, password string) {
+	if _, exist := l.players[username]; exist {
+		sendMessageToClient("Invalid: Username already exists.", s.addr, l.conn)
+		return
+	}
+	l.players[username] = &Player{Name: username, Addr: s.addr, session: s}
+	l.broadcastLobby()
+	// Posted from its own goroutine so the lobby never waits on a busy session
+	go s.post(func() { s.load(username, password) })
+}
+
+// release frees a name the session reserved but could not log in with.
+func (l *Lobby) release(s *Session, username string) {
+	if p := l.players[username]; p != nil && p.session == s {
+		delete(l.players, username)
+		l.broadcastLobby()
+	}
+}
+
+// setEvents turns the structured events of a player on or off.
+func (l *Lobby) setEvents(name string, on bool) {
+	p := l.players[name]
+	if p == nil {
+		return
+	}
+	p.events = on
+	if on {
+		sendEvent("lobby", l.lobbyEvent(), p.Addr, l.conn)
+	}
+}
+
+// leave drops a player who quit. A match in progress is surrendered.
+func (l *Lobby) leave(name string) {
+	p := l.players[name]
+	if p == nil {
+		return
+	}
+	l.stopSpectating(p)
+	l.dequeue(p)
+	if p.game != nil {
+		l.submit(p, battle.Action{Kind: battle.ActSurrender})
+	} else if p.rival != nil {
+		sendMessageToClient(name+" left the game.", p.rival.Addr, l.conn)
+		p.rival.rival = nil
+	}
+	for invitee, challenge := range l.invitations {
+		if invitee == name || challenge.From == name {
+			delete(l.invitations, invitee)
+		}
+	}
+	delete(l.players, name)
+	l.wentOffline(name)
+	l.broadcastLobby()
+}
+
+// setTeam stores the team a session published for its player.
+func (l *Lobby) setTeam(s *Session, name string, team []Pokedex, slots []int) {
+	p := l.players[name]
+	if p == nil || p.session != s {
+		return
+	}
+	p.team = team
+	p.slots = slots
+	l.startPairings()
+}
+
+func (l *Lobby) list(senderName string, addr *net.UDPAddr) {
+	competitors := "Current player:\n"
+	for _, user := range l.players {
+		if user.Name != senderName {
+			competitors += fmt.Sprintf("[Player: %s] - %s\n", user.Name, user.presence())
+		}
+	}
+	// Friends who are away show when they were last seen
+	if senderName != "" {
+		offline := ""
+		for _, friend := range l.friendList(senderName).Friends {
+			if l.players[friend] == nil {
+				offline += fmt.Sprintf("[Player: %s] - %s\n", friend, l.presenceOf(friend))
+			}
+		}
+		if offline != "" {
+			competitors += "Friends offline:\n" + offline
+		}
+	}
+	sendMessageToClient(competitors, addr, l.conn)
+}
+
+// invite challenges another player. Unrated matches are only for friends,
+// so the ladder cannot be dodged.
+func (l *Lobby) invite(senderName, target string, rated bool, format battle.Format, ruleName string) {
+	sender := l.players[senderName]
+	if sender == nil {
+		return
+	}
+	addr := sender.Addr
+	if target == senderName {
+		sendMessageToClient("Cannot invite yourself!!!", addr, l.conn)
+		return
+	}
+	user := l.players[target]
+	if user == nil {
+		fmt.Println("Recipient not found:", target)
+		sendMessageToClient("NotFound", addr, l.conn)
+		return
+	}
+	if user.rival != nil {
+		sendMessageToClient(target+" is in battle, please try later!", addr, l.conn)
+		return
+	}
+	if !rated && !containsName(l.friendList(senderName).Friends, target) {
+		sendMessageToClient("Unrated matches are only for friends! Invite "+target+" to a rated match or send a friend request.", addr, l.conn)
+		return
+	}
+	l.invitations[target] = Challenge{From: senderName, Rated: rated, Format: format, Rules: ruleName}
+	sendMessageToClient("Waiting for your competitor!", addr, l.conn)
+	request := senderName + " send you a request to battle!(accept yes/no)\n"
+	if !rated {
+		request += "This match is unrated.\n"
+	}
+	if format == battle.Doubles {
+		request += "This is a double battle: bring at least 2 Pokémon.\n"
+	}
+	if ruleName != config.Gameplay.Rules {
+		request += fmt.Sprintf("Rules: %s (%s).\n", ruleName, config.Rules[ruleName])
+	}
+	sendMessageToClient(request, user.Addr, l.conn)
+}
+
+func (l *Lobby) accept(senderName string, yes bool) {
+	client := l.players[senderName]
+	if client == nil {
+		return
+	}
+	addr := client.Addr
+	challenge, ok := l.invitations[senderName]
+	inviterName := challenge.From
+	if !ok {
+		sendMessageToClient("You have no invitation to answer!", addr, l.conn)
+		return
+	}
+	delete(l.invitations, senderName)
+	user := l.players[inviterName]
+	if user == nil {
+		sendMessageToClient(inviterName+" is no longer online.", addr, l.conn)
+		return
+	}
+
+	if !yes {
+		sendMessageToClient("Your competitor is decline\nChoose another user or other task", user.Addr, l.conn)
+		sendMessageToClient("You decline successfull\nLet continue other tasks\n", addr, l.conn)
+		return
+	}
+	if user.rival != nil || client.rival != nil {
+		sendMessageToClient(inviterName+" is in battle, please try later!", addr, l.conn)
+		return
+	}
+	l.dequeue(user)
+	l.dequeue(client)
+	user.rival = client
+	client.rival = user
+	user.rated = challenge.Rated
+	client.rated = challenge.Rated
+	user.format = challenge.Format
+	client.format = challenge.Format
+	user.rules = challenge.Rules
+	client.rules = challenge.Rules
+	l.broadcastLobby()
+	sendMessageToClient(senderName+" has accepted the battle\n(Usage: p #id_pokemon1 #id_pokemon2 #id_pokemon3, or start to use your active party)\n", user.Addr, l.conn)
+	sendMessageToClient("You are join the battle!\n(Usage: p #id_pokemon1 #id_pokemon2 #id_pokemon3, or start to use your active party)\n", addr, l.conn)
+}
+
+func (l *Lobby) start(name string) {
+	player := l.players[name]
+	if player == nil {
+		return
+	}
+	addr := player.Addr
+	opponent := player.rival
+	if opponent == nil {
+		sendMessageToClient("You are not in the battle! Cannot use this command!", addr, l.conn)
+		return
+	}
+	if player.game != nil {
+		sendMessageToClient("A game is already in process.", addr, l.conn)
+		return
+	}
+	// Players who did not pick with p fight with their active party
+	if len(player.team) == 0 || len(opponent.team) == 0 {
+		sendMessageToClient("Both players must choose their pokemon first!\n(Usage: p #id_pokemon1 #id_pokemon2 #id_pokemon3 or party use <name>)", addr, l.conn)
+		return
+	}
+	if player.format == battle.Doubles && (len(player.team) < 2 || len(opponent.team) < 2) {
+		sendMessageToClient("A double battle needs at least 2 Pokémon on each team!\n(Usage: p #id_pokemon1 #id_pokemon2 #id_pokemon3 or party use <name>)", addr, l.conn)
+		return
+	}
+	// Khóa đội hình: kiểm tra luật của trận
+	for _, p := range []*Player{player, opponent} {
+		if err := checkTeam(player.rules, p.team); err != nil {
+			sendMessageToClient(brokenRules(player.rules, err), p.Addr, l.conn)
+			if p != player {
+				sendMessageToClient(fmt.Sprintf("%s's team breaks the %s rules! %v", p.Name, player.rules, err), addr, l.conn)
+			}
+			return
+		}
+	}
+
+	game := l.newBattle(player, opponent, player.team, opponent.team, player.slots, opponent.slots)
+	game.post(func(g *Battle) {
+		g.begin()
+	})
+}
+
+// attack lets the player attack with the given kind, or a random one. In
+// double battles aim and foe pick the target.
+func (l *Lobby) attack(name string, kind battle.AttackKind, aim battle.Aim, foe int) {
+	player := l.players[name]
+	if player == nil {
+		return
+	}
+	if player.game == nil {
+		sendMessageToClient("You are not in the battle! Cannot use this command!", player.Addr, l.conn)
+		return
+	}
+	l.submit(player, battle.Action{Kind: battle.ActAttack, Attack: kind, Aim: aim, Foe: foe})
+}
+
+// move uses a field move, by ID.
+func (l *Lobby) move(name, id string) {
+	player := l.players[name]
+	if player == nil {
+		return
+	}
+	if player.game == nil {
+		sendMessageToClient("You are not in the battle! Cannot use this command!", player.Addr, l.conn)
+		return
+	}
+	l.submit(player, battle.Action{Kind: battle.ActMove, Move: id})
+}
+
+// switchTo sends in the Pokémon. slot picks which fainted Pokémon it
+// replaces in a double battle.
+func (l *Lobby) switchTo(name, id string, slot int) {
+	player := l.players[name]
+	if player == nil {
+		return
+	}
+	if player.game == nil {
+		sendMessageToClient("No game in progress! Use @play to start a battle.", player.Addr, l.conn)
+		return
+	}
+	l.submit(player, battle.Action{Kind: battle.ActSwitch, SwitchTo: id, Slot: slot})
+}
+
+func (l *Lobby) surrender(name string) {
+	player := l.players[name]
+	if player == nil {
+		return
+	}
+	addr := player.Addr
+	if player.rival == nil {
+		sendMessageToClient("You are not in the battle! Cannot use this command!", addr, l.conn)
+		return
+	}
+	if player.game == nil {
+		sendMessageToClient("You are not already\n(Usage: @play to ready the battle)", addr, l.conn)
+		return
+	}
+	l.submit(player, battle.Action{Kind: battle.ActSurrender})
+}
+
+// submit hands the player's action to their match.
+func (l *Lobby) submit(player *Player, action battle.Action) {
+	game := player.game
+	action.Side = game.side(player)
+	game.post(func(g *Battle) { g.submit(action) })
+}
+
+// newBattle registers a match between two players and starts its goroutine.
+// The player's rule set decides the levels the teams battle at.
+func (l *Lobby) newBattle(player, opponent *Player, team1, team2 []Pokedex, slots1, slots2 []int) *Battle {
+	team1, team2 = ruleTeam(player.rules, team1), ruleTeam(player.rules, team2)
+	game := &Battle{
+		ID:      l.nextMatchID,
+		Players: [2]*Player{player, opponent},
+		Teams:   [2][]Pokedex{team1, team2},
+		Slots:   [2][]int{slots1, slots2},
+		Engine:  battle.New(player.format, toBattleTeam(team1), toBattleTeam(team2), rollRNG.Int63()),
+		Rules:   player.rules,
+		Rated:   player.rated && opponent.rated,
+		events:  [2]bool{player.events, opponent.events},
+		lobby:   l,
+		conn:    l.conn,
+		inbox:   make(chan func(*Battle), inboxSize),
+	}
+	game.Engine.SleepClause = config.Rules[player.rules].SleepClause
+	game.clocks = newClocks(config.Rules[player.rules], game.Players)
+	l.nextMatchID++
+	l.stopSpectating(player)
+	l.stopSpectating(opponent)
+	player.game = game
+	opponent.game = game
+	l.games[game.ID] = game
+	l.broadcastLobby()
+	go game.run()
+	return game
+}
+
+// endGame forgets a finished match, frees its players and sends the
+// results to their sessions. The match posts it once it has a winner.
+func (l *Lobby) endGame(game *Battle, winner, expReward int) {
+	delete(l.games, game.ID)
+	for _, p := range l.players {
+		if p.watching == game {
+			p.watching = nil
+		}
+	}
+
+	if game.Rated {
+		l.rateMatch(game, winner)
+	}
+	if game.Tournament {
+		l.tournamentResult(game, winner)
+	}
+
+	// The team is picked again for the next match
+	for side, player := range game.Players {
+		player.game = nil
+		player.rival = nil
+		player.rated = false
+		player.team = nil
+		player.slots = nil
+		if s := player.session; s != nil {
+			var slots []int
+			if side == winner {
+				slots = game.Slots[side]
+			}
+			go s.post(func() { s.finishMatch(slots, expReward) })
+		}
+	}
+	close(game.inbox)
+	l.broadcastLobby()
+	for side, player := range game.Players {
+		if player.session == nil {
+			continue
+		}
+		result := "finished"
+		if side == winner {
+			result = "won"
+		} else if winner == 1-side {
+			result = "lost"
+		}
+		l.notifyFriends(player.Name, fmt.Sprintf("%s %s a battle against %s.", player.Name, result, game.Players[1-side].Name))
+	}
+	l.startPairings()
+
+	fmt.Printf("[LOG] Game between %s and %s has been cleaned up.\n", game.Players[0].Name, game.Players[1].Name)
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"time"
+
+	"pokegame/server/battle"
+)
+
+// The matchmaker is the goroutine behind queue ranked and queue casual. It
+// owns the waiting players and pairs them by rating once a second; the
+// gap it allows grows with the time both have waited. The lobby posts the
+// players in and out, and the matchmaker posts every pair back to the
+// lobby, which starts the match.
+
+var queueModes = []string{"ranked", "casual"}
+
+type queueEntry struct {
+	name   string
+	rating float64
+	mode   string // ranked or casual
+	rules  string // only entries with the same rule set are paired
+	since  time.Time
+}
+
+type queueStats struct {
+	matches     int
+	totalWait   time.Duration
+	longestWait time.Duration
+}
+
+type Matchmaker struct {
+	lobby  *Lobby
+	queues map[string][]queueEntry // by mode
+	stats  map[string]*queueStats
+	inbox  chan func(*Matchmaker)
+}
+
+func newMatchmaker(lobby *Lobby) *Matchmaker {
+	m := &Matchmaker{
+		lobby:  lobby,
+		queues: make(map[string][]queueEntry),
+		stats:  make(map[string]*queueStats),
+		inbox:  make(chan func(*Matchmaker), 256),
+	}
+	for _, mode := range queueModes {
+		m.stats[mode] = &queueStats{}
+	}
+	return m
+}
+
+func (m *Matchmaker) run() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case f := <-m.inbox:
+			f(m)
+		case now := <-ticker.C:
+			m.pair(now)
+		}
+	}
+}
+
+// post runs f in the matchmaker goroutine.
+func (m *Matchmaker) post(f func(*Matchmaker)) {
+	m.inbox <- f
+}
+
+func (m *Matchmaker) add(entry queueEntry) {
+	m.remove(entry.name)
+	m.queues[entry.mode] = append(m.queues[entry.mode], entry)
+}
+
+func (m *Matchmaker) remove(name string) {
+	for mode, queue := range m.queues {
+		for i, entry := range queue {
+			if entry.name == name {
+				m.queues[mode] = append(queue[:i], queue[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// allowedGap is the rating difference a player accepts after waiting.
+func allowedGap(entry queueEntry, now time.Time) float64 {
+	gap := config.Matchmaking.BaseGap + config.Matchmaking.GapPerSecond*int(now.Sub(entry.since).Seconds())
+	return float64(min(gap, config.Matchmaking.MaxGap))
+}
+
+// pair matches the players who waited longest first, each with the closest
+// rating both of them accept.
+func (m *Matchmaker) pair(now time.Time) {
+	for mode, queue := range m.queues {
+		sort.Slice(queue, func(i, j int) bool { return queue[i].since.Before(queue[j].since) })
+		var waiting []queueEntry
+		paired := make([]bool, len(queue))
+		for i, a := range queue {
+			if paired[i] {
+				continue
+			}
+			best := -1
+			for j := i + 1; j < len(queue); j++ {
+				b := queue[j]
+				gap := math.Abs(a.rating - b.rating)
+				if paired[j] || a.rules != b.rules || gap > allowedGap(a, now) || gap > allowedGap(b, now) {
+					continue
+				}
+				if best < 0 || gap < math.Abs(a.rating-queue[best].rating) {
+					best = j
+				}
+			}
+			if best < 0 {
+				waiting = append(waiting, a)
+				continue
+			}
+			paired[i], paired[best] = true, true
+			b := queue[best]
+			m.record(mode, now.Sub(a.since))
+			m.record(mode, now.Sub(b.since))
+			go m.lobby.post(func(l *Lobby) { l.matchFound(a, b) })
+		}
+		m.queues[mode] = waiting
+	}
+}
+
+func (m *Matchmaker) record(mode string, wait time.Duration) {
+	s := m.stats[mode]
+	s.matches++
+	s.totalWait += wait
+	s.longestWait = max(s.longestWait, wait)
+}
+
+// sendStats reports the queues to an admin.
+func (m *Matchmaker) sendStats(addr *net.UDPAddr, conn *net.UDPConn) {
+	now := time.Now()
+	msg := "Queue stats:\n"
+	for _, mode := range queueModes {
+		queue := m.queues[mode]
+		var longest time.Duration
+		for _, entry := range queue {
+			longest = max(longest, now.Sub(entry.since))
+		}
+		s := m.stats[mode]
+		var average time.Duration
+		if s.matches > 0 {
+			average = s.totalWait / time.Duration(s.matches)
+		}
+		msg += fmt.Sprintf("[%s] waiting: %d (longest %s) - matched: %d players, average wait %s, longest wait %s\n",
+			mode, len(queue), longest.Round(time.Second), s.matches, average.Round(time.Second), s.longestWait.Round(time.Second))
+	}
+	sendMessageToClient(msg, addr, conn)
+}
+
+// queue runs queue ranked|casual|leave|stats for a player. The team is
+// checked against the rule set when they join.
+func (l *Lobby) queue(name, mode, ruleName string) {
+	p := l.players[name]
+	if p == nil {
+		return
+	}
+	switch mode {
+	case "leave":
+		if p.queued == "" {
+			sendMessageToClient("You are not in a queue!", p.Addr, l.conn)
+			return
+		}
+		l.dequeue(p)
+		sendMessageToClient("You left the queue.", p.Addr, l.conn)
+		return
+	case "stats":
+		if !containsName(config.Admins, name) {
+			sendMessageToClient("Only admins can see the queue stats!", p.Addr, l.conn)
+			return
+		}
+		addr := p.Addr
+		l.matchmaker.post(func(m *Matchmaker) { m.sendStats(addr, l.conn) })
+		return
+	}
+
+	if p.game != nil || p.rival != nil {
+		sendMessageToClient("You are already in a battle!", p.Addr, l.conn)
+		return
+	}
+	if len(p.team) == 0 {
+		sendMessageToClient("Choose your pokemon first!\n(Usage: p #id_pokemon1 #id_pokemon2 #id_pokemon3 or party use <name>)", p.Addr, l.conn)
+		return
+	}
+	if p.queued == mode && p.rules == ruleName {
+		sendMessageToClient("You are already in the "+mode+" queue.", p.Addr, l.conn)
+		return
+	}
+	if err := checkTeam(ruleName, p.team); err != nil {
+		sendMessageToClient(brokenRules(ruleName, err), p.Addr, l.conn)
+		return
+	}
+	entry := queueEntry{name: name, rating: l.standing(name).Rating.Rating, mode: mode, rules: ruleName, since: time.Now()}
+	p.queued = mode
+	p.rules = ruleName
+	l.matchmaker.post(func(m *Matchmaker) { m.add(entry) })
+	l.broadcastLobby()
+	sendMessageToClient(fmt.Sprintf("You joined the %s queue (%s rules) with rating %d. Waiting for an opponent...\n(Usage: queue leave)", mode, ruleName, round(entry.rating)), p.Addr, l.conn)
+}
+
+// dequeue takes the player out of their queue, if they are in one.
+func (l *Lobby) dequeue(p *Player) {
+	if p.queued == "" {
+		return
+	}
+	name := p.Name
+	p.queued = ""
+	l.matchmaker.post(func(m *Matchmaker) { m.remove(name) })
+	l.broadcastLobby()
+}
+
+// matchFound starts the match the matchmaker paired. A player who left, got
+// into another battle or dropped their team since is skipped, and the other
+// goes back to the queue with the time they already waited. One whose team
+// no longer fits is told they left the queue.
+func (l *Lobby) matchFound(a, b queueEntry) {
+	ready := func(e queueEntry) *Player {
+		p := l.players[e.name]
+		if p == nil || p.queued != e.mode || p.rules != e.rules || p.game != nil || p.rival != nil || len(p.team) == 0 {
+			return nil
+		}
+		// Đội hình có thể đã đổi khi đang chờ
+		if err := checkTeam(e.rules, p.team); err != nil {
+			return nil
+		}
+		return p
+	}
+	player, opponent := ready(a), ready(b)
+	if player == nil || opponent == nil {
+		for _, e := range []queueEntry{a, b} {
+			if p := ready(e); p != nil {
+				l.matchmaker.post(func(m *Matchmaker) { m.add(e) })
+			} else if p := l.players[e.name]; p != nil && p.queued == e.mode && p.rules == e.rules {
+				// Vẫn trong hàng chờ này, nên đội hình là lý do
+				p.queued = ""
+				sendMessageToClient("Your team changed, you left the queue.\n(Usage: queue ranked|casual [rules])", p.Addr, l.conn)
+				l.broadcastLobby()
+			}
+		}
+		return
+	}
+
+	player.queued, opponent.queued = "", ""
+	player.rival, opponent.rival = opponent, player
+	player.rated = a.mode == "ranked"
+	opponent.rated = player.rated
+	player.format, opponent.format = battle.Singles, battle.Singles
+	player.rules, opponent.rules = a.rules, a.rules
+	game := l.newBattle(player, opponent, player.team, opponent.team, player.slots, opponent.slots)
+	fmt.Printf("[LOG] Matchmaker paired %s and %s (%s, %s rules).\n", player.Name, opponent.Name, a.mode, a.rules)
+	game.post(func(g *Battle) {
+		for side, p := range g.Players {
+			other := g.Players[1-side]
+			sendMessageToClient(fmt.Sprintf("Match found! You are battling %s (%d) in a %s match.\n(Usage: attack [normal|special], switch #id, surrender)",
+				other.Name, round(queueRating(a, b, other.Name)), a.mode), p.Addr, g.conn)
+		}
+		g.begin()
+	})
+}
+
+func queueRating(a, b queueEntry, name string) float64 {
+	if a.name == name {
+		return a.rating
+	}
+	return b.rating
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+const (
+	minPartySize     = 1
+	maxPartySize     = 6
+	defaultPartySize = 3
+)
+
+// Party is a saved team. Members are indexes into the owner's userPokedex,
+// so two Pokémon of the same species stay apart.
+type Party struct {
+	Name    string `json:"Name"`
+	Size    int    `json:"Size"`
+	Members []int  `json:"Members"`
+	Lead    int    `json:"Lead"` // index into Members of the Pokémon sent out first
+}
+
+const partyUsage = "Usage:\n" +
+	"party list\n" +
+	"party create <name> [size 1-6]\n" +
+	"party add <name> #id\n" +
+	"party remove <name> #id\n" +
+	"party reorder <name> #id1 #id2 ...\n" +
+	"party lead <name> #id\n" +
+	"party size <name> <1-6>\n" +
+	"party use <name>\n" +
+	"party delete <name>\n"
+
+func handleParty(client *Client, args []string, addr *net.UDPAddr, conn *net.UDPConn) {
+	if len(args) == 0 || args[0] == "list" {
+		sendMessageToClient(listParties(client), addr, conn)
+		return
+	}
+	if len(args) < 2 {
+		sendMessageToClient(partyUsage, addr, conn)
+		return
+	}
+
+	name := args[1]
+	if args[0] == "create" {
+		if findParty(client, name) != nil {
+			sendMessageToClient("Party "+name+" already exists!", addr, conn)
+			return
+		}
+		size := defaultPartySize
+		if len(args) == 3 {
+			n, err := strconv.Atoi(args[2])
+			if err != nil || n < minPartySize || n > maxPartySize {
+				sendMessageToClient("Party size must be between 1 and 6!", addr, conn)
+				return
+			}
+			size = n
+		}
+		client.parties = append(client.parties, Party{Name: name, Size: size})
+		if client.activeParty == "" {
+			client.activeParty = name
+		}
+		saveClient(client)
+		sendMessageToClient(fmt.Sprintf("Party %s created with size %d.", name, size), addr, conn)
+		return
+	}
+
+	party := findParty(client, name)
+	if party == nil {
+		sendMessageToClient("Party "+name+" does not exist!", addr, conn)
+		return
+	}
+
+	switch args[0] {
+	case "add":
+		if len(args) != 3 {
+			sendMessageToClient(partyUsage, addr, conn)
+			return
+		}
+		if len(party.Members) >= party.Size {
+			sendMessageToClient(fmt.Sprintf("Party %s is full (%d/%d)!", name, len(party.Members), party.Size), addr, conn)
+			return
+		}
+		slot := findOwnedSlot(client, args[2], party.Members)
+		if slot < 0 {
+			sendMessageToClient("Poke you choose is not have in your pokedex!", addr, conn)
+			return
+		}
+		party.Members = append(party.Members, slot)
+		sendMessageToClient(fmt.Sprintf("%s added to party %s.", client.userPokedex[slot].Name, name), addr, conn)
+	case "remove":
+		if len(args) != 3 {
+			sendMessageToClient(partyUsage, addr, conn)
+			return
+		}
+		i := memberIndex(client, party, args[2])
+		if i < 0 {
+			sendMessageToClient(args[2]+" is not in party "+name+"!", addr, conn)
+			return
+		}
+		removed := client.userPokedex[party.Members[i]].Name
+		party.Members = append(party.Members[:i], party.Members[i+1:]...)
+		if party.Lead >= len(party.Members) || party.Lead == i {
+			party.Lead = 0
+		} else if party.Lead > i {
+			party.Lead--
+		}
+		sendMessageToClient(fmt.Sprintf("%s removed from party %s.", removed, name), addr, conn)
+	case "reorder":
+		if len(party.Members) == 0 {
+			sendMessageToClient("Party "+name+" is empty!", addr, conn)
+			return
+		}
+		if len(args)-2 != len(party.Members) {
+			sendMessageToClient(fmt.Sprintf("Please list all %d Pokémon of party %s in the new order!", len(party.Members), name), addr, conn)
+			return
+		}
+		lead := party.Members[party.Lead]
+		var members []int
+		for _, id := range args[2:] {
+			i := -1
+			for j, slot := range party.Members {
+				if client.userPokedex[slot].Id == id && !containsSlot(members, slot) {
+					i = j
+					break
+				}
+			}
+			if i < 0 {
+				sendMessageToClient(id+" is not in party "+name+"!", addr, conn)
+				return
+			}
+			members = append(members, party.Members[i])
+		}
+		party.Members = members
+		for i, slot := range members {
+			if slot == lead {
+				party.Lead = i
+			}
+		}
+		sendMessageToClient(describeParty(client, *party), addr, conn)
+	case "lead":
+		if len(args) != 3 {
+			sendMessageToClient(partyUsage, addr, conn)
+			return
+		}
+		i := memberIndex(client, party, args[2])
+		if i < 0 {
+			sendMessageToClient(args[2]+" is not in party "+name+"!", addr, conn)
+			return
+		}
+		party.Lead = i
+		sendMessageToClient(fmt.Sprintf("%s now leads party %s.", client.userPokedex[party.Members[i]].Name, name), addr, conn)
+	case "size":
+		if len(args) != 3 {
+			sendMessageToClient(partyUsage, addr, conn)
+			return
+		}
+		n, err := strconv.Atoi(args[2])
+		if err != nil || n < minPartySize || n > maxPartySize {
+			sendMessageToClient("Party size must be between 1 and 6!", addr, conn)
+			return
+		}
+		if n < len(party.Members) {
+			sendMessageToClient(fmt.Sprintf("Party %s has %d Pokémon, remove some first!", name, len(party.Members)), addr, conn)
+			return
+		}
+		party.Size = n
+		sendMessageToClient(fmt.Sprintf("Party %s size set to %d.", name, n), addr, conn)
+	case "use":
+		if len(party.Members) == 0 {
+			sendMessageToClient("Party "+name+" is empty!", addr, conn)
+			return
+		}
+		client.activeParty = name
+		sendMessageToClient("Party "+name+" is now your active party.", addr, conn)
+	case "delete":
+		for i := range client.parties {
+			if client.parties[i].Name == name {
+				client.parties = append(client.parties[:i], client.parties[i+1:]...)
+				break
+			}
+		}
+		if client.activeParty == name {
+			client.activeParty = ""
+		}
+		sendMessageToClient("Party "+name+" deleted.", addr, conn)
+	default:
+		sendMessageToClient(partyUsage, addr, conn)
+		return
+	}
+	saveClient(client)
+}
+
+// battleTeam returns the Pokémon the player picked with the p command, or
+// their active party with the lead first, along with their indexes in
+// userPokedex.
+func battleTeam(client *Client) ([]Pokedex, []int) {
+	slots := client.battlePoke
+	if len(slots) == 0 {
+		if party := findParty(client, client.activeParty); party != nil && len(party.Members) > 0 {
+			slots = append(slots, party.Members[party.Lead])
+			for i, slot := range party.Members {
+				if i != party.Lead {
+					slots = append(slots, slot)
+				}
+			}
+		}
+	}
+	var team []Pokedex
+	for _, slot := range slots {
+		team = append(team, client.userPokedex[slot])
+	}
+	return team, append([]int(nil), slots...)
+}
+
+func findParty(client *Client, name string) *Party {
+	for i := range client.parties {
+		if client.parties[i].Name == name {
+			return &client.parties[i]
+		}
+	}
+	return nil
+}
+
+// findOwnedSlot returns the first owned Pokémon with the given ID that is
+// not already taken, or -1.
+func findOwnedSlot(client *Client, id string, taken []int) int {
+	for i, poke := range client.userPokedex {
+		if poke.Id == id && !containsSlot(taken, i) {
+			return i
+		}
+	}
+	return -1
+}
+
+func memberIndex(client *Client, party *Party, id string) int {
+	for i, slot := range party.Members {
+		if client.userPokedex[slot].Id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func describeParty(client *Client, party Party) string {
+	msg := fmt.Sprintf("Party %s (%d/%d)", party.Name, len(party.Members), party.Size)
+	if party.Name == client.activeParty {
+		msg += " [active]"
+	}
+	msg += ":\n"
+	for i, slot := range party.Members {
+		poke := client.userPokedex[slot]
+		msg += fmt.Sprintf("%d. %s %s [Level: %d]", i+1, poke.Id, poke.Name, poke.Level)
+		if i == party.Lead {
+			msg += " (lead)"
+		}
+		msg += "\n"
+	}
+	return msg
+}
+
+func listParties(client *Client) string {
+	if len(client.parties) == 0 {
+		return "You have no party yet!\n" + partyUsage
+	}
+	msg := "Your parties:\n"
+	for _, party := range client.parties {
+		msg += describeParty(client, party)
+	}
+	return msg
+}
@@ -0,0 +1,108 @@
+// Package rating implements the Glicko-2 rating system
+// (http://www.glicko.net/glicko/glicko2.pdf). It is pure math: the server
+// decides which matches count and where ratings are stored.
+package rating
+
+import "math"
+
+const (
+	scale   = 173.7178 // between the Glicko and Glicko-2 scales
+	tau     = 0.5      // how fast the volatility may change
+	epsilon = 0.000001 // convergence of the volatility iteration
+)
+
+// Rating is a player's strength on the Glicko scale: the rating, its
+// deviation (the uncertainty) and the volatility.
+type Rating struct {
+	Rating     float64 `json:"Rating"`
+	Deviation  float64 `json:"Deviation"`
+	Volatility float64 `json:"Volatility"`
+}
+
+// Default is the rating of a player who never played a rated match.
+func Default() Rating {
+	return Rating{Rating: 1500, Deviation: 350, Volatility: 0.06}
+}
+
+// Result is one game of a rating period. Score is 1 for a win, 0.5 for a
+// draw and 0 for a loss.
+type Result struct {
+	Opponent Rating
+	Score    float64
+}
+
+// Update returns the player's rating after a rating period with the given
+// games. Without games only the deviation grows.
+func Update(r Rating, results []Result) Rating {
+	mu := (r.Rating - 1500) / scale
+	phi := r.Deviation / scale
+	sigma := r.Volatility
+
+	if len(results) == 0 {
+		phi = math.Sqrt(phi*phi + sigma*sigma)
+		return Rating{Rating: r.Rating, Deviation: math.Min(phi*scale, 350), Volatility: sigma}
+	}
+
+	var vInv, sum float64
+	for _, res := range results {
+		muJ := (res.Opponent.Rating - 1500) / scale
+		phiJ := res.Opponent.Deviation / scale
+		gJ := g(phiJ)
+		e := expected(mu, muJ, gJ)
+		vInv += gJ * gJ * e * (1 - e)
+		sum += gJ * (res.Score - e)
+	}
+	v := 1 / vInv
+	delta := v * sum
+
+	sigma = volatility(phi, sigma, v, delta)
+	phiStar := math.Sqrt(phi*phi + sigma*sigma)
+	phi = 1 / math.Sqrt(1/(phiStar*phiStar)+1/v)
+	mu += phi * phi * sum
+
+	return Rating{Rating: mu*scale + 1500, Deviation: phi * scale, Volatility: sigma}
+}
+
+func g(phi float64) float64 {
+	return 1 / math.Sqrt(1+3*phi*phi/(math.Pi*math.Pi))
+}
+
+func expected(mu, muJ, gJ float64) float64 {
+	return 1 / (1 + math.Exp(-gJ*(mu-muJ)))
+}
+
+// volatility finds the new volatility with the Illinois algorithm, step 5
+// of the paper.
+func volatility(phi, sigma, v, delta float64) float64 {
+	a := math.Log(sigma * sigma)
+	f := func(x float64) float64 {
+		ex := math.Exp(x)
+		d := phi*phi + v + ex
+		return ex*(delta*delta-d)/(2*d*d) - (x-a)/(tau*tau)
+	}
+
+	A := a
+	var B float64
+	if delta*delta > phi*phi+v {
+		B = math.Log(delta*delta - phi*phi - v)
+	} else {
+		k := 1.0
+		for f(a-k*tau) < 0 {
+			k++
+		}
+		B = a - k*tau
+	}
+
+	fA, fB := f(A), f(B)
+	for math.Abs(B-A) > epsilon {
+		C := A + (A-B)*fA/(fB-fA)
+		fC := f(C)
+		if fC*fB <= 0 {
+			A, fA = B, fB
+		} else {
+			fA /= 2
+		}
+		B, fB = C, fC
+	}
+	return math.Exp(A / 2)
+}
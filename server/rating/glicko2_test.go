@@ -0,0 +1,63 @@
+package rating
+
+import (
+	"math"
+	"testing"
+)
+
+func near(got, want, tolerance float64) bool {
+	return math.Abs(got-want) <= tolerance
+}
+
+// The worked example at the end of Glickman's paper.
+func TestUpdateMatchesThePaper(t *testing.T) {
+	player := Rating{Rating: 1500, Deviation: 200, Volatility: 0.06}
+	got := Update(player, []Result{
+		{Opponent: Rating{Rating: 1400, Deviation: 30, Volatility: 0.06}, Score: 1},
+		{Opponent: Rating{Rating: 1550, Deviation: 100, Volatility: 0.06}, Score: 0},
+		{Opponent: Rating{Rating: 1700, Deviation: 300, Volatility: 0.06}, Score: 0},
+	})
+	if !near(got.Rating, 1464.06, 0.01) || !near(got.Deviation, 151.52, 0.01) || !near(got.Volatility, 0.05999, 0.00001) {
+		t.Errorf("got %.2f / %.2f / %.5f, want 1464.06 / 151.52 / 0.05999", got.Rating, got.Deviation, got.Volatility)
+	}
+}
+
+func TestUpdateWithoutGames(t *testing.T) {
+	player := Rating{Rating: 1650, Deviation: 80, Volatility: 0.06}
+	got := Update(player, nil)
+	want := math.Sqrt(80*80 + 0.06*0.06*scale*scale)
+	if got.Rating != 1650 || got.Volatility != 0.06 || !near(got.Deviation, want, 1e-9) {
+		t.Errorf("got %+v, want the rating kept and deviation %.4f", got, want)
+	}
+	if got.Deviation <= player.Deviation {
+		t.Errorf("deviation went from %.2f to %.2f without games", player.Deviation, got.Deviation)
+	}
+}
+
+func TestDeviationIsCapped(t *testing.T) {
+	r := Rating{Rating: 1500, Deviation: 300, Volatility: 0.06}
+	for period := 0; period < 1000; period++ {
+		if r = Update(r, nil); r.Deviation > 350 {
+			t.Fatalf("period %d: deviation %.4f is above 350", period, r.Deviation)
+		}
+	}
+	if r.Deviation != 350 {
+		t.Errorf("deviation stopped at %.4f, want 350", r.Deviation)
+	}
+	if got := Update(Default(), nil); got != Default() {
+		t.Errorf("a new player idling became %+v", got)
+	}
+}
+
+func TestWinRaisesAndLossLowers(t *testing.T) {
+	opponent := Default()
+	won := Update(Default(), []Result{{Opponent: opponent, Score: 1}})
+	lost := Update(Default(), []Result{{Opponent: opponent, Score: 0}})
+	drawn := Update(Default(), []Result{{Opponent: opponent, Score: 0.5}})
+	if won.Rating <= 1500 || lost.Rating >= 1500 || !near(drawn.Rating, 1500, 1e-6) {
+		t.Errorf("win %.2f, loss %.2f, draw %.2f", won.Rating, lost.Rating, drawn.Rating)
+	}
+	if !near(won.Rating-1500, 1500-lost.Rating, 1e-6) || won.Deviation >= 350 {
+		t.Errorf("win %+v and loss %+v are not symmetric", won, lost)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"pokegame/server/battle"
+)
+
+const replayDelay = time.Second // pause between two streamed turns
+
+// Replay is everything needed to play a match again: the seed, both teams
+// and the ordered list of submitted actions. The outcome is kept to check
+// that a re-simulation reproduces it.
+type Replay struct {
+	ID       int             `json:"ID"`
+	Seed     int64           `json:"Seed"`
+	Format   battle.Format   `json:"Format,omitempty"` // singles when missing
+	Rules    string          `json:"Rules,omitempty"`
+	Sleep    bool            `json:"Sleep-Clause,omitempty"` // the engine's Sleep Clause, kept apart from the rule set, which can change
+	Player1  string          `json:"Player1"`
+	Player2  string          `json:"Player2"`
+	Team1    []Pokedex       `json:"Team1"`
+	Team2    []Pokedex       `json:"Team2"`
+	Actions  []battle.Action `json:"Actions"`
+	Winner   string          `json:"Winner"`
+	FinalHp1 []int           `json:"Final-HP1"`
+	FinalHp2 []int           `json:"Final-HP2"`
+}
+
+func replayPath(id int) string {
+	return filepath.Join(config.Storage.ReplayDir, strconv.Itoa(id)+".json")
+}
+
+// loadReplayIndex makes new match IDs follow the replays already on disk.
+func loadReplayIndex(l *Lobby) {
+	files, _ := filepath.Glob(filepath.Join(config.Storage.ReplayDir, "*.json"))
+	for _, file := range files {
+		id, err := strconv.Atoi(strings.TrimSuffix(filepath.Base(file), ".json"))
+		if err == nil && id >= l.nextMatchID {
+			l.nextMatchID = id + 1
+		}
+	}
+}
+
+func saveReplay(game *Battle) {
+	engine := game.Engine
+	replay := Replay{
+		ID:      game.ID,
+		Seed:    engine.Seed,
+		Format:  engine.Format,
+		Rules:   game.Rules,
+		Sleep:   engine.SleepClause,
+		Player1: game.Players[0].Name,
+		Player2: game.Players[1].Name,
+		Team1:   game.Teams[0],
+		Team2:   game.Teams[1],
+		Actions: engine.Actions,
+	}
+	if engine.Over() {
+		replay.Winner = game.Players[engine.Winner].Name
+	}
+	replay.FinalHp1 = finalHp(engine.Sides[0])
+	replay.FinalHp2 = finalHp(engine.Sides[1])
+	saveFile(replayPath(game.ID), replay)
+}
+
+func finalHp(side *battle.Side) []int {
+	var hp []int
+	for _, poke := range side.Team {
+		hp = append(hp, poke.Hp)
+	}
+	return hp
+}
+
+// simulateReplay plays the recorded actions again with the battle engine
+// and describes every turn.
+func simulateReplay(replay Replay) (*battle.Engine, []string, error) {
+	if len(replay.Team1) == 0 || len(replay.Team2) == 0 {
+		return nil, nil, fmt.Errorf("replay %d has an empty team", replay.ID)
+	}
+	names := [2]string{replay.Player1, replay.Player2}
+	engine := battle.New(replay.Format, toBattleTeam(replay.Team1), toBattleTeam(replay.Team2), replay.Seed)
+	engine.SleepClause = replay.Sleep
+	turns := []string{fmt.Sprintf("[Replay %d] %s sends out %s, %s sends out %s. %s goes first.",
+		replay.ID, names[0], leads(engine.Sides[0]), names[1], leads(engine.Sides[1]), names[engine.Turn])}
+	if opening := describeEvents(names, engine.Opening); opening != "" {
+		turns[0] += "\n" + opening
+	}
+	if field := describeField(engine.Field); field != "" {
+		turns[0] += "\n" + field
+	}
+
+	for i, action := range replay.Actions {
+		events := engine.Apply(action)
+		if len(events) == 1 && events[0].Kind == battle.EventRejected {
+			return engine, turns, fmt.Errorf("action %d was rejected: %s", i+1, events[0].Reason)
+		}
+		turn := fmt.Sprintf("[Replay %d] Turn %d: %s", replay.ID, i+1, describeEvents(names, events))
+		if field := describeField(engine.Field); field != "" {
+			turn += "\n" + field
+		}
+		turns = append(turns, turn)
+	}
+	return engine, turns, nil
+}
+
+func handleReplay(args []string, addr *net.UDPAddr, conn *net.UDPConn) {
+	if len(args) != 1 {
+		sendMessageToClient("Invalid command!\n(Usage: replay <matchID>)", addr, conn)
+		return
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		sendMessageToClient("Invalid match ID!", addr, conn)
+		return
+	}
+	if !fileExists(replayPath(id)) {
+		sendMessageToClient("Replay "+args[0]+" not found!", addr, conn)
+		return
+	}
+	var replay Replay
+	if err := OpenFile(replayPath(id), &replay); err != nil {
+		fmt.Printf("Error reading replay %d: %v\n", id, err)
+		sendMessageToClient(fmt.Sprintf("Replay %d is damaged and cannot be played.", id), addr, conn)
+		return
+	}
+	_, turns, err := simulateReplay(replay)
+	if err != nil {
+		sendMessageToClient(fmt.Sprintf("Replay %d is broken: %v", id, err), addr, conn)
+		return
+	}
+
+	// Stream in the background so the session keeps answering, one turn at a time
+	go func() {
+		for i, turn := range turns {
+			if i > 0 {
+				time.Sleep(replayDelay)
+			}
+			sendMessageToClient(turn, addr, conn)
+		}
+		sendMessageToClient(fmt.Sprintf("[Replay %d] End of replay.", id), addr, conn)
+	}()
+}
+
+// verifyReplay re-simulates a replay file offline and reports whether it
+// reproduces the recorded outcome. It returns the process exit code.
+func verifyReplay(fileName string) int {
+	var replay Replay
+	if err := OpenFile(fileName, &replay); err != nil {
+		fmt.Println("Error opening replay: ", err)
+		return 1
+	}
+	engine, turns, err := simulateReplay(replay)
+	for _, turn := range turns {
+		fmt.Println(turn)
+	}
+	if err != nil {
+		fmt.Println("Replay failed: ", err)
+		return 1
+	}
+
+	winner := ""
+	if engine.Over() {
+		winner = [2]string{replay.Player1, replay.Player2}[engine.Winner]
+	}
+	ok := winner == replay.Winner &&
+		fmt.Sprint(finalHp(engine.Sides[0])) == fmt.Sprint(replay.FinalHp1) &&
+		fmt.Sprint(finalHp(engine.Sides[1])) == fmt.Sprint(replay.FinalHp2)
+	if !ok {
+		fmt.Printf("Replay %d does NOT reproduce the recorded outcome (winner %q, recorded %q).\n", replay.ID, winner, replay.Winner)
+		return 1
+	}
+	fmt.Printf("Replay %d reproduces the recorded outcome.\n", replay.ID)
+	return 0
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// rollRNG drives the catalogue roller and hands out the seeds of new
+// matches, so a server started with the same -seed repeats the same rolls.
+// Sessions and the lobby draw from it concurrently.
+var rollRNG = newRNG(0)
+
+// newRNG returns a generator for the given seed that is safe for
+// concurrent use. A zero seed picks one from the clock.
+func newRNG(seed int64) *rand.Rand {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return rand.New(&lockedSource{src: rand.NewSource(seed).(rand.Source64)})
+}
+
+// lockedSource guards a rand.Source the way the math/rand top-level
+// functions do.
+type lockedSource struct {
+	mu  sync.Mutex
+	src rand.Source64
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Uint64() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Uint64()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.src.Seed(seed)
+}
+
+// trainerRNG gives a CPU trainer its own generator derived from the match
+// seed. Its choices end up in the action list, so they must not draw from
+// the battle generator that replays roll again.
+func trainerRNG(seed int64) *rand.Rand {
+	return rand.New(rand.NewSource(seed ^ 0x5eed))
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"pokegame/server/battle"
+)
+
+// testCatalogue stands in for the crawled catalogue: every ID the roller
+// can draw, each species with two abilities and a hidden one.
+func testCatalogue() []Pokedex {
+	var catalogue []Pokedex
+	for id := 1; id < 1025; id++ {
+		catalogue = append(catalogue, Pokedex{
+			Id:   fmt.Sprintf("#%04d", id),
+			Name: fmt.Sprintf("Mon%d", id),
+			PokeInfo: PokeInfo{
+				Hp: 40 + id%60, Atk: 40 + id%50, Def: 40 + id%45, Speed: 30 + id%70,
+				Abilities:     []string{"Static", "Sturdy"},
+				HiddenAbility: "Effect Spore",
+			},
+		})
+	}
+	return catalogue
+}
+
+func withCatalogue(t *testing.T) {
+	t.Helper()
+	savedConfig, savedPokedex := config, pokedex
+	config, pokedex = defaultConfig(), testCatalogue()
+	t.Cleanup(func() { config, pokedex = savedConfig, savedPokedex })
+}
+
+func rolled(pokes []Pokedex) []string {
+	var out []string
+	for _, poke := range pokes {
+		out = append(out, poke.Id+" "+poke.Ability)
+	}
+	return out
+}
+
+func TestRollPokeSameSeedSameRolls(t *testing.T) {
+	withCatalogue(t)
+	for _, seed := range []int64{1, 42, 2024} {
+		a, b := newRNG(seed), newRNG(seed)
+		for round := 0; round < 5; round++ {
+			first, second := rolled(RollPoke(Pokedex{}, a)), rolled(RollPoke(Pokedex{}, b))
+			if !reflect.DeepEqual(first, second) {
+				t.Fatalf("seed %d round %d: %v and %v", seed, round, first, second)
+			}
+			if len(first) != config.Gameplay.RollCount {
+				t.Fatalf("seed %d round %d: rolled %d Pokémon, want %d", seed, round, len(first), config.Gameplay.RollCount)
+			}
+		}
+	}
+}
+
+// The rolls of a seed must never change: saved replays and servers started
+// with -seed rely on them.
+func TestRollPokeRegression(t *testing.T) {
+	withCatalogue(t)
+	got := rolled(RollPoke(Pokedex{}, newRNG(42)))
+	want := []string{"#0178 Static", "#0575 Sturdy", "#0934 Static", "#0468 Sturdy"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("seed 42 rolled %q, want %q", got, want)
+	}
+}
+
+func TestTrainerRNGFollowsTheMatchSeed(t *testing.T) {
+	a, b := trainerRNG(7), trainerRNG(7)
+	for i := 0; i < 20; i++ {
+		if x, y := a.Int63(), b.Int63(); x != y {
+			t.Fatalf("draw %d: %d and %d", i, x, y)
+		}
+	}
+	if trainerRNG(7).Int63() == newRNG(7).Int63() {
+		t.Error("the trainer draws the same numbers as the battle generator")
+	}
+}
+
+func testTeam(seed int64) []battle.Pokemon {
+	rng := newRNG(seed)
+	var team []battle.Pokemon
+	for i := 0; i < 3; i++ {
+		team = append(team, battle.Pokemon{
+			ID: fmt.Sprintf("#%04d", i+1), Name: fmt.Sprintf("Mon%d", i+1), Level: 1, Types: []string{"Normal"},
+			Stats: battle.Stats{Hp: 60 + rng.Intn(40), Atk: 40 + rng.Intn(40), Def: 40 + rng.Intn(40), SpAtk: 40 + rng.Intn(40), SpDef: 40 + rng.Intn(40), Speed: 30 + rng.Intn(60)},
+		})
+	}
+	return team
+}
+
+// damages plays attacks until the match ends and returns every hit.
+func damages(seed int64) []int {
+	e := battle.New(battle.Singles, testTeam(1), testTeam(2), seed)
+	var hits []int
+	for turn := 0; turn < 200 && !e.Over(); turn++ {
+		side := 0
+		if !e.Waiting(side) {
+			side = 1
+		}
+		action := battle.Action{Side: side, Kind: battle.ActAttack, Attack: battle.AttackRandom}
+		if e.MustSwitch(side) {
+			view := e.View(side)
+			action = battle.Action{Side: side, Kind: battle.ActSwitch, Slot: view.Slot, SwitchTo: view.Bench[0].Pokemon.ID}
+		}
+		for _, ev := range e.Apply(action) {
+			if ev.Kind == battle.EventAttack {
+				hits = append(hits, ev.Damage)
+			}
+		}
+	}
+	return hits
+}
+
+func TestDamageSameSeedSameHits(t *testing.T) {
+	for _, seed := range []int64{3, 99, 123456} {
+		if a, b := damages(seed), damages(seed); !reflect.DeepEqual(a, b) || len(a) == 0 {
+			t.Fatalf("seed %d: %v and %v", seed, a, b)
+		}
+	}
+}
+
+func TestDamageRegression(t *testing.T) {
+	got := damages(99)
+	want := []int{27, 19, 21, 42, 38, 28, 13, 34, 25, 16, 24, 29, 20, 39, 36, 41, 11, 41}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("seed 99 dealt %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+
+	"pokegame/server/battle"
+	"pokegame/server/rules"
+)
+
+// A challenge or a queue names the rule set its matches are played under,
+// or gets config.Gameplay.Rules. Teams are checked when they are locked in:
+// when the match starts, or when the player joins a queue.
+
+// checkTeam returns the first rule of the named rule set the team breaks.
+func checkTeam(name string, team []Pokedex) error {
+	var members []rules.Member
+	for _, poke := range team {
+		item := ""
+		if poke.Held != "" {
+			item = heldName(poke.Held)
+		}
+		members = append(members, rules.Member{Name: poke.Name, Level: poke.Level, Item: item})
+	}
+	return config.Rules[name].Check(members)
+}
+
+// ruleTeam returns the team as it battles under the rule set: at the set's
+// auto level, with the stats of that level. The player's own Pokémon keep
+// their level and stats.
+func ruleTeam(name string, team []Pokedex) []Pokedex {
+	set := config.Rules[name]
+	leveled := make([]Pokedex, len(team))
+	for i, poke := range team {
+		poke.Level = set.Level(poke.Level)
+		if set.Leveled() {
+			info := &poke.PokeInfo
+			stats := battle.Stats{Hp: info.Hp, Atk: info.Atk, Def: info.Def, SpAtk: info.SpAtk, SpDef: info.SpDef, Speed: info.Speed}.AtLevel(poke.Level)
+			info.Hp, info.Atk, info.Def, info.SpAtk, info.SpDef, info.Speed = stats.Hp, stats.Atk, stats.Def, stats.SpAtk, stats.SpDef, stats.Speed
+		}
+		leveled[i] = poke
+	}
+	return leveled
+}
+
+// brokenRules tells a player which rule their team breaks.
+func brokenRules(name string, err error) string {
+	return fmt.Sprintf("Your team breaks the %s rules! %v\n(Usage: rules %s to see them)", name, err, name)
+}
+
+// showRules lists the rule sets, or the rules of one.
+func showRules(args []string, addr *net.UDPAddr, conn *net.UDPConn) {
+	if len(args) == 1 {
+		set, ok := config.Rules[args[0]]
+		if !ok {
+			sendMessageToClient("Unknown rule set "+args[0]+"! Type rules to see them.", addr, conn)
+			return
+		}
+		sendMessageToClient(fmt.Sprintf("Rule set %s: %s", args[0], set), addr, conn)
+		return
+	}
+	var names []string
+	for name := range config.Rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	msg := "Rule sets:\n"
+	for _, name := range names {
+		msg += fmt.Sprintf("  %s: %s", name, config.Rules[name])
+		if name == config.Gameplay.Rules {
+			msg += " (default)"
+		}
+		msg += "\n"
+	}
+	sendMessageToClient(msg+"(Usage: 4 <username> [rated|unrated] [singles|doubles] [rules], queue ranked|casual [rules])", addr, conn)
+}
@@ -0,0 +1,151 @@
+// Package rules holds the rule sets a match can be played under. A team is
+// checked against its rule set when it is locked in; the clauses that work
+// during the match, like Sleep Clause, are handed to the battle engine by
+// the server.
+package rules
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Set is one named rule set. The zero value allows everything.
+type Set struct {
+	LevelCap      int      `json:"Level-Cap,omitempty"`      // highest level allowed, 0 for none
+	AutoLevel     int      `json:"Auto-Level,omitempty"`     // every Pokémon battles at this level instead, 0 keeps theirs
+	SpeciesClause bool     `json:"Species-Clause,omitempty"` // no two Pokémon of the same species
+	ItemClause    bool     `json:"Item-Clause,omitempty"`    // no two Pokémon holding the same item
+	SleepClause   bool     `json:"Sleep-Clause,omitempty"`   // only one opposing Pokémon can be put to sleep at a time
+	Banned        []string `json:"Banned,omitempty"`         // species that cannot battle
+	MinTeam       int      `json:"Min-Team,omitempty"`
+	MaxTeam       int      `json:"Max-Team,omitempty"`     // 0 for no limit
+	TurnSeconds   int      `json:"Turn-Seconds,omitempty"` // time to act each turn before the default action is played, 0 for none
+	BankSeconds   int      `json:"Bank-Seconds,omitempty"` // chess clock over the whole match, the player forfeits when it runs out, 0 for none
+}
+
+// Member is what the rules need to know about one team member.
+type Member struct {
+	Name  string // species
+	Level int
+	Item  string // held item, "" for none
+}
+
+// Violation is a rule a team breaks. Rule names the rule, Detail says how.
+type Violation struct {
+	Rule   string
+	Detail string
+}
+
+func (v *Violation) Error() string {
+	return v.Rule + ": " + v.Detail
+}
+
+// Timed reports whether matches under the set have a timer.
+func (s Set) Timed() bool {
+	return s.TurnSeconds > 0 || s.BankSeconds > 0
+}
+
+// Check returns the first rule the team breaks, or nil when it is legal.
+func (s Set) Check(team []Member) error {
+	if len(team) < s.MinTeam || (s.MaxTeam > 0 && len(team) > s.MaxTeam) {
+		return &Violation{"Team Size", fmt.Sprintf("the team needs %s Pokémon, it has %d", s.teamSize(), len(team))}
+	}
+	species := make(map[string]bool)
+	items := make(map[string]string)
+	for _, poke := range team {
+		for _, banned := range s.Banned {
+			if strings.EqualFold(poke.Name, banned) {
+				return &Violation{"Banned", poke.Name + " is banned"}
+			}
+		}
+		// Auto-level thay cho giới hạn cấp độ
+		if s.AutoLevel == 0 && s.LevelCap > 0 && poke.Level > s.LevelCap {
+			return &Violation{"Level Cap", fmt.Sprintf("%s is level %d, the cap is %d", poke.Name, poke.Level, s.LevelCap)}
+		}
+		if s.SpeciesClause && species[poke.Name] {
+			return &Violation{"Species Clause", poke.Name + " is on the team twice"}
+		}
+		species[poke.Name] = true
+		if s.ItemClause && poke.Item != "" {
+			if other, ok := items[poke.Item]; ok {
+				return &Violation{"Item Clause", fmt.Sprintf("%s and %s both hold a %s", other, poke.Name, poke.Item)}
+			}
+			items[poke.Item] = poke.Name
+		}
+	}
+	return nil
+}
+
+// Leveled reports whether matches under the set battle with the stats of
+// the auto level instead of the base stats. A level cap only limits which
+// teams are allowed.
+func (s Set) Leveled() bool {
+	return s.AutoLevel > 0
+}
+
+// Level is the level a Pokémon of the given level battles at.
+func (s Set) Level(level int) int {
+	if s.AutoLevel > 0 {
+		return s.AutoLevel
+	}
+	return level
+}
+
+func (s Set) teamSize() string {
+	switch {
+	case s.MaxTeam == 0:
+		return fmt.Sprintf("at least %d", s.MinTeam)
+	case s.MinTeam == s.MaxTeam:
+		return fmt.Sprint(s.MinTeam)
+	}
+	return fmt.Sprintf("%d to %d", s.MinTeam, s.MaxTeam)
+}
+
+// Validate checks that the rule set makes sense.
+func (s Set) Validate() error {
+	if s.LevelCap < 0 || s.LevelCap > 100 || s.AutoLevel < 0 || s.AutoLevel > 100 {
+		return fmt.Errorf("level cap and auto level must be between 0 and 100, got %d and %d", s.LevelCap, s.AutoLevel)
+	}
+	if s.MinTeam < 0 || (s.MaxTeam > 0 && s.MaxTeam < s.MinTeam) {
+		return fmt.Errorf("team size must not be negative and max team must be at least min team, got %d and %d", s.MinTeam, s.MaxTeam)
+	}
+	if s.TurnSeconds < 0 || s.BankSeconds < 0 {
+		return fmt.Errorf("turn and bank seconds must not be negative, got %d and %d", s.TurnSeconds, s.BankSeconds)
+	}
+	return nil
+}
+
+// String lists the rules of the set, like "Level 50, Species Clause".
+func (s Set) String() string {
+	var parts []string
+	if s.AutoLevel > 0 {
+		parts = append(parts, fmt.Sprintf("Level %d", s.AutoLevel))
+	} else if s.LevelCap > 0 {
+		parts = append(parts, fmt.Sprintf("Level Cap %d", s.LevelCap))
+	}
+	if s.MinTeam > 0 || s.MaxTeam > 0 {
+		parts = append(parts, "Team Size "+s.teamSize())
+	}
+	if s.SpeciesClause {
+		parts = append(parts, "Species Clause")
+	}
+	if s.ItemClause {
+		parts = append(parts, "Item Clause")
+	}
+	if s.SleepClause {
+		parts = append(parts, "Sleep Clause")
+	}
+	if s.TurnSeconds > 0 {
+		parts = append(parts, fmt.Sprintf("Turn Timer %ds", s.TurnSeconds))
+	}
+	if s.BankSeconds > 0 {
+		parts = append(parts, fmt.Sprintf("Clock %d:%02d", s.BankSeconds/60, s.BankSeconds%60))
+	}
+	if len(s.Banned) > 0 {
+		parts = append(parts, "Banned: "+strings.Join(s.Banned, ", "))
+	}
+	if len(parts) == 0 {
+		return "no restrictions"
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestRuleTeamBattlesAtTheSetLevel(t *testing.T) {
+	withCatalogue(t)
+	poke := pokedex[0]
+	poke.Level = 3
+	team := []Pokedex{poke}
+
+	flat := ruleTeam("flat", team)[0]
+	if flat.Level != 50 || flat.PokeInfo.Hp != poke.PokeInfo.Hp*2*50/100+60 || flat.PokeInfo.Atk != poke.PokeInfo.Atk+5 {
+		t.Errorf("flat: level %d, %+v", flat.Level, flat.PokeInfo)
+	}
+	// A level cap only decides which teams are allowed
+	open, little := ruleTeam("open", team)[0], ruleTeam("little", team)[0]
+	if open.PokeInfo.Hp != poke.PokeInfo.Hp || open.Level != 3 {
+		t.Errorf("open changed the team: level %d, %+v", open.Level, open.PokeInfo)
+	}
+	if little.Level != open.Level || little.PokeInfo.Hp != open.PokeInfo.Hp || little.PokeInfo.Atk != open.PokeInfo.Atk ||
+		little.PokeInfo.Def != open.PokeInfo.Def || little.PokeInfo.Speed != open.PokeInfo.Speed {
+		t.Errorf("little: level %d, %+v, open: level %d, %+v", little.Level, little.PokeInfo, open.Level, open.PokeInfo)
+	}
+	if team[0].PokeInfo.Hp != poke.PokeInfo.Hp || team[0].Level != 3 {
+		t.Error("the player's own Pokémon changed")
+	}
+}
@@ -0,0 +1,553 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"pokegame/server/battle"
+)
+
+const inboxSize = 64
+
+// Session is the goroutine behind one client address. It owns the player's
+// profile (Pokédex, parties, chosen team) and runs their commands one at a
+// time; anything shared with other players goes through the lobby.
+type Session struct {
+	addr   *net.UDPAddr
+	conn   *net.UDPConn
+	lobby  *Lobby
+	client *Client // nil until @join
+	inbox  chan func()
+	done   chan struct{}
+	quit   bool
+	events bool // send structured events next to the text
+
+	chatTimes  []time.Time  // when the last chat messages were sent, for the rate limit
+	lastActive atomic.Int64 // unix nanoseconds of the last command, read by the lobby
+}
+
+func newSession(addr *net.UDPAddr, conn *net.UDPConn, lobby *Lobby) *Session {
+	s := &Session{
+		addr:  addr,
+		conn:  conn,
+		lobby: lobby,
+		inbox: make(chan func(), inboxSize),
+		done:  make(chan struct{}),
+	}
+	s.lastActive.Store(time.Now().UnixNano())
+	go s.run()
+	return s
+}
+
+func (s *Session) run() {
+	defer close(s.done)
+	for f := range s.inbox {
+		f()
+		if s.quit {
+			return
+		}
+	}
+}
+
+// post runs f in the session goroutine. It gives up once the session has
+// ended, so a player who quit never blocks the lobby or a match.
+func (s *Session) post(f func()) {
+	select {
+	case s.inbox <- f:
+	case <-s.done:
+	}
+}
+
+// idleFor is how long ago the player sent their last command.
+func (s *Session) idleFor() time.Duration {
+	return time.Since(time.Unix(0, s.lastActive.Load()))
+}
+
+func (s *Session) closed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// handle runs one command from the client.
+func (s *Session) handle(message string) {
+	addr, conn := s.addr, s.conn
+	parts := strings.Split(message, " ")
+	command := parts[0]
+	client := s.client
+	s.lastActive.Store(time.Now().UnixNano())
+
+	switch command {
+	case "@join":
+		if len(parts) < 2 {
+			sendMessageToClient("Invalid: Please provide a username.", addr, conn)
+			return
+		}
+		if client != nil {
+			sendMessageToClient("Invalid: You already joined as "+client.Name+".", addr, conn)
+			return
+		}
+		username := parts[1]
+		if !validName(username) {
+			sendMessageToClient(`Invalid: Usernames cannot contain /, \ or "..".`, addr, conn)
+			return
+		}
+		password := ""
+		if len(parts) > 2 {
+			password = parts[2]
+		}
+		s.lobby.post(func(l *Lobby) { l.join(s, username, password) })
+	case "5":
+		if client == nil {
+			sendMessageToClient("You are out the game", addr, conn)
+			return
+		}
+		name := client.Name
+		s.lobby.post(func(l *Lobby) { l.leave(name) })
+		fmt.Print("Player [" + name + "] out the game\n")
+		sendMessageToClient("You are out the game", addr, conn)
+		s.quit = true
+	case "2":
+		if client == nil {
+			sendMessageToClient("Error: You must join the game first.", addr, conn)
+			return
+		}
+
+		// Đảm bảo `userCurrentPoke` đã được khởi tạo
+		if client.userCurrentPoke.Id == "" {
+			sendMessageToClient("Error: No current Pokémon available. Please start the game properly.", addr, conn)
+			return
+		}
+
+		// Mỗi lần roll dùng một Poké Ball. A player who has none and cannot
+		// buy one still rolls, so nobody is locked out, but earns nothing
+		pity := false
+		if !client.wallet.take("poke-ball") {
+			if client.wallet.Coins >= findItem("poke-ball").Price {
+				sendMessageToClient("You have no Poké Balls left!\n(Usage: buy poke-ball [qty])", addr, conn)
+				return
+			}
+			pity = true
+		}
+		getPoke := RollPoke(client.userCurrentPoke, rollRNG)
+		ListPokemon := "Your new pokemon:\n"
+		for _, poke := range getPoke {
+			ListPokemon += fmt.Sprintf("[ID: %s --Name: %s -- Level: %d]\n", poke.Id, poke.Name, poke.Level)
+		}
+		ListPokemon += fmt.Sprintf("Poké Balls left: %d", client.wallet.Items["poke-ball"])
+		sendMessageToClient(ListPokemon, addr, conn)
+		client.userPokedex = append(client.userPokedex, getPoke...)
+		if pity {
+			sendMessageToClient("You had no Poké Balls and no coins for one, so this roll was free and your catches earn no coins.", addr, conn)
+		} else {
+			earn(client, config.Economy.CatchReward*len(getPoke), "for your catches", addr, conn)
+		}
+		saveClient(client)
+		s.sendBag()
+	case "1":
+		if client == nil {
+			sendMessageToClient("Error: You must join the game first.", addr, conn)
+			return
+		}
+		if len(parts) == 2 && parts[1] == "items" {
+			showItems(client, addr, conn)
+			return
+		}
+		msg := "Your Bag:\n"
+		for _, poke := range client.userPokedex {
+			msg += fmt.Sprintf("ID: %s - Name: %s [Level: %d] - HP: %d - ATK: %d - DEF: %d - SPEED: %d",
+				poke.Id, poke.Name, poke.Level, poke.PokeInfo.Hp, poke.PokeInfo.Atk, poke.PokeInfo.Def, poke.PokeInfo.Speed)
+			if poke.Ability != "" {
+				msg += " - Ability: " + poke.Ability
+			}
+			if poke.Held != "" {
+				msg += " - Holds: " + heldName(poke.Held)
+			}
+			msg += "\n"
+		}
+		sendMessageToClient(msg, addr, conn)
+	case "p":
+		if client == nil {
+			sendMessageToClient("Error: You must join the game first.", addr, conn)
+			return
+		}
+		if len(parts) != 4 {
+			sendMessageToClient("Invalid input! Please try again!\n", addr, conn)
+		} else {
+			confirm := "Your pokemon choosen:\n"
+			if checkPokeExist(parts[1], parts[2], parts[3], client) {
+				// Pick a fresh team every time instead of appending to the last one
+				client.battlePoke = nil
+				for _, id := range parts[1:4] {
+					for i, poke := range client.userPokedex {
+						if id == poke.Id && !containsSlot(client.battlePoke, i) {
+							confirm += poke.Name + " "
+							client.battlePoke = append(client.battlePoke, i)
+							break
+						}
+					}
+				}
+				s.publishTeam()
+				s.sendBag()
+				confirm += "\n(Usage: Enter start to start battle!)\n"
+				sendMessageToClient(confirm, addr, conn)
+			} else {
+				sendMessageToClient("Poke you choose is not have in your pokedex!", addr, conn)
+			}
+		}
+	case "party":
+		if client == nil {
+			sendMessageToClient("Error: You must join the game first.", addr, conn)
+			return
+		}
+		handleParty(client, parts[1:], addr, conn)
+		s.publishTeam()
+		s.sendBag()
+	case "use":
+		if client == nil {
+			sendMessageToClient("Error: You must join the game first.", addr, conn)
+			return
+		}
+		s.useItem(parts[1:])
+	case "give", "take":
+		if client == nil {
+			sendMessageToClient("Error: You must join the game first.", addr, conn)
+			return
+		}
+		changed := false
+		if command == "give" {
+			changed = giveItem(client, parts[1:], addr, conn)
+		} else {
+			changed = takeItem(client, parts[1:], addr, conn)
+		}
+		if changed {
+			s.publishTeam()
+			s.sendBag()
+		}
+	case "shop", "buy":
+		if client == nil {
+			sendMessageToClient("Error: You must join the game first.", addr, conn)
+			return
+		}
+		if command == "shop" {
+			showShop(client, addr, conn)
+			return
+		}
+		buyItem(client, parts[1:], addr, conn)
+		s.sendBag()
+	case "events":
+		if client == nil {
+			sendMessageToClient("Error: You must join the game first.", addr, conn)
+			return
+		}
+		if len(parts) != 2 || (parts[1] != "on" && parts[1] != "off") {
+			sendMessageToClient("Invalid command!\n(Usage: events on|off)", addr, conn)
+			return
+		}
+		s.events = parts[1] == "on"
+		name, on := client.Name, s.events
+		s.lobby.post(func(l *Lobby) { l.setEvents(name, on) })
+		s.sendBag()
+	case "replay":
+		handleReplay(parts[1:], addr, conn)
+	case "rules":
+		showRules(parts[1:], addr, conn)
+	case "/say", "/w", "/m", "/mute", "/unmute":
+		if client == nil {
+			sendMessageToClient("Error: You must join the game first.", addr, conn)
+			return
+		}
+		s.handleChat(command, strings.TrimSpace(strings.TrimPrefix(message, command)))
+	default:
+		if client == nil {
+			s.handleGuest(parts)
+			return
+		}
+		s.handleLobby(client.Name, parts)
+	}
+}
+
+// handleGuest answers the lobby commands of someone who has not joined.
+func (s *Session) handleGuest(parts []string) {
+	switch parts[0] {
+	case "matches":
+		s.lobby.post(func(l *Lobby) { l.matches(s.addr) })
+	case "3":
+		s.lobby.post(func(l *Lobby) { l.list("", s.addr) })
+	case "ladder":
+		s.lobby.post(func(l *Lobby) { l.showLadder(parts[1:], s.addr) })
+	case "profile":
+		if len(parts) < 2 {
+			sendMessageToClient("Invalid command!\n(Usage: profile <player>)", s.addr, s.conn)
+			return
+		}
+		target, saved := parts[1], accountExists(parts[1])
+		s.lobby.post(func(l *Lobby) { l.showProfile(target, saved, s.addr) })
+	case "start", "attack", "surrender", "move":
+		sendMessageToClient("You are not in the battle! Cannot use this command!", s.addr, s.conn)
+	case "switch":
+		sendMessageToClient("Invalid command!", s.addr, s.conn)
+	case "spectate", "battle", "friends", "friend", "queue", "tournament":
+		sendMessageToClient("Error: You must join the game first.", s.addr, s.conn)
+	default:
+		sendMessageToClient("Invalid command", s.addr, s.conn)
+	}
+}
+
+// handleLobby passes the commands that involve other players to the lobby.
+func (s *Session) handleLobby(name string, parts []string) {
+	addr, conn := s.addr, s.conn
+	switch parts[0] {
+	case "3":
+		s.lobby.post(func(l *Lobby) { l.list(name, addr) })
+	case "4":
+		if len(parts) < 2 {
+			sendMessageToClient("Invalid command!\n(Usage: 4 <username> [rated|unrated] [singles|doubles] [rules])", addr, conn)
+			return
+		}
+		rated, format, ruleName := true, battle.Singles, config.Gameplay.Rules
+		for _, word := range parts[2:] {
+			word = strings.ToLower(word)
+			switch _, known := config.Rules[word]; {
+			case word == "rated", word == "unrated":
+				rated = word == "rated"
+			case word == "singles", word == "doubles":
+				format = battle.Format(word)
+			case known:
+				ruleName = word
+			default:
+				sendMessageToClient("Invalid command!\n(Usage: 4 <username> [rated|unrated] [singles|doubles] [rules], rules lists the rule sets)", addr, conn)
+				return
+			}
+		}
+		s.lobby.post(func(l *Lobby) { l.invite(name, parts[1], rated, format, ruleName) })
+	case "accept":
+		if len(parts) != 2 {
+			sendMessageToClient("Invalid command!\n", addr, conn)
+			return
+		}
+		answer := strings.ToLower(parts[1])
+		if answer != "yes" && answer != "no" {
+			sendMessageToClient("Invalid command!\n", addr, conn)
+			return
+		}
+		s.lobby.post(func(l *Lobby) { l.accept(name, answer == "yes") })
+	case "start":
+		s.lobby.post(func(l *Lobby) { l.start(name) })
+	case "attack":
+		// Đòn đánh đôi: chọn đối thủ 1, 2, đồng đội hoặc cả sân
+		kind, aim, foe := battle.AttackRandom, battle.AimFoe, 0
+		for _, word := range parts[1:] {
+			switch strings.ToLower(word) {
+			case "normal":
+				kind = battle.AttackNormal
+			case "special":
+				kind = battle.AttackSpecial
+			case "random":
+				kind = battle.AttackRandom
+			case "1", "2":
+				foe = int(word[0] - '1')
+			case "ally", "foes", "all":
+				aim = battle.Aim(strings.ToLower(word))
+			default:
+				sendMessageToClient("Invalid command!\n(Usage: attack [normal|special|random] [1|2|ally|foes|all])", addr, conn)
+				return
+			}
+		}
+		s.lobby.post(func(l *Lobby) { l.attack(name, kind, aim, foe) })
+	case "switch":
+		if len(parts) != 2 && (len(parts) != 3 || (parts[2] != "1" && parts[2] != "2")) {
+			sendMessageToClient("Invalid command!\n(Usage: switch #id [1|2])", addr, conn)
+			return
+		}
+		slot := 0
+		if len(parts) == 3 {
+			slot = int(parts[2][0] - '1')
+		}
+		s.lobby.post(func(l *Lobby) { l.switchTo(name, parts[1], slot) })
+	case "move":
+		if len(parts) != 2 {
+			sendMessageToClient("Invalid command!\n(Usage: move <field move>)", addr, conn)
+			return
+		}
+		id := strings.ToLower(parts[1])
+		s.lobby.post(func(l *Lobby) { l.move(name, id) })
+	case "surrender":
+		s.lobby.post(func(l *Lobby) { l.surrender(name) })
+	case "friends", "friend":
+		// The other player's list is read here, not in the lobby
+		var lists map[string]*FriendList
+		saved := false
+		if len(parts) == 3 {
+			var err error
+			if lists, err = loadFriends(parts[2]); err != nil {
+				fmt.Printf("Error loading the friends of [%s]: %v\n", parts[2], err)
+				sendMessageToClient("The friends of "+parts[2]+" could not be read! Please try again later.", addr, conn)
+				return
+			}
+			saved = accountExists(parts[2])
+		}
+		s.lobby.post(func(l *Lobby) {
+			l.adoptFriends(lists)
+			l.friendCommand(name, parts, saved)
+		})
+	case "queue":
+		ruleName := config.Gameplay.Rules
+		if len(parts) == 3 && containsName(queueModes, parts[1]) {
+			ruleName = strings.ToLower(parts[2])
+		}
+		if _, known := config.Rules[ruleName]; len(parts) < 2 || len(parts) > 3 || !known ||
+			!(containsName(queueModes, parts[1]) || (len(parts) == 2 && (parts[1] == "leave" || parts[1] == "stats"))) {
+			sendMessageToClient("Invalid command!\n(Usage: queue ranked|casual [rules], queue leave)", addr, conn)
+			return
+		}
+		s.lobby.post(func(l *Lobby) { l.queue(name, parts[1], ruleName) })
+	case "tournament":
+		s.lobby.post(func(l *Lobby) { l.tournamentCommand(name, parts[1:]) })
+	case "ladder":
+		s.lobby.post(func(l *Lobby) { l.showLadder(parts[1:], addr) })
+	case "profile":
+		target := name
+		if len(parts) > 1 {
+			target = parts[1]
+		}
+		saved := accountExists(target)
+		s.lobby.post(func(l *Lobby) { l.showProfile(target, saved, addr) })
+	case "matches":
+		s.lobby.post(func(l *Lobby) { l.matches(addr) })
+	case "spectate":
+		if len(parts) != 2 {
+			sendMessageToClient("Invalid command!\n(Usage: spectate <matchID> or spectate leave)", addr, conn)
+			return
+		}
+		s.lobby.post(func(l *Lobby) { l.spectate(name, parts[1]) })
+	case "battle":
+		if len(parts) < 2 || len(parts) > 4 || parts[1] != "cpu" {
+			sendMessageToClient("Invalid command!\n(Usage: battle cpu [easy|normal|hard] [singles|doubles])", addr, conn)
+			return
+		}
+		difficulty, format := "normal", battle.Singles
+		for _, word := range parts[2:] {
+			if word = strings.ToLower(word); word == "singles" || word == "doubles" {
+				format = battle.Format(word)
+			} else {
+				difficulty = word
+			}
+		}
+		s.lobby.post(func(l *Lobby) { l.battleCPU(name, difficulty, format) })
+	default:
+		sendMessageToClient("Invalid command", addr, conn)
+	}
+}
+
+// loadFailed turns the player away when their save cannot be read. The
+// save is left as it is, so nothing is overwritten.
+func (s *Session) loadFailed(username string, err error) {
+	fmt.Printf("Error loading user [%s]: %v\n", username, err)
+	s.lobby.post(func(l *Lobby) { l.release(s, username) })
+	sendMessageToClient("Your save could not be read! Please try again later.", s.addr, s.conn)
+}
+
+// load reads the player's save once the lobby has reserved their name.
+func (s *Session) load(username, password string) {
+	client := &Client{Name: username, Addr: s.addr}
+
+	// Kiểm tra xem tệp JSON lưu trữ Pokémon của người dùng có tồn tại không
+	filePath := savePath(username)
+	if fileExists(filePath) {
+		// Nếu tệp tồn tại, tải dữ liệu từ tệp
+		var save PlayerSave
+		if err := OpenFile(filePath, &save); err != nil {
+			s.loadFailed(username, err)
+			return
+		}
+		if loadSave(client, save) {
+			saveClient(client)
+		}
+		fmt.Printf("User [%s] reloaded with saved data.\n", username)
+	} else if fileExists(legacyPath(username)) {
+		// Older saves only hold the Pokémon list
+		var savedPokedex []Pokedex
+		if err := OpenFile(legacyPath(username), &savedPokedex); err != nil {
+			s.loadFailed(username, err)
+			return
+		}
+		loadSave(client, PlayerSave{Pokedex: savedPokedex})
+		saveClient(client)
+		fmt.Printf("User [%s] reloaded with saved data.\n", username)
+	} else {
+		// Nếu tệp không tồn tại, khởi tạo người dùng với một Pokémon mặc định
+		if poke, ok := findStarter(); ok {
+			poke.Ability = pickAbility(poke, rollRNG)
+			client.userCurrentPoke = poke
+			client.userCurrentPoke.Level = 1
+			client.userPokedex = append(client.userPokedex, poke)
+		}
+		client.wallet = startingWallet()
+		fmt.Printf("New user [%s] initialized with default Pokemon.\n", username)
+
+		// Lưu tệp JSON cho người dùng mới
+		saveClient(client)
+	}
+
+	if !checkPassword(client, password) {
+		fmt.Printf("User [%s] gave a wrong password.\n", username)
+		s.lobby.post(func(l *Lobby) { l.release(s, username) })
+		sendMessageToClient("Invalid: Wrong password.", s.addr, s.conn)
+		return
+	}
+	if needsPassword(client, password) {
+		setPassword(client, password)
+		saveClient(client)
+	}
+
+	friends, err := loadFriendsOf(username)
+	if err != nil {
+		s.loadFailed(username, err)
+		return
+	}
+
+	s.client = client
+	s.publishTeam()
+	sendMessageToClient("["+username+"] Welcome to the POKEMON game!", s.addr, s.conn)
+	s.lobby.post(func(l *Lobby) { l.cameOnline(username, friends) })
+}
+
+// publishTeam hands the lobby a copy of the team the player would battle
+// with, so it can start a match without reaching into the session.
+func (s *Session) publishTeam() {
+	name := s.client.Name
+	team, slots := battleTeam(s.client)
+	s.lobby.post(func(l *Lobby) { l.setTeam(s, name, team, slots) })
+}
+
+// finishMatch clears the chosen team after a match. The winner also gets
+// the slots of their team and the experience each one earned.
+func (s *Session) finishMatch(slots []int, expReward int) {
+	client := s.client
+	if client == nil {
+		return
+	}
+	client.battlePoke = nil
+	if slots != nil {
+		earn(client, config.Economy.WinReward, "for the win", s.addr, s.conn)
+		distributeExp(client, slots, expReward)
+	}
+	s.publishTeam()
+	s.sendBag()
+}
+
+// sendBag sends the bag event when the client asked for events.
+func (s *Session) sendBag() {
+	if s.events {
+		for _, page := range bagEvents(s.client) {
+			sendEvent("bag", page, s.addr, s.conn)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"pokegame/server/battle"
+)
+
+// Wallet is the player's money and the items in their bag, by item ID.
+type Wallet struct {
+	Coins int            `json:"Coins"`
+	Items map[string]int `json:"Items"`
+}
+
+// Item is something the shop sells.
+type Item struct {
+	ID       string // what players type
+	Name     string
+	Price    int
+	Kind     string          // ball, medicine, stone or candy
+	Medicine battle.Medicine // what a medicine does in battle
+	About    string
+}
+
+const (
+	maxBuy   = 99  // items bought at once
+	maxStack = 999 // items of one kind in the bag
+)
+
+var shopItems = []Item{
+	{ID: "poke-ball", Name: "Poké Ball", Price: 200, Kind: "ball", About: "Needed to roll for new Pokémon. Without balls or coins a roll is free but earns nothing."},
+	{ID: "potion", Name: "Potion", Price: 300, Kind: "medicine", Medicine: battle.Medicine{Heal: 20}, About: "Restores 20 HP in battle."},
+	{ID: "super-potion", Name: "Super Potion", Price: 700, Kind: "medicine", Medicine: battle.Medicine{Heal: 60}, About: "Restores 60 HP in battle."},
+	{ID: "hyper-potion", Name: "Hyper Potion", Price: 1200, Kind: "medicine", Medicine: battle.Medicine{Heal: 120}, About: "Restores 120 HP in battle."},
+	{ID: "full-heal", Name: "Full Heal", Price: 400, Kind: "medicine", Medicine: battle.Medicine{Cure: true}, About: "Cures the status of a Pokémon in battle."},
+	{ID: "revive", Name: "Revive", Price: 1500, Kind: "medicine", Medicine: battle.Medicine{Revive: true}, About: "Revives a fainted Pokémon with half its HP in battle."},
+	{ID: "rare-candy", Name: "Rare Candy", Price: 4800, Kind: "candy", About: "Raises a Pokémon by one level."},
+	{ID: "fire-stone", Name: "Fire Stone", Price: 2100, Kind: "stone", About: "Evolves Vulpix, Growlithe, Eevee..."},
+	{ID: "water-stone", Name: "Water Stone", Price: 2100, Kind: "stone", About: "Evolves Poliwhirl, Staryu, Eevee..."},
+	{ID: "thunder-stone", Name: "Thunder Stone", Price: 2100, Kind: "stone", About: "Evolves Pikachu, Eevee, Eelektrik."},
+	{ID: "leaf-stone", Name: "Leaf Stone", Price: 2100, Kind: "stone", About: "Evolves Gloom, Weepinbell, Exeggcute..."},
+	{ID: "moon-stone", Name: "Moon Stone", Price: 2100, Kind: "stone", About: "Evolves Nidorina, Nidorino, Clefairy..."},
+	{ID: "leftovers", Name: "Leftovers", Price: 4000, Kind: "held", About: "Held: restores 1/16 of max HP after each of its turns."},
+	{ID: "focus-sash", Name: "Focus Sash", Price: 3000, Kind: "held", About: "Held: survives a knockout with 1 HP from full HP, once a battle."},
+	{ID: "choice-band", Name: "Choice Band", Price: 5000, Kind: "held", About: "Held: normal attacks x1.5, locked into its first attack kind."},
+	{ID: "choice-specs", Name: "Choice Specs", Price: 5000, Kind: "held", About: "Held: special attacks x1.5, locked into its first attack kind."},
+	{ID: "choice-scarf", Name: "Choice Scarf", Price: 5000, Kind: "held", About: "Held: speed x1.5, locked into its first attack kind."},
+}
+
+func init() {
+	// Mỗi loại có một vật phẩm tăng sức tấn công đặc biệt
+	for _, b := range battle.TypeBoosters {
+		shopItems = append(shopItems, Item{ID: b.ID, Name: b.Name, Price: 1000, Kind: "held",
+			About: "Held: special attacks x1.2 for " + b.Type + " types."})
+	}
+}
+
+func findItem(id string) *Item {
+	for i := range shopItems {
+		if strings.EqualFold(shopItems[i].ID, id) {
+			return &shopItems[i]
+		}
+	}
+	return nil
+}
+
+// startingWallet is what new players, and players from before the shop,
+// start with.
+func startingWallet() Wallet {
+	return Wallet{
+		Coins: config.Economy.StartingCoins,
+		Items: map[string]int{"poke-ball": config.Economy.StartingBalls},
+	}
+}
+
+func (w *Wallet) add(id string, n int) {
+	if w.Items == nil {
+		w.Items = make(map[string]int)
+	}
+	w.Items[id] += n
+}
+
+// take uses up one item and reports whether there was one.
+func (w *Wallet) take(id string) bool {
+	if w.Items[id] == 0 {
+		return false
+	}
+	w.Items[id]--
+	if w.Items[id] == 0 {
+		delete(w.Items, id)
+	}
+	return true
+}
+
+func showShop(client *Client, addr *net.UDPAddr, conn *net.UDPConn) {
+	msg := fmt.Sprintf("Shop (you have %d coins):\n", client.wallet.Coins)
+	for _, item := range shopItems {
+		msg += fmt.Sprintf("  %-14s %-14s %5d  %s\n", item.ID, item.Name, item.Price, item.About)
+	}
+	msg += "(Usage: buy <item> [qty])"
+	sendMessageToClient(msg, addr, conn)
+}
+
+func buyItem(client *Client, args []string, addr *net.UDPAddr, conn *net.UDPConn) {
+	if len(args) < 1 || len(args) > 2 {
+		sendMessageToClient("Invalid command!\n(Usage: buy <item> [qty])", addr, conn)
+		return
+	}
+	item := findItem(args[0])
+	if item == nil {
+		sendMessageToClient("The shop does not sell "+args[0]+". Type shop to see what it sells.", addr, conn)
+		return
+	}
+	qty := 1
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 || n > maxBuy {
+			sendMessageToClient(fmt.Sprintf("Quantity must be between 1 and %d!", maxBuy), addr, conn)
+			return
+		}
+		qty = n
+	}
+	w := &client.wallet
+	if w.Items[item.ID]+qty > maxStack {
+		sendMessageToClient(fmt.Sprintf("Your bag holds at most %d %s!", maxStack, item.Name), addr, conn)
+		return
+	}
+	cost := item.Price * qty
+	if cost > w.Coins {
+		sendMessageToClient(fmt.Sprintf("%d %s cost %d coins, you only have %d!", qty, item.Name, cost, w.Coins), addr, conn)
+		return
+	}
+	w.Coins -= cost
+	w.add(item.ID, qty)
+	saveClient(client)
+	sendMessageToClient(fmt.Sprintf("You bought %d %s for %d coins. You have %d coins left.", qty, item.Name, cost, w.Coins), addr, conn)
+}
+
+func showItems(client *Client, addr *net.UDPAddr, conn *net.UDPConn) {
+	w := client.wallet
+	msg := fmt.Sprintf("Wallet: %d coins\nItems:\n", w.Coins)
+	if len(w.Items) == 0 {
+		msg += "No items. (Usage: shop)\n"
+	}
+	for _, item := range shopItems {
+		if n := w.Items[item.ID]; n > 0 {
+			msg += fmt.Sprintf("[%s] %s x%d\n", item.ID, item.Name, n)
+		}
+	}
+	sendMessageToClient(msg, addr, conn)
+}
+
+// earn pays the player and tells them why.
+func earn(client *Client, coins int, why string, addr *net.UDPAddr, conn *net.UDPConn) {
+	if coins <= 0 {
+		return
+	}
+	client.wallet.Coins += coins
+	sendMessageToClient(fmt.Sprintf("You earned %d coins %s. Wallet: %d coins.", coins, why, client.wallet.Coins), addr, conn)
+}
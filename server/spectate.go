@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"pokegame/server/battle"
+)
+
+// matches lists the live games that can be spectated.
+func (l *Lobby) matches(addr *net.UDPAddr) {
+	if len(l.games) == 0 {
+		sendMessageToClient("No match is being played right now.", addr, l.conn)
+		return
+	}
+	var list []*Battle
+	for _, game := range l.games {
+		list = append(list, game)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+
+	msg := "Live matches:\n"
+	for _, game := range list {
+		msg += fmt.Sprintf("[Match %d] %s vs %s - %d watching\n",
+			game.ID, game.Players[0].Name, game.Players[1].Name, l.watchers(game))
+	}
+	msg += "(Usage: spectate <matchID>)\n"
+	sendMessageToClient(msg, addr, l.conn)
+}
+
+func (l *Lobby) watchers(game *Battle) int {
+	n := 0
+	for _, p := range l.players {
+		if p.watching == game {
+			n++
+		}
+	}
+	return n
+}
+
+func (l *Lobby) spectate(name, arg string) {
+	client := l.players[name]
+	if client == nil {
+		return
+	}
+	addr := client.Addr
+	if arg == "leave" {
+		if client.watching == nil {
+			sendMessageToClient("You are not watching any match.", addr, l.conn)
+			return
+		}
+		l.stopSpectating(client)
+		l.broadcastLobby()
+		sendMessageToClient("You stopped watching the match.", addr, l.conn)
+		return
+	}
+	if client.game != nil {
+		sendMessageToClient("You cannot watch a match while you are in a battle!", addr, l.conn)
+		return
+	}
+
+	id, err := strconv.Atoi(arg)
+	game := l.games[id]
+	if err != nil || game == nil {
+		sendMessageToClient("Match "+arg+" not found! Use matches to list live games.", addr, l.conn)
+		return
+	}
+
+	l.stopSpectating(client)
+	client.watching = game
+	l.broadcastLobby()
+	fmt.Printf("[LOG] %s is watching match %d.\n", client.Name, game.ID)
+	game.post(func(g *Battle) {
+		g.Spectators = append(g.Spectators, client)
+		sendMessageToClient(matchSnapshot(g), addr, g.conn)
+	})
+}
+
+func (l *Lobby) stopSpectating(client *Player) {
+	game := client.watching
+	if game == nil {
+		return
+	}
+	game.post(func(g *Battle) {
+		for i, spectator := range g.Spectators {
+			if spectator == client {
+				g.Spectators = append(g.Spectators[:i], g.Spectators[i+1:]...)
+				break
+			}
+		}
+	})
+	client.watching = nil
+}
+
+// notifySpectators sends a turn event to everyone watching the match.
+func (game *Battle) notifySpectators(message string) {
+	for _, spectator := range game.Spectators {
+		sendMessageToClient(fmt.Sprintf("[Match %d] %s", game.ID, message), spectator.Addr, game.conn)
+	}
+}
+
+// matchSnapshot describes the match for someone joining in the middle of
+// it. Benched Pokémon stay hidden until they have been sent out.
+func matchSnapshot(game *Battle) string {
+	msg := fmt.Sprintf("[Match %d] You are watching %s vs %s\n", game.ID, game.Players[0].Name, game.Players[1].Name)
+	for i, player := range game.Players {
+		msg += describeSide(player, game.Engine.Sides[i])
+	}
+	msg += fmt.Sprintf("Turn: %s\n(Usage: spectate leave to stop watching)\n", game.Players[game.Engine.Turn].Name)
+	return msg
+}
+
+func describeSide(player *Player, side *battle.Side) string {
+	var actives []string
+	inBattle := make(map[*battle.Combatant]bool)
+	for slot := range side.Slots {
+		active := side.At(slot)
+		inBattle[active] = true
+		actives = append(actives, fmt.Sprintf("%s [HP: %d/%d]", active.Pokemon.Name, active.Hp, active.MaxHp))
+	}
+	msg := fmt.Sprintf("%s: %s\n", player.Name, strings.Join(actives, ", "))
+	hidden := 0
+	for _, poke := range side.Team {
+		if inBattle[poke] {
+			continue
+		}
+		if !poke.Revealed {
+			hidden++
+			continue
+		}
+		msg += fmt.Sprintf("  bench: %s [HP: %d/%d]\n", poke.Pokemon.Name, poke.Hp, poke.MaxHp)
+	}
+	if hidden > 0 {
+		msg += fmt.Sprintf("  bench: %d unrevealed Pokémon\n", hidden)
+	}
+	return msg
+}
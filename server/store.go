@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// saveJob is one file write waiting for the saver.
+type saveJob struct {
+	fileName string
+	data     []byte
+}
+
+// saves queues the writes of every goroutine, so a slow disk only delays
+// the saver and never a player's command.
+var saves = make(chan saveJob, 256)
+
+// pending holds the newest data queued for each file that still has writes
+// waiting, so a read never sees a file older than what was saved.
+var (
+	pendingMu sync.Mutex
+	pending   = make(map[string]*pendingSave)
+)
+
+// The gate is held for reading while a save is queued. Stopping takes it
+// for writing, so no save is being queued when the queue is closed.
+var (
+	gate      sync.RWMutex
+	stopped   bool                  // no more saves are taken, guarded by gate
+	saverDone = make(chan struct{}) // closed once the saver wrote the whole queue
+)
+
+type pendingSave struct {
+	data  []byte
+	count int // writes to the file still in the queue
+}
+
+// runSaver writes queued files one at a time. Writes to the same file keep
+// the order they were queued in. It returns once the queue is closed and
+// empty.
+func runSaver() {
+	defer close(saverDone)
+	for job := range saves {
+		if err := writeFile(job.fileName, job.data); err != nil {
+			fmt.Println("Error writing JSON to file: ", err)
+		} else {
+			fmt.Println(job.fileName + " updated!")
+		}
+		pendingMu.Lock()
+		if p := pending[job.fileName]; p != nil {
+			if p.count--; p.count == 0 {
+				delete(pending, job.fileName)
+			}
+		}
+		pendingMu.Unlock()
+	}
+}
+
+// writeFile writes a temporary file next to the target and renames it into
+// place, so readers see either the old file or the new one.
+func writeFile(fileName string, data []byte) error {
+	dir := filepath.Dir(fileName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(fileName)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), fileName)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// saveFile marshals the value right away, in the caller's goroutine that
+// owns it, and leaves the write to the saver.
+func saveFile(fileName string, key interface{}) {
+	jsonData, err := json.MarshalIndent(key, "", "  ")
+	if err != nil {
+		fmt.Println("Error marshalling to JSON: ", err)
+		return
+	}
+	gate.RLock()
+	defer gate.RUnlock()
+	if stopped {
+		fmt.Println("Server is stopping, not saved: " + fileName)
+		return
+	}
+	pendingMu.Lock()
+	p := pending[fileName]
+	if p == nil {
+		p = &pendingSave{}
+		pending[fileName] = p
+	}
+	p.data = jsonData
+	p.count++
+	pendingMu.Unlock()
+	// The saver never takes the gate, so a full queue still drains
+	saves <- saveJob{fileName: fileName, data: jsonData}
+}
+
+// readFile returns the file as it will be once the queued writes are done.
+func readFile(fileName string) ([]byte, error) {
+	pendingMu.Lock()
+	p := pending[fileName]
+	var data []byte
+	if p != nil {
+		data = p.data
+	}
+	pendingMu.Unlock()
+	if p != nil {
+		return data, nil
+	}
+	return os.ReadFile(fileName)
+}
+
+// fileExists reports whether the file is on disk or queued to be.
+func fileExists(fileName string) bool {
+	pendingMu.Lock()
+	p := pending[fileName]
+	pendingMu.Unlock()
+	if p != nil {
+		return true
+	}
+	_, err := os.Stat(fileName)
+	return err == nil
+}
+
+// stopSaves refuses new saves, closes the queue and waits until the saver
+// has written everything queued before.
+func stopSaves() {
+	gate.Lock()
+	stopped = true
+	close(saves)
+	gate.Unlock()
+	<-saverDone
+}
+
+// flushOnExit writes the queued saves when the server is stopped, so no
+// save that was queued is lost.
+func flushOnExit() {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+	fmt.Println("Shutting down, writing the queued saves...")
+	stopSaves()
+	os.Exit(0)
+}
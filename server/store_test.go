@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// withSaver runs a fresh saver for the test and puts the old queue back.
+func withSaver(t *testing.T) {
+	t.Helper()
+	oldSaves, oldDone := saves, saverDone
+	saves, saverDone, stopped = make(chan saveJob, 256), make(chan struct{}), false
+	go runSaver()
+	t.Cleanup(func() { saves, saverDone, stopped = oldSaves, oldDone, false })
+}
+
+func TestStopSavesWritesTheQueue(t *testing.T) {
+	withSaver(t)
+	dir := t.TempDir()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for n := 0; n < 50; n++ {
+				saveFile(filepath.Join(dir, string(rune('a'+i))+".json"), n)
+			}
+		}(i)
+	}
+	wg.Wait()
+	stopSaves()
+
+	for i := 0; i < 8; i++ {
+		data, err := os.ReadFile(filepath.Join(dir, string(rune('a'+i))+".json"))
+		if err != nil || string(data) != "49" {
+			t.Errorf("file %d holds %q (%v), want the last save", i, data, err)
+		}
+	}
+	// Saves after the stop are dropped, not sent on the closed queue
+	saveFile(filepath.Join(dir, "late.json"), 1)
+	if _, err := os.Stat(filepath.Join(dir, "late.json")); err == nil {
+		t.Error("a save after the stop was written")
+	}
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	if len(pending) != 0 {
+		t.Errorf("%d files still pending", len(pending))
+	}
+}
+
+func TestStopSavesWhileSaving(t *testing.T) {
+	withSaver(t)
+	dir := t.TempDir()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 200; n++ {
+				saveFile(filepath.Join(dir, "busy.json"), n)
+			}
+		}()
+	}
+	stopSaves()
+	wg.Wait()
+	if data, err := os.ReadFile(filepath.Join(dir, "busy.json")); err == nil && len(data) == 0 {
+		t.Error("busy.json was left empty")
+	}
+}
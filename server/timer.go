@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"pokegame/server/battle"
+	"pokegame/server/rules"
+)
+
+// A match under a rule set with a timer gives every human player a turn
+// timer and a chess-clock bank. Both run while the player has to act: when
+// the turn timer runs out a move is played for them, when the bank runs out
+// they forfeit. Both players are warned as the time left passes the
+// thresholds, and the battle events carry everyone's time.
+
+// timerWarnings are the times left at which both players are warned.
+var timerWarnings = []time.Duration{30 * time.Second, 10 * time.Second, 5 * time.Second}
+
+// clock is one player's time in a timed match.
+type clock struct {
+	turn    time.Duration // for every turn, 0 for no turn timer
+	bank    time.Duration // left on the chess clock
+	banked  bool          // the rule set has a chess clock
+	started time.Time     // when they had to act, zero while they wait
+	warned  int           // warnings sent this turn
+	timer   *time.Timer
+}
+
+// newClocks returns the clocks of both players, nil for CPU players and
+// for rule sets without a timer.
+func newClocks(set rules.Set, players [2]*Player) [2]*clock {
+	var clocks [2]*clock
+	if !set.Timed() {
+		return clocks
+	}
+	for side, p := range players {
+		if p.session == nil {
+			continue
+		}
+		clocks[side] = &clock{
+			turn:   time.Duration(set.TurnSeconds) * time.Second,
+			bank:   time.Duration(set.BankSeconds) * time.Second,
+			banked: set.BankSeconds > 0,
+		}
+	}
+	return clocks
+}
+
+// elapsed is how long the player has been thinking this turn.
+func (c *clock) elapsed(now time.Time) time.Duration {
+	if c.started.IsZero() {
+		return 0
+	}
+	return now.Sub(c.started)
+}
+
+// left is the time until the player's timer runs out, and whether it is
+// the bank that runs out first.
+func (c *clock) left(now time.Time) (time.Duration, bool) {
+	elapsed := c.elapsed(now)
+	if c.banked && (c.turn == 0 || c.bank < c.turn) {
+		return c.bank - elapsed, true
+	}
+	return c.turn - elapsed, false
+}
+
+// stop charges the turn to the bank.
+func (c *clock) stop(now time.Time) {
+	if c.banked {
+		c.bank = max(0, c.bank-c.elapsed(now))
+	}
+	c.started = time.Time{}
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+}
+
+// view is the clock as the battle events show it, nil without one.
+func (c *clock) view(now time.Time) *BattleTimer {
+	if c == nil {
+		return nil
+	}
+	elapsed := c.elapsed(now)
+	timer := &BattleTimer{Running: !c.started.IsZero()}
+	if c.turn > 0 {
+		timer.Turn = seconds(c.turn - elapsed)
+	}
+	if c.banked {
+		timer.Bank = seconds(c.bank - elapsed)
+	}
+	return timer
+}
+
+// seconds rounds a time left up to whole seconds.
+func seconds(d time.Duration) int {
+	return int(max(0, (d+time.Second-1)/time.Second))
+}
+
+// describeClocks tells the players the timer of the match, or "".
+func describeClocks(set rules.Set) string {
+	switch {
+	case set.TurnSeconds > 0 && set.BankSeconds > 0:
+		return fmt.Sprintf("Timer: %ds per turn and %d:%02d on each clock. A move is played for you when the turn runs out, you forfeit when your clock does.",
+			set.TurnSeconds, set.BankSeconds/60, set.BankSeconds%60)
+	case set.TurnSeconds > 0:
+		return fmt.Sprintf("Timer: %ds per turn. A move is played for you when it runs out.", set.TurnSeconds)
+	case set.BankSeconds > 0:
+		return fmt.Sprintf("Timer: %d:%02d on each clock. You forfeit when yours runs out.", set.BankSeconds/60, set.BankSeconds%60)
+	}
+	return ""
+}
+
+// runClocks starts the clock of every player who now has to act and stops
+// the others'. It runs after every change to the match.
+func (game *Battle) runClocks() {
+	now := time.Now()
+	for side, c := range game.clocks {
+		if c == nil {
+			continue
+		}
+		acting := !game.Engine.Over() && game.Engine.Waiting(side)
+		switch {
+		case acting && c.started.IsZero():
+			c.started, c.warned = now, 0
+			// Bỏ qua các mốc đã qua khi lượt bắt đầu
+			left, _ := c.left(now)
+			for c.warned < len(timerWarnings) && left <= timerWarnings[c.warned] {
+				c.warned++
+			}
+			game.schedule(side)
+		case !acting && !c.started.IsZero():
+			c.stop(now)
+		}
+	}
+}
+
+// schedule wakes the match at the player's next warning, or when their
+// time runs out.
+func (game *Battle) schedule(side int) {
+	c := game.clocks[side]
+	wait, _ := c.left(time.Now())
+	if c.warned < len(timerWarnings) {
+		wait -= timerWarnings[c.warned]
+	}
+	started := c.started
+	c.timer = time.AfterFunc(max(wait, 0), func() {
+		// Through the lobby, which never posts to a match that has ended
+		game.lobby.post(func(l *Lobby) {
+			if l.games[game.ID] == game {
+				game.post(func(g *Battle) { g.clockRang(side, started) })
+			}
+		})
+	})
+}
+
+// clockRang warns both players or plays the timeout of the side whose turn
+// started at the given time. A turn that ended since is ignored.
+func (game *Battle) clockRang(side int, started time.Time) {
+	c := game.clocks[side]
+	if game.Engine.Over() || !c.started.Equal(started) {
+		return
+	}
+	now := time.Now()
+	player, opponent := game.Players[side], game.Players[1-side]
+	left, bank := c.left(now)
+	if left > 0 {
+		// The smallest warning the time left has passed, 0 for none
+		var warning time.Duration
+		for c.warned < len(timerWarnings) && left <= timerWarnings[c.warned] {
+			warning = timerWarnings[c.warned]
+			c.warned++
+		}
+		if warning == 0 {
+			game.schedule(side)
+			return
+		}
+		if bank {
+			sendMessageToClient(fmt.Sprintf("%d seconds left on your clock! You forfeit when it runs out.", seconds(warning)), player.Addr, game.conn)
+		} else {
+			sendMessageToClient(fmt.Sprintf("%d seconds left on your turn!", seconds(warning)), player.Addr, game.conn)
+		}
+		sendMessageToClient(fmt.Sprintf("%s has %d seconds left.", player.Name, seconds(warning)), opponent.Addr, game.conn)
+		game.schedule(side)
+		game.sendState()
+		return
+	}
+
+	c.stop(now)
+	fmt.Printf("[LOG] %s ran out of time in match %d.\n", player.Name, game.ID)
+	if bank {
+		sendMessageToClient("Your clock ran out! You forfeit the match.", player.Addr, game.conn)
+		sendMessageToClient(fmt.Sprintf("%s's clock ran out! They forfeit the match.", player.Name), opponent.Addr, game.conn)
+		game.notifySpectators(player.Name + "'s clock ran out.")
+		game.submit(battle.Action{Side: side, Kind: battle.ActSurrender})
+		return
+	}
+	sendMessageToClient("Your turn timed out! A move was played for you.", player.Addr, game.conn)
+	sendMessageToClient(fmt.Sprintf("%s's turn timed out! A move was played for them.", player.Name), opponent.Addr, game.conn)
+	game.notifySpectators(player.Name + "'s turn timed out.")
+	game.playFor(side, battle.GreedyTrainer{})
+	// A rejected move leaves the turn to them again
+	game.runClocks()
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"pokegame/server/battle"
+	"pokegame/server/tournament"
+)
+
+// The lobby runs one tournament at a time: an admin creates it, players
+// register, and every round's matches start on their own once both
+// players are online, free and have a team. The tournament is saved after
+// every change, so a restart picks it up where it was; a match that was
+// being played when the server stopped is played again.
+
+const tournamentUsage = "(Usage: tournament status|join|leave, admins: tournament create <name> <single|double|swiss> [rounds] [rules], tournament start|cancel, tournament forfeit <player>)"
+
+// loadTournament reads the tournament saved by an earlier run.
+func loadTournament(l *Lobby) {
+	if _, err := os.Stat(config.Storage.Tournament); err != nil {
+		return
+	}
+	t := &tournament.Tournament{}
+	if err := OpenFile(config.Storage.Tournament, t); err != nil {
+		fmt.Println("Error loading the tournament:", err)
+		return
+	}
+	if t.Name == "" {
+		return
+	}
+	for _, p := range t.Current() {
+		p.Match = 0
+	}
+	l.tournament = t
+	fmt.Printf("Tournament %s loaded (%s, round %d).\n", t.Name, t.State, t.Round)
+}
+
+func (l *Lobby) saveTournament() {
+	if l.tournament == nil {
+		saveFile(config.Storage.Tournament, struct{}{})
+		return
+	}
+	saveFile(config.Storage.Tournament, l.tournament)
+}
+
+func (l *Lobby) tournamentCommand(name string, args []string) {
+	p := l.players[name]
+	if p == nil {
+		return
+	}
+	reply := func(msg string) { sendMessageToClient(msg, p.Addr, l.conn) }
+	if len(args) == 0 {
+		reply("Invalid command!\n" + tournamentUsage)
+		return
+	}
+	t := l.tournament
+	admin := containsName(config.Admins, name)
+
+	switch args[0] {
+	case "status":
+		if t == nil {
+			reply("There is no tournament. Admins create one with tournament create.")
+			return
+		}
+		reply(l.describeTournament())
+		return
+	case "create", "start", "cancel", "forfeit":
+		if !admin {
+			reply("Only admins can " + args[0] + " a tournament!")
+			return
+		}
+	}
+
+	if args[0] == "create" {
+		if t != nil && t.State != tournament.Finished {
+			reply("Tournament " + t.Name + " is not over yet. Cancel it first.")
+			return
+		}
+		if len(args) < 3 || len(args) > 5 {
+			reply("Invalid command!\n(Usage: tournament create <name> <single|double|swiss> [rounds] [rules])")
+			return
+		}
+		rounds, ruleName := 0, config.Gameplay.Rules
+		for _, word := range args[3:] {
+			if n, err := strconv.Atoi(word); err == nil {
+				rounds = n
+			} else if _, known := config.Rules[strings.ToLower(word)]; known {
+				ruleName = strings.ToLower(word)
+			} else {
+				reply("Rounds must be a number and rules a rule set! Type rules to see them.")
+				return
+			}
+		}
+		created, err := tournament.New(args[1], tournament.Format(args[2]), rounds)
+		if err != nil {
+			reply("Cannot create the tournament: " + err.Error())
+			return
+		}
+		created.Rules = ruleName
+		l.tournament = created
+		l.rulesWarned = make(map[string]int)
+		l.saveTournament()
+		for _, player := range l.players {
+			sendMessageToClient(fmt.Sprintf("[Tournament] %s (%s, %s rules) is open! Register with tournament join.", created.Name, created.Format, created.Rules), player.Addr, l.conn)
+		}
+		return
+	}
+
+	if t == nil {
+		reply("There is no tournament. Admins create one with tournament create.")
+		return
+	}
+	switch args[0] {
+	case "join":
+		if err := t.Register(name); err != nil {
+			reply("Cannot join " + t.Name + ": " + err.Error() + ".")
+			return
+		}
+		l.saveTournament()
+		l.announce(fmt.Sprintf("%s registered (%d in total).", name, len(t.Players)))
+	case "leave":
+		if err := t.Unregister(name); err != nil {
+			reply("Cannot leave " + t.Name + ": " + err.Error() + ".")
+			return
+		}
+		l.saveTournament()
+		reply("You left " + t.Name + ".")
+	case "start":
+		// Seeds follow the ladder
+		seeds := append([]string(nil), t.Players...)
+		sort.SliceStable(seeds, func(i, j int) bool {
+			return l.standing(seeds[i]).Rating.Rating > l.standing(seeds[j]).Rating.Rating
+		})
+		if err := t.Start(seeds); err != nil {
+			reply("Cannot start " + t.Name + ": " + err.Error() + ".")
+			return
+		}
+		l.saveTournament()
+		l.announceRound()
+		l.startPairings()
+	case "cancel":
+		l.announce(t.Name + " was cancelled.")
+		l.tournament = nil
+		l.saveTournament()
+	case "forfeit":
+		if len(args) != 2 {
+			reply("Invalid command!\n(Usage: tournament forfeit <player>)")
+			return
+		}
+		pairing := t.PairingOf(args[1])
+		if pairing != nil && pairing.Match != 0 {
+			reply(args[1] + " is playing their match. They can surrender it.")
+			return
+		}
+		advanced, err := t.Forfeit(args[1])
+		if err != nil {
+			reply("Cannot forfeit: " + err.Error() + ".")
+			return
+		}
+		l.saveTournament()
+		l.announce(args[1] + " forfeits their match.")
+		l.afterResult(advanced)
+	default:
+		reply("Invalid command!\n" + tournamentUsage)
+	}
+}
+
+// announce tells every registered player who is online.
+func (l *Lobby) announce(msg string) {
+	t := l.tournament
+	for _, name := range t.Players {
+		if p := l.players[name]; p != nil {
+			sendMessageToClient("[Tournament] "+msg, p.Addr, l.conn)
+		}
+	}
+}
+
+func (l *Lobby) announceRound() {
+	t := l.tournament
+	if t.State == tournament.Finished {
+		l.announce(fmt.Sprintf("%s is over! The champion is %s.", t.Name, t.Champion))
+		return
+	}
+	msg := fmt.Sprintf("%s round %d:\n", t.Name, t.Round)
+	for _, p := range t.Current() {
+		msg += describePairing(p) + "\n"
+	}
+	msg += "Matches start once both players are online and have a team."
+	l.announce(msg)
+}
+
+func describePairing(p *tournament.Pairing) string {
+	switch {
+	case p.B == "":
+		return fmt.Sprintf("  %s - bye", p.A)
+	case p.Winner != "":
+		return fmt.Sprintf("  %s vs %s - %s won", p.A, p.B, p.Winner)
+	case p.Match != 0:
+		return fmt.Sprintf("  %s vs %s - playing (match %d)", p.A, p.B, p.Match)
+	}
+	return fmt.Sprintf("  %s vs %s - waiting", p.A, p.B)
+}
+
+func (l *Lobby) describeTournament() string {
+	t := l.tournament
+	msg := fmt.Sprintf("Tournament %s - %s - %s rules - %s\n", t.Name, t.Format, l.tournamentRules(), t.State)
+	if t.State == tournament.Registering {
+		msg += fmt.Sprintf("Registered (%d): %s\n(Usage: tournament join)", len(t.Players), strings.Join(t.Players, ", "))
+		return msg
+	}
+	if t.Champion != "" {
+		msg += "Champion: " + t.Champion + "\n"
+	}
+	msg += "Standings:\n"
+	for i, s := range t.Standings() {
+		line := fmt.Sprintf("%d. %s %dW %dL", i+1, s.Name, s.Wins, s.Losses)
+		if t.Format == tournament.Swiss {
+			line += fmt.Sprintf(" (Buchholz %d)", s.Buchholz)
+		} else if s.Out {
+			line += " (out)"
+		}
+		msg += line + "\n"
+	}
+	if t.State == tournament.Running {
+		round := fmt.Sprintf("Round %d", t.Round)
+		if t.Format == tournament.Swiss {
+			round += fmt.Sprintf(" of %d", t.Rounds)
+		}
+		msg += round + ":\n"
+		for _, p := range t.Current() {
+			msg += describePairing(p) + "\n"
+		}
+	}
+	return msg
+}
+
+// tournamentRules returns the rule set of the tournament. Tournaments saved
+// before they had one, or whose set was since removed, play the default.
+func (l *Lobby) tournamentRules() string {
+	if _, known := config.Rules[l.tournament.Rules]; !known {
+		return config.Gameplay.Rules
+	}
+	return l.tournament.Rules
+}
+
+// startPairings starts every match of the round whose players are ready.
+// It runs whenever that may have changed: a round starts, a match ends, a
+// player comes online or picks a team.
+func (l *Lobby) startPairings() {
+	t := l.tournament
+	if t == nil || t.State != tournament.Running {
+		return
+	}
+	ruleName := l.tournamentRules()
+	ready := func(name string) *Player {
+		p := l.players[name]
+		if p == nil || p.game != nil || p.rival != nil || len(p.team) == 0 {
+			return nil
+		}
+		if err := checkTeam(ruleName, p.team); err != nil {
+			// Told once a round, not every time the pairings are tried
+			if l.rulesWarned[name] != t.Round {
+				l.rulesWarned[name] = t.Round
+				sendMessageToClient(brokenRules(ruleName, err), p.Addr, l.conn)
+			}
+			return nil
+		}
+		return p
+	}
+	for _, pairing := range t.Current() {
+		if pairing.Winner != "" || pairing.Match != 0 {
+			continue
+		}
+		player, opponent := ready(pairing.A), ready(pairing.B)
+		if player == nil || opponent == nil {
+			continue
+		}
+		l.dequeue(player)
+		l.dequeue(opponent)
+		player.rival, opponent.rival = opponent, player
+		player.format, opponent.format = battle.Singles, battle.Singles
+		player.rules, opponent.rules = ruleName, ruleName
+		game := l.newBattle(player, opponent, player.team, opponent.team, player.slots, opponent.slots)
+		game.Tournament = true
+		pairing.Match = game.ID
+		l.saveTournament()
+		round, cup := t.Round, t.Name
+		game.post(func(g *Battle) {
+			for side, p := range g.Players {
+				sendMessageToClient(fmt.Sprintf("You are battling %s in round %d of %s!\n(Usage: attack [normal|special], switch #id, surrender)",
+					g.Players[1-side].Name, round, cup), p.Addr, g.conn)
+			}
+			g.begin()
+		})
+	}
+}
+
+// tournamentResult advances the bracket after a tournament match. A draw
+// is played again.
+func (l *Lobby) tournamentResult(game *Battle, winner int) {
+	t := l.tournament
+	if t == nil || t.State != tournament.Running {
+		return
+	}
+	a, b := game.Players[0].Name, game.Players[1].Name
+	if winner < 0 {
+		if p := t.PairingOf(a); p != nil && p.Has(b) {
+			p.Match = 0
+			l.saveTournament()
+		}
+		l.announce(fmt.Sprintf("%s and %s drew; their match is played again.", a, b))
+		return
+	}
+	advanced, err := t.Report(a, b, game.Players[winner].Name)
+	if err != nil {
+		fmt.Println("Tournament result not recorded:", err)
+		return
+	}
+	l.saveTournament()
+	l.announce(fmt.Sprintf("%s beat %s.", game.Players[winner].Name, game.Players[1-winner].Name))
+	l.afterResult(advanced)
+}
+
+func (l *Lobby) afterResult(advanced bool) {
+	if advanced {
+		l.announceRound()
+	}
+	l.startPairings()
+}
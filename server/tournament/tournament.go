@@ -0,0 +1,465 @@
+// Package tournament runs brackets: single elimination, double elimination
+// and Swiss. It only decides who plays whom and who advances; the server
+// starts the matches and reports their winners. A Tournament is plain data
+// so it can be saved as JSON and picked up again after a restart.
+package tournament
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+type Format string
+
+const (
+	SingleElimination Format = "single"
+	DoubleElimination Format = "double"
+	Swiss             Format = "swiss"
+)
+
+func (f Format) String() string {
+	switch f {
+	case SingleElimination:
+		return "single elimination"
+	case DoubleElimination:
+		return "double elimination"
+	case Swiss:
+		return "Swiss"
+	}
+	return string(f)
+}
+
+const (
+	Registering = "registering"
+	Running     = "running"
+	Finished    = "finished"
+)
+
+type Tournament struct {
+	Name     string    `json:"Name"`
+	Format   Format    `json:"Format"`
+	Rounds   int       `json:"Rounds"` // Swiss only: rounds to play
+	Rules    string    `json:"Rules"`  // rule set of the matches, kept for the server
+	State    string    `json:"State"`
+	Players  []string  `json:"Players"` // in seed order once started
+	Round    int       `json:"Round"`
+	Pairings []Pairing `json:"Pairings"`
+	Champion string    `json:"Champion"`
+}
+
+// Pairing is one match of a round. B is empty for a bye, which A wins
+// without playing.
+type Pairing struct {
+	Round  int    `json:"Round"`
+	A      string `json:"A"`
+	B      string `json:"B"`
+	Winner string `json:"Winner"` // empty while not played
+	Match  int    `json:"Match"`  // live match ID, 0 while not started
+}
+
+func (p Pairing) Has(name string) bool {
+	return p.A == name || p.B == name
+}
+
+// Opponent returns the other player of the pairing.
+func (p Pairing) Opponent(name string) string {
+	if p.A == name {
+		return p.B
+	}
+	return p.A
+}
+
+// New creates a tournament open for registration. rounds is only used by
+// Swiss; 0 picks enough rounds to find a single winner.
+func New(name string, format Format, rounds int) (*Tournament, error) {
+	switch format {
+	case SingleElimination, DoubleElimination, Swiss:
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	if rounds < 0 {
+		return nil, errors.New("rounds cannot be negative")
+	}
+	return &Tournament{Name: name, Format: format, Rounds: rounds, State: Registering}, nil
+}
+
+func (t *Tournament) Registered(name string) bool {
+	for _, p := range t.Players {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Tournament) Register(name string) error {
+	if t.State != Registering {
+		return errors.New("registration is closed")
+	}
+	if t.Registered(name) {
+		return errors.New("already registered")
+	}
+	t.Players = append(t.Players, name)
+	return nil
+}
+
+func (t *Tournament) Unregister(name string) error {
+	if t.State != Registering {
+		return errors.New("the tournament has already started")
+	}
+	for i, p := range t.Players {
+		if p == name {
+			t.Players = append(t.Players[:i], t.Players[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("not registered")
+}
+
+// Start closes registration and creates the first round. seeds holds the
+// registered players, best first.
+func (t *Tournament) Start(seeds []string) error {
+	if t.State != Registering {
+		return errors.New("the tournament has already started")
+	}
+	if len(seeds) != len(t.Players) {
+		return errors.New("the seeds must list every registered player")
+	}
+	for _, name := range seeds {
+		if !t.Registered(name) {
+			return fmt.Errorf("%s is not registered", name)
+		}
+	}
+	if len(seeds) < 2 {
+		return errors.New("at least 2 players must register")
+	}
+	t.Players = seeds
+	if t.Format == Swiss && t.Rounds == 0 {
+		for n := 1; n < len(seeds); n *= 2 {
+			t.Rounds++
+		}
+	}
+	t.State = Running
+	t.advance()
+	return nil
+}
+
+// Current returns the pairings of the round being played.
+func (t *Tournament) Current() []*Pairing {
+	var current []*Pairing
+	for i := range t.Pairings {
+		if t.Pairings[i].Round == t.Round {
+			current = append(current, &t.Pairings[i])
+		}
+	}
+	return current
+}
+
+// PairingOf returns the player's unplayed pairing in the current round.
+func (t *Tournament) PairingOf(name string) *Pairing {
+	for _, p := range t.Current() {
+		if p.Has(name) && p.Winner == "" {
+			return p
+		}
+	}
+	return nil
+}
+
+// Report records the winner of a pairing of the current round. It returns
+// true when that finished the round, in which case the next round has been
+// created or the tournament is over.
+func (t *Tournament) Report(a, b, winner string) (bool, error) {
+	if t.State != Running {
+		return false, errors.New("the tournament is not running")
+	}
+	p := t.PairingOf(a)
+	if p == nil || !p.Has(b) {
+		return false, fmt.Errorf("%s and %s do not play each other this round", a, b)
+	}
+	if winner != a && winner != b {
+		return false, fmt.Errorf("%s does not play in this pairing", winner)
+	}
+	p.Winner = winner
+	p.Match = 0
+	if !t.roundOver() {
+		return false, nil
+	}
+	t.advance()
+	return true, nil
+}
+
+func (t *Tournament) roundOver() bool {
+	for _, p := range t.Current() {
+		if p.Winner == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// advance creates rounds until one has a match to play or the tournament
+// is over. A round of byes alone is skipped right away.
+func (t *Tournament) advance() {
+	for t.roundOver() {
+		if t.Round > 0 && t.finish() {
+			return
+		}
+		t.nextRound()
+	}
+}
+
+// Forfeit gives the player's current pairing to their opponent.
+func (t *Tournament) Forfeit(name string) (bool, error) {
+	p := t.PairingOf(name)
+	if p == nil {
+		return false, fmt.Errorf("%s has no match to play this round", name)
+	}
+	opponent := p.Opponent(name)
+	return t.Report(name, opponent, opponent)
+}
+
+// lives is how many losses knock a player out of an elimination bracket.
+func (t *Tournament) lives() int {
+	if t.Format == DoubleElimination {
+		return 2
+	}
+	return 1
+}
+
+func (t *Tournament) record(name string) (wins, losses int) {
+	for _, p := range t.Pairings {
+		if !p.Has(name) || p.Winner == "" {
+			continue
+		}
+		if p.Winner == name {
+			wins++
+		} else {
+			losses++
+		}
+	}
+	return wins, losses
+}
+
+// Out reports whether the player was knocked out of an elimination bracket.
+func (t *Tournament) Out(name string) bool {
+	if t.Format == Swiss {
+		return false
+	}
+	_, losses := t.record(name)
+	return losses >= t.lives()
+}
+
+// finish ends the tournament once a champion is known.
+func (t *Tournament) finish() bool {
+	if t.Format == Swiss {
+		if t.Round < t.Rounds {
+			return false
+		}
+		t.Champion = t.Standings()[0].Name
+	} else {
+		var alive []string
+		for _, name := range t.Players {
+			if !t.Out(name) {
+				alive = append(alive, name)
+			}
+		}
+		if len(alive) > 1 {
+			return false
+		}
+		t.Champion = alive[0]
+	}
+	t.State = Finished
+	return true
+}
+
+func (t *Tournament) nextRound() {
+	t.Round++
+	var pairs [][2]string
+	if t.Format == Swiss {
+		pairs = t.swissPairs()
+	} else {
+		pairs = t.eliminationPairs()
+	}
+	for _, pair := range pairs {
+		p := Pairing{Round: t.Round, A: pair[0], B: pair[1]}
+		if p.B == "" {
+			p.Winner = p.A
+		}
+		t.Pairings = append(t.Pairings, p)
+	}
+}
+
+// eliminationPairs pairs the players left by how many losses they have, so
+// a double elimination runs a winners and a losers bracket side by side.
+// Each bracket is reseeded every round: the best seed meets the worst and
+// an odd player out, the best seed, gets a bye. The last player of the
+// winners bracket meets the last of the losers bracket in the grand final,
+// which is played again if the winners bracket player loses it.
+func (t *Tournament) eliminationPairs() [][2]string {
+	pools := make([][]string, t.lives())
+	for _, name := range t.Players {
+		if _, losses := t.record(name); losses < t.lives() {
+			pools[losses] = append(pools[losses], name)
+		}
+	}
+	if len(pools) == 2 && len(pools[0]) == 1 && len(pools[1]) == 1 {
+		return [][2]string{{pools[0][0], pools[1][0]}}
+	}
+	var pairs [][2]string
+	for _, pool := range pools {
+		if len(pool)%2 == 1 {
+			pairs = append(pairs, [2]string{pool[0], ""})
+			pool = pool[1:]
+		}
+		for i := 0; i < len(pool)/2; i++ {
+			pairs = append(pairs, [2]string{pool[i], pool[len(pool)-1-i]})
+		}
+	}
+	return pairs
+}
+
+// swissPairs pairs players with the same score, best first, avoiding
+// rematches where possible. With an odd count the lowest ranked player
+// without a bye yet sits out and scores a win.
+func (t *Tournament) swissPairs() [][2]string {
+	var order []string
+	for _, s := range t.Standings() {
+		order = append(order, s.Name)
+	}
+	if len(order)%2 == 0 {
+		if pairs := t.pairUp(order, new(int)); pairs != nil {
+			return pairs
+		}
+		return t.greedyPairs(order)
+	}
+	// The bye goes to the lowest ranked player who has not had one and
+	// leaves the others a pairing without rematches
+	var byes []int
+	for i := len(order) - 1; i >= 0; i-- {
+		if !t.played(order[i], "") {
+			byes = append(byes, i)
+		}
+	}
+	if len(byes) == 0 {
+		byes = []int{len(order) - 1}
+	}
+	steps := 0
+	for _, bye := range byes {
+		rest := append(order[:bye:bye], order[bye+1:]...)
+		if pairs := t.pairUp(rest, &steps); pairs != nil {
+			return append([][2]string{{order[bye], ""}}, pairs...)
+		}
+	}
+	bye := byes[0]
+	rest := append(order[:bye:bye], order[bye+1:]...)
+	return append([][2]string{{order[bye], ""}}, t.greedyPairs(rest)...)
+}
+
+// pairSteps bounds the search for a pairing without rematches.
+const pairSteps = 10000
+
+// pairUp pairs each player with the best placed player they have not met,
+// going back on earlier choices when that leaves someone without a new
+// opponent. It returns nil when every pairing has a rematch or the search
+// takes too long.
+func (t *Tournament) pairUp(order []string, steps *int) [][2]string {
+	if len(order) == 0 {
+		return [][2]string{}
+	}
+	a := order[0]
+	for k := 1; k < len(order); k++ {
+		if *steps++; *steps > pairSteps {
+			return nil
+		}
+		if t.played(a, order[k]) {
+			continue
+		}
+		rest := append(order[1:k:k], order[k+1:]...)
+		if pairs := t.pairUp(rest, steps); pairs != nil {
+			return append([][2]string{{a, order[k]}}, pairs...)
+		}
+	}
+	return nil
+}
+
+// greedyPairs pairs each player with the best placed one they have not
+// met, or the next one when they met everyone.
+func (t *Tournament) greedyPairs(order []string) [][2]string {
+	var pairs [][2]string
+	for len(order) > 0 {
+		a := order[0]
+		j := 1
+		for k := 1; k < len(order); k++ {
+			if !t.played(a, order[k]) {
+				j = k
+				break
+			}
+		}
+		pairs = append(pairs, [2]string{a, order[j]})
+		order = append(order[1:j:j], order[j+1:]...)
+	}
+	return pairs
+}
+
+// played reports whether a met b already; b "" asks about a bye.
+func (t *Tournament) played(a, b string) bool {
+	for _, p := range t.Pairings {
+		if p.A == a && p.B == b || b != "" && p.A == b && p.B == a {
+			return true
+		}
+	}
+	return false
+}
+
+// Standing is a player's line in the standings.
+type Standing struct {
+	Name     string
+	Wins     int
+	Losses   int
+	Buchholz int  // Swiss tiebreak: the wins of every opponent met
+	Out      bool // knocked out of an elimination bracket
+}
+
+// Standings ranks the players: by wins then Buchholz for Swiss, the
+// champion and players still in first for eliminations, then seed.
+func (t *Tournament) Standings() []Standing {
+	seed := make(map[string]int)
+	var standings []Standing
+	for i, name := range t.Players {
+		seed[name] = i
+		wins, losses := t.record(name)
+		standings = append(standings, Standing{Name: name, Wins: wins, Losses: losses, Out: t.Out(name)})
+	}
+	wins := make(map[string]int)
+	for _, s := range standings {
+		wins[s.Name] = s.Wins
+	}
+	for i := range standings {
+		for _, p := range t.Pairings {
+			if p.Has(standings[i].Name) && p.B != "" && p.Winner != "" {
+				standings[i].Buchholz += wins[p.Opponent(standings[i].Name)]
+			}
+		}
+	}
+	sort.SliceStable(standings, func(i, j int) bool {
+		a, b := standings[i], standings[j]
+		if t.Champion != "" && (a.Name == t.Champion) != (b.Name == t.Champion) {
+			return a.Name == t.Champion
+		}
+		if a.Out != b.Out {
+			return !a.Out
+		}
+		if a.Wins != b.Wins {
+			return a.Wins > b.Wins
+		}
+		if t.Format == Swiss && a.Buchholz != b.Buchholz {
+			return a.Buchholz > b.Buchholz
+		}
+		if a.Losses != b.Losses {
+			return a.Losses < b.Losses
+		}
+		return seed[a.Name] < seed[b.Name]
+	})
+	return standings
+}
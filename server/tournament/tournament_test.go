@@ -0,0 +1,216 @@
+package tournament
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func seeds(n int) []string {
+	var names []string
+	for i := 1; i <= n; i++ {
+		names = append(names, fmt.Sprintf("p%d", i))
+	}
+	return names
+}
+
+func start(t *testing.T, format Format, rounds, players int) *Tournament {
+	t.Helper()
+	tour, err := New("cup", format, rounds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range seeds(players) {
+		if err := tour.Register(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tour.Start(seeds(players)); err != nil {
+		t.Fatal(err)
+	}
+	return tour
+}
+
+// better is the lower seed number, which wins unless a test says otherwise.
+func better(p Pairing) string {
+	if p.A < p.B {
+		return p.A
+	}
+	return p.B
+}
+
+// play reports a winner for every pairing until the tournament is over and
+// returns the rounds as "A-B" strings, "A bye" for byes.
+func play(t *testing.T, tour *Tournament, pick func(p Pairing) string) [][]string {
+	t.Helper()
+	var rounds [][]string
+	for round := 0; tour.State == Running; round++ {
+		if round > 20 {
+			t.Fatal("the tournament never ends")
+		}
+		var names []string
+		var open []Pairing
+		for _, p := range tour.Current() {
+			if p.B == "" {
+				names = append(names, p.A+" bye")
+				continue
+			}
+			names = append(names, p.A+"-"+p.B)
+			open = append(open, *p)
+		}
+		rounds = append(rounds, names)
+		for _, p := range open {
+			if _, err := tour.Report(p.A, p.B, pick(p)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return rounds
+}
+
+func TestElimination(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   Format
+		players  int
+		pick     func(p Pairing) string
+		rounds   [][]string
+		champion string
+	}{
+		{
+			name: "single of four", format: SingleElimination, players: 4, pick: better,
+			rounds:   [][]string{{"p1-p4", "p2-p3"}, {"p1-p2"}},
+			champion: "p1",
+		},
+		{
+			name: "single with byes", format: SingleElimination, players: 5, pick: better,
+			rounds:   [][]string{{"p1 bye", "p2-p5", "p3-p4"}, {"p1 bye", "p2-p3"}, {"p1-p2"}},
+			champion: "p1",
+		},
+		{
+			name: "single upset", format: SingleElimination, players: 4,
+			pick: func(p Pairing) string {
+				if p.Has("p4") {
+					return "p4"
+				}
+				return better(p)
+			},
+			rounds:   [][]string{{"p1-p4", "p2-p3"}, {"p2-p4"}},
+			champion: "p4",
+		},
+		{
+			name: "double won by the winners bracket", format: DoubleElimination, players: 4, pick: better,
+			rounds:   [][]string{{"p1-p4", "p2-p3"}, {"p1-p2", "p3-p4"}, {"p1 bye", "p2-p3"}, {"p1-p2"}},
+			champion: "p1",
+		},
+		{
+			// p2 comes through the losers bracket and wins the grand final,
+			// so it is played again
+			name: "double with a bracket reset", format: DoubleElimination, players: 4,
+			pick: func() func(p Pairing) string {
+				finals := 0
+				return func(p Pairing) string {
+					if p.Has("p1") && p.Has("p2") {
+						if finals++; finals == 2 {
+							return "p2"
+						}
+					}
+					return better(p)
+				}
+			}(),
+			rounds:   [][]string{{"p1-p4", "p2-p3"}, {"p1-p2", "p3-p4"}, {"p1 bye", "p2-p3"}, {"p1-p2"}, {"p1-p2"}},
+			champion: "p1",
+		},
+		{
+			name: "double with byes", format: DoubleElimination, players: 3, pick: better,
+			rounds:   [][]string{{"p1 bye", "p2-p3"}, {"p1-p2", "p3 bye"}, {"p1 bye", "p2-p3"}, {"p1-p2"}},
+			champion: "p1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tour := start(t, tt.format, 0, tt.players)
+			rounds := play(t, tour, tt.pick)
+			if !reflect.DeepEqual(rounds, tt.rounds) {
+				t.Errorf("rounds %q, want %q", rounds, tt.rounds)
+			}
+			if tour.State != Finished || tour.Champion != tt.champion {
+				t.Errorf("state %s, champion %q, want %q", tour.State, tour.Champion, tt.champion)
+			}
+			if standings := tour.Standings(); standings[0].Name != tt.champion {
+				t.Errorf("standings lead with %s", standings[0].Name)
+			}
+		})
+	}
+}
+
+func TestSwiss(t *testing.T) {
+	tests := []struct {
+		players, rounds, want int
+	}{
+		{players: 8, want: 3},
+		{players: 6, rounds: 5, want: 5},
+		{players: 5, want: 3},
+		{players: 7, rounds: 4, want: 4},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d players", tt.players), func(t *testing.T) {
+			tour := start(t, Swiss, tt.rounds, tt.players)
+			rounds := play(t, tour, better)
+			if len(rounds) != tt.want {
+				t.Fatalf("played %d rounds, want %d", len(rounds), tt.want)
+			}
+			met := make(map[[2]string]bool)
+			for _, p := range tour.Pairings {
+				pair := [2]string{p.A, p.B}
+				if p.B != "" && p.B < p.A {
+					pair = [2]string{p.B, p.A}
+				}
+				if met[pair] {
+					t.Errorf("round %d: %s and %q met again", p.Round, p.A, p.B)
+				}
+				met[pair] = true
+			}
+			for round, names := range rounds {
+				if len(names) != (tt.players+1)/2 {
+					t.Errorf("round %d has %d pairings: %q", round+1, len(names), names)
+				}
+			}
+			if tour.Champion != "p1" {
+				t.Errorf("champion %q, want p1", tour.Champion)
+			}
+		})
+	}
+}
+
+func TestSwissPairsEqualScores(t *testing.T) {
+	tour := start(t, Swiss, 2, 4)
+	play(t, tour, better)
+	var second []string
+	for _, p := range tour.Pairings {
+		if p.Round == 2 {
+			second = append(second, p.A+"-"+p.B)
+		}
+	}
+	// Round one is p1-p2 and p3-p4: the winners p1 and p3 meet next
+	if want := []string{"p1-p3", "p2-p4"}; !reflect.DeepEqual(second, want) {
+		t.Errorf("second round %q, want %q", second, want)
+	}
+}
+
+func TestForfeitAndErrors(t *testing.T) {
+	tour := start(t, SingleElimination, 0, 2)
+	if _, err := tour.Report("p1", "p3", "p1"); err == nil {
+		t.Error("reported a pairing that does not exist")
+	}
+	if err := tour.Register("p3"); err == nil {
+		t.Error("registered after the start")
+	}
+	over, err := tour.Forfeit("p1")
+	if err != nil || !over || tour.Champion != "p2" {
+		t.Errorf("forfeit: over %v, err %v, champion %q", over, err, tour.Champion)
+	}
+	if _, err := New("cup", "league", 0); err == nil {
+		t.Error("created a tournament of an unknown format")
+	}
+}